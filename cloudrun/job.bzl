@@ -16,6 +16,11 @@ def cloudrun_job(
         configs = [],
         config_format = "apphosting.*.yaml",
         project_id = "",
+        policy = None,
+        env_files = [],
+        project_number = "",
+        run_on_deploy = False,
+        require_digest = False,
         **kwargs):
     """Generates Cloud Run Job manifests and deploy targets.
 
@@ -37,6 +42,19 @@ def cloudrun_job(
         configs: List of env-specific configs.
         config_format: Filename pattern for env extraction.
         project_id: GCP project ID. Use {} for env substitution.
+        policy: Optional policy rules file evaluated against every rendered
+            manifest. Violations fail the build.
+        env_files: Files referenced by envFrom in the configs, tracked as
+            render inputs. Paths in envFrom are relative to each config.
+        project_number: Optional deploy project number, used for dependency
+            URLs and cross-project secret aliases. Overrides projectNumber in
+            the configs.
+        run_on_deploy: Execute the job after every deploy and wait for the
+            execution to finish; the deploy fails when a task fails. Pass
+            --no-execute to the .deploy target to skip it.
+        require_digest: Fail the render unless the image is pinned by
+            digest, rejecting tag-only and placeholder images. Always on
+            with image_repo.
         **kwargs: Additional attributes.
     """
     if not job_name:
@@ -76,6 +94,10 @@ def cloudrun_job(
             image = resolved_image,
             image_repo = resolved_image_repo,
             image_digest = image_digest,
+            policy = policy,
+            env_files = env_files,
+            project_number = project_number,
+            require_digest = require_digest,
             resource_type = "job",
             visibility = visibility,
             tags = tags,
@@ -83,10 +105,12 @@ def cloudrun_job(
         cloudrun_deploy_target(
             name = name + ".deploy",
             manifest = ":" + name + ".render",
+            iam_policy = ":" + name + ".render.iam.yaml",
             project_id = project_id,
             push_executable = push_executable,
             regions = [region],
             resource_type = "job",
+            run_on_deploy = run_on_deploy,
             service_name = job_name,
             visibility = visibility,
             tags = tags + ["cloudrun_deploy"],
@@ -103,11 +127,16 @@ def cloudrun_job(
             name = target_name + ".render",
             config = cfg,
             base_config = base_config,
+            environment = env,
             service_name = job_name,
             region = region,
             image = resolved_image,
             image_repo = resolved_image_repo,
             image_digest = image_digest,
+            policy = policy,
+            env_files = env_files,
+            project_number = project_number,
+            require_digest = require_digest,
             resource_type = "job",
             visibility = visibility,
             tags = tags,
@@ -115,10 +144,12 @@ def cloudrun_job(
         cloudrun_deploy_target(
             name = target_name + ".deploy",
             manifest = ":" + target_name + ".render",
+            iam_policy = ":" + target_name + ".render.iam.yaml",
             project_id = resolved_project,
             push_executable = push_executable,
             regions = [region],
             resource_type = "job",
+            run_on_deploy = run_on_deploy,
             service_name = job_name,
             visibility = visibility,
             tags = tags + ["cloudrun_deploy"],
@@ -0,0 +1,242 @@
+// Package manifest builds Cloud Run manifests from apphosting configuration.
+//
+// It is the supported Go entry point into rules_cloudrun: parse a config with
+// ParseConfig, build a typed resource with BuildService, BuildJob, or
+// BuildWorkerPool, post-process it as needed, and serialize it with Marshal.
+package manifest
+
+import (
+	"fmt"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Config is a merged apphosting configuration file.
+type Config struct {
+	RunConfig         RunConfig      `yaml:"runConfig"`
+	Env               []Env          `yaml:"env"`
+	EnvFrom           []EnvSource    `yaml:"envFrom"`
+	SecretVolumes     []SecretVolume `yaml:"secretVolumes"`
+	Dependencies      []Dependency   `yaml:"dependencies"`
+	Domains           []string       `yaml:"domains"`
+	LoadBalancer      *LoadBalancer  `yaml:"loadBalancer"`
+	IAM               *IAM           `yaml:"iam"`
+	Deploy            *Deploy        `yaml:"deploy"`
+	Rollout           *Rollout       `yaml:"rollout"`
+	Security          *Security      `yaml:"security"`
+	ServiceAccount    string         `yaml:"serviceAccount"`
+	CloudSQLConnector string         `yaml:"cloudsqlConnector"`
+	// ProjectNumber is the number of the project the resource is deployed
+	// to. When set, secrets from other projects are referenced through
+	// aliases instead of by their short name.
+	ProjectNumber string `yaml:"projectNumber"`
+}
+
+// RunConfig holds the runtime settings under the runConfig key. Fields that
+// do not apply to a resource type are ignored by its builder.
+type RunConfig struct {
+	CPU            *int   `yaml:"cpu"`
+	MemoryMiB      *int   `yaml:"memoryMiB"`
+	MinInstances   *int   `yaml:"minInstances"`
+	MaxInstances   *int   `yaml:"maxInstances"`
+	Concurrency    *int   `yaml:"concurrency"`
+	Ingress        string `yaml:"ingress"`
+	Mesh           string `yaml:"mesh"`
+	Network        string `yaml:"network"`
+	Subnet         string `yaml:"subnet"`
+	VPCConnector   string `yaml:"vpcConnector"`
+	VPCEgress      string `yaml:"vpcEgress"`
+	TaskCount      *int   `yaml:"taskCount"`
+	Parallelism    *int   `yaml:"parallelism"`
+	MaxRetries     *int   `yaml:"maxRetries"`
+	TimeoutSeconds *int   `yaml:"timeoutSeconds"`
+	LivenessProbe  *Probe `yaml:"livenessProbe"`
+	ReadinessProbe *Probe `yaml:"readinessProbe"`
+	StartupProbe   *Probe `yaml:"startupProbe"`
+	// Command replaces the image entrypoint and Args its arguments.
+	Command    []string `yaml:"command"`
+	Args       []string `yaml:"args"`
+	WorkingDir string   `yaml:"workingDir"`
+	Port       *Port    `yaml:"port"`
+	// Scaling refines the service autoscaling bounds; MinInstances and
+	// MaxInstances are shorthands for its service-level ones.
+	Scaling *Scaling `yaml:"scaling"`
+	// ScalingMode, InstanceCount, and InstanceSplits apply to worker pools
+	// only; see BuildWorkerPool.
+	ScalingMode    string          `yaml:"scalingMode"`
+	InstanceCount  *int            `yaml:"instanceCount"`
+	InstanceSplits []InstanceSplit `yaml:"instanceSplits"`
+}
+
+// Scaling bounds the instances of a service. MinInstances and MaxInstances
+// apply to the service as a whole, across every revision that serves
+// traffic; RevisionMinInstances and RevisionMaxInstances apply to each
+// revision. CPUUtilization is the CPU usage, in percent, autoscaling aims
+// for.
+type Scaling struct {
+	MinInstances         *int `yaml:"minInstances"`
+	MaxInstances         *int `yaml:"maxInstances"`
+	RevisionMinInstances *int `yaml:"revisionMinInstances"`
+	RevisionMaxInstances *int `yaml:"revisionMaxInstances"`
+	CPUUtilization       *int `yaml:"cpuUtilization"`
+}
+
+// InstanceSplit assigns Percent of a worker pool's instances to a revision,
+// named by Revision or, with Latest, the newest ready revision.
+type InstanceSplit struct {
+	Revision string `yaml:"revision"`
+	Latest   bool   `yaml:"latest"`
+	Percent  int    `yaml:"percent"`
+}
+
+// Port is the port a service container listens on. Cloud Run sends
+// requests to it as HTTP/1 or, with Name h2c, as end-to-end HTTP/2.
+type Port struct {
+	Number *int32 `yaml:"number"`
+	Name   string `yaml:"name"`
+}
+
+// Probe is a liveness, readiness, or startup probe. At most one of HTTPGet,
+// TCPSocket, and GRPC is used, in that order of precedence.
+type Probe struct {
+	InitialDelaySeconds *int32          `yaml:"initialDelaySeconds"`
+	TimeoutSeconds      *int32          `yaml:"timeoutSeconds"`
+	PeriodSeconds       *int32          `yaml:"periodSeconds"`
+	SuccessThreshold    *int32          `yaml:"successThreshold"`
+	FailureThreshold    *int32          `yaml:"failureThreshold"`
+	HTTPGet             *HTTPGetProbe   `yaml:"httpGet"`
+	TCPSocket           *TCPSocketProbe `yaml:"tcpSocket"`
+	GRPC                *GRPCProbe      `yaml:"grpc"`
+}
+
+// HTTPGetProbe checks an HTTP endpoint.
+type HTTPGetProbe struct {
+	Path        string       `yaml:"path"`
+	Port        *int32       `yaml:"port"`
+	HTTPHeaders []HTTPHeader `yaml:"httpHeaders"`
+}
+
+// HTTPHeader is a header sent with an HTTP probe.
+type HTTPHeader struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
+}
+
+// TCPSocketProbe checks that a TCP port accepts connections.
+type TCPSocketProbe struct {
+	Port *int32 `yaml:"port"`
+}
+
+// GRPCProbe calls the gRPC health checking protocol.
+type GRPCProbe struct {
+	Port    *int32 `yaml:"port"`
+	Service string `yaml:"service"`
+}
+
+// Env is an environment variable set from a literal Value or a Secret
+// Manager reference of the form projects/<num>/secrets/<name>. Value is a
+// pointer so that an intentionally empty value (`value: ""`) is kept.
+// Version pins the secret version and defaults to latest.
+type Env struct {
+	Variable       string  `yaml:"variable"`
+	Value          *string `yaml:"value"`
+	Secret         string  `yaml:"secret"`
+	Version        string  `yaml:"version"`
+	AllowPlaintext bool    `yaml:"allowPlaintext"`
+}
+
+// EnvSource imports environment variables from a dotenv file or a YAML env
+// list. The builders in this package ignore EnvFrom; callers resolve the
+// sources into Env before building.
+type EnvSource struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
+}
+
+// SecretVolume mounts one version of a Secret Manager secret as a file.
+// The file is named FileName, which defaults to the secret's short name,
+// inside the MountPath directory. Version defaults to latest.
+type SecretVolume struct {
+	MountPath string `yaml:"mountPath"`
+	Secret    string `yaml:"secret"`
+	Version   string `yaml:"version"`
+	FileName  string `yaml:"fileName"`
+}
+
+// Dependency names another Cloud Run service whose URL is injected as an
+// env variable; see ResolveDependencies.
+type Dependency struct {
+	Service  string `yaml:"service"`
+	Variable string `yaml:"variable"`
+	Region   string `yaml:"region"`
+}
+
+// LoadBalancer configures the global external load balancer that fronts a
+// service in every region. Domains get a Google-managed certificate and an
+// HTTPS listener; without domains the load balancer serves plain HTTP.
+type LoadBalancer struct {
+	Domains []string `yaml:"domains"`
+}
+
+// Security holds the Binary Authorization and customer-managed encryption
+// settings of a resource; see BuildService, BuildJob, and BuildWorkerPool.
+type Security struct {
+	// BinaryAuthorization is "default" for the project's default policy or
+	// the name of a platform policy. BreakglassJustification deploys an
+	// image the policy would reject and records why.
+	BinaryAuthorization     string `yaml:"binaryAuthorization"`
+	BreakglassJustification string `yaml:"breakglassJustification"`
+	// EncryptionKey is the Cloud KMS key that encrypts the container image.
+	// KeyRevocationAction, prevent-new or shutdown, is what Cloud Run does
+	// once the key is revoked; shutdown stops running instances after
+	// KeyShutdownHours.
+	EncryptionKey       string `yaml:"encryptionKey"`
+	KeyRevocationAction string `yaml:"keyRevocationAction"`
+	KeyShutdownHours    *int   `yaml:"keyShutdownHours"`
+}
+
+// IAM lists the principals granted access to a service or job on deploy.
+type IAM struct {
+	// Invokers are granted roles/run.invoker: they may call the service or
+	// run the job.
+	Invokers []string `yaml:"invokers"`
+}
+
+// ValidateEnv checks that every entry names a variable, sets exactly one of
+// value and secret, pins a valid secret version if any, and that no variable
+// is defined twice. Duplicates typically come from merging a base config
+// with an overlay.
+func ValidateEnv(entries []Env) error {
+	seen := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		if entry.Variable == "" {
+			return fmt.Errorf("env[%d] is missing 'variable'", i)
+		}
+		if entry.Value != nil && entry.Secret != "" {
+			return fmt.Errorf("env[%d] %q has both 'value' and 'secret'; set exactly one", i, entry.Variable)
+		}
+		if entry.Value == nil && entry.Secret == "" {
+			return fmt.Errorf("env[%d] %q has neither 'value' nor 'secret'; use value: \"\" for an empty variable", i, entry.Variable)
+		}
+		if entry.Version != "" && entry.Secret == "" {
+			return fmt.Errorf("env[%d] %q sets 'version' without 'secret'", i, entry.Variable)
+		}
+		if err := validateSecretVersion(entry.Version); err != nil {
+			return fmt.Errorf("env[%d] %q: %w", i, entry.Variable, err)
+		}
+		if first, duplicate := seen[entry.Variable]; duplicate {
+			return fmt.Errorf("env %q is defined more than once (env[%d] and env[%d])", entry.Variable, first, i)
+		}
+		seen[entry.Variable] = i
+	}
+	return nil
+}
+
+// ParseConfig decodes an apphosting YAML document.
+func ParseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := yamlv3.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("parse config yaml: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,185 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Port names accepted in runConfig.port.name.
+const (
+	PortNameHTTP1 = "http1"
+	PortNameH2C   = "h2c"
+)
+
+// DefaultPort is the container port of a runConfig.port without a number,
+// the port Cloud Run uses when none is declared.
+const DefaultPort int32 = 8080
+
+// Shared shapes of the run.googleapis.com/v1 Job and WorkerPool manifests.
+// Services use the upstream Knative types; env vars and secret volumes use
+// core/v1 types for every resource type so their rendering stays identical.
+
+// ObjectMeta is the subset of Kubernetes object metadata Cloud Run reads.
+type ObjectMeta struct {
+	Name        string            `json:"name,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Container is a container in a job task or worker pool revision.
+type Container struct {
+	Image          string               `json:"image"`
+	Command        []string             `json:"command,omitempty"`
+	Args           []string             `json:"args,omitempty"`
+	WorkingDir     string               `json:"workingDir,omitempty"`
+	Resources      ResourceRequirements `json:"resources"`
+	Env            []corev1.EnvVar      `json:"env,omitempty"`
+	VolumeMounts   []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	LivenessProbe  *ContainerProbe      `json:"livenessProbe,omitempty"`
+	ReadinessProbe *ContainerProbe      `json:"readinessProbe,omitempty"`
+	StartupProbe   *ContainerProbe      `json:"startupProbe,omitempty"`
+}
+
+// ResourceRequirements holds container resource limits such as cpu and
+// memory.
+type ResourceRequirements struct {
+	Limits map[string]string `json:"limits"`
+}
+
+// ContainerProbe is the rendered form of a Probe.
+type ContainerProbe struct {
+	InitialDelaySeconds *int32           `json:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      *int32           `json:"timeoutSeconds,omitempty"`
+	PeriodSeconds       *int32           `json:"periodSeconds,omitempty"`
+	SuccessThreshold    *int32           `json:"successThreshold,omitempty"`
+	FailureThreshold    *int32           `json:"failureThreshold,omitempty"`
+	HTTPGet             *HTTPGetAction   `json:"httpGet,omitempty"`
+	TCPSocket           *TCPSocketAction `json:"tcpSocket,omitempty"`
+	GRPC                *GRPCAction      `json:"grpc,omitempty"`
+}
+
+// HTTPGetAction is the rendered form of an HTTPGetProbe.
+type HTTPGetAction struct {
+	Path        string       `json:"path,omitempty"`
+	Port        *int32       `json:"port,omitempty"`
+	HTTPHeaders []HTTPHeader `json:"httpHeaders,omitempty"`
+}
+
+// TCPSocketAction is the rendered form of a TCPSocketProbe.
+type TCPSocketAction struct {
+	Port *int32 `json:"port,omitempty"`
+}
+
+// GRPCAction is the rendered form of a GRPCProbe.
+type GRPCAction struct {
+	Port    *int32 `json:"port,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+func buildResourceLimits(config Config) ResourceRequirements {
+	cpu := DefaultCPU
+	if config.RunConfig.CPU != nil {
+		cpu = *config.RunConfig.CPU
+	}
+	memoryMiB := DefaultMemoryMiB
+	if config.RunConfig.MemoryMiB != nil {
+		memoryMiB = *config.RunConfig.MemoryMiB
+	}
+	return ResourceRequirements{
+		Limits: map[string]string{
+			"cpu":    fmt.Sprintf("%dm", cpu*1000),
+			"memory": fmt.Sprintf("%dMi", memoryMiB),
+		},
+	}
+}
+
+// validateContainer checks the container settings of runConfig. Only
+// services accept a port.
+func validateContainer(runConfig RunConfig, resourceType string) error {
+	if runConfig.WorkingDir != "" && !path.IsAbs(runConfig.WorkingDir) {
+		return fmt.Errorf("runConfig.workingDir must be an absolute path, got %q", runConfig.WorkingDir)
+	}
+	if len(runConfig.Command) > 0 && runConfig.Command[0] == "" {
+		return errors.New("runConfig.command must name a program")
+	}
+	port := runConfig.Port
+	if port == nil {
+		return nil
+	}
+	if resourceType != "service" {
+		return fmt.Errorf("runConfig.port is only supported for services, not %ss", resourceType)
+	}
+	if port.Number != nil && (*port.Number < 1 || *port.Number > 65535) {
+		return fmt.Errorf("runConfig.port.number must be between 1 and 65535, got %d", *port.Number)
+	}
+	switch port.Name {
+	case "", PortNameHTTP1, PortNameH2C:
+	default:
+		return fmt.Errorf("runConfig.port.name must be %q or %q, got %q", PortNameHTTP1, PortNameH2C, port.Name)
+	}
+	return nil
+}
+
+// buildContainerPorts returns the declared port of a service container.
+func buildContainerPorts(port *Port) []corev1.ContainerPort {
+	if port == nil {
+		return nil
+	}
+	number := DefaultPort
+	if port.Number != nil {
+		number = *port.Number
+	}
+	return []corev1.ContainerPort{{Name: port.Name, ContainerPort: number}}
+}
+
+func buildNetworkAnnotations(config Config, annotations map[string]string) {
+	if config.CloudSQLConnector != "" {
+		annotations["run.googleapis.com/cloudsql-instances"] = config.CloudSQLConnector
+	}
+	if config.RunConfig.Network != "" && config.RunConfig.Subnet != "" {
+		annotations["run.googleapis.com/network-interfaces"] = fmt.Sprintf(
+			`[{"network":"%s","subnetwork":"%s"}]`,
+			config.RunConfig.Network,
+			config.RunConfig.Subnet,
+		)
+	}
+	if config.RunConfig.VPCConnector != "" {
+		annotations["run.googleapis.com/vpc-access-connector"] = config.RunConfig.VPCConnector
+	}
+	if config.RunConfig.VPCEgress != "" {
+		annotations["run.googleapis.com/vpc-access-egress"] = config.RunConfig.VPCEgress
+	}
+}
+
+func buildContainerProbe(p *Probe) *ContainerProbe {
+	if p == nil {
+		return nil
+	}
+	probe := &ContainerProbe{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+
+	if p.HTTPGet != nil {
+		probe.HTTPGet = &HTTPGetAction{
+			Path:        p.HTTPGet.Path,
+			Port:        p.HTTPGet.Port,
+			HTTPHeaders: p.HTTPGet.HTTPHeaders,
+		}
+	} else if p.TCPSocket != nil {
+		probe.TCPSocket = &TCPSocketAction{
+			Port: p.TCPSocket.Port,
+		}
+	} else if p.GRPC != nil {
+		probe.GRPC = &GRPCAction{
+			Port:    p.GRPC.Port,
+			Service: p.GRPC.Service,
+		}
+	}
+	return probe
+}
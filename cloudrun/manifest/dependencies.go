@@ -0,0 +1,98 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// meshAnnotation attaches a revision to a Cloud Service Mesh.
+const meshAnnotation = "run.googleapis.com/mesh"
+
+var (
+	serviceNamePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+	meshPattern        = regexp.MustCompile(`^projects/[^/]+/locations/global/meshes/[^/]+$`)
+	regionPattern      = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+)
+
+// DependencyURL returns the URL a dependency is reached at: the mesh
+// hostname http://<service>.run.app when a mesh is configured, and the
+// deterministic https://<service>-<project-number>.<region>.run.app URL
+// otherwise.
+func DependencyURL(dependency Dependency, projectNumber, region, mesh string) string {
+	if mesh != "" {
+		return fmt.Sprintf("http://%s.run.app", dependency.Service)
+	}
+	if dependency.Region != "" {
+		region = dependency.Region
+	}
+	return fmt.Sprintf("https://%s-%s.%s.run.app", dependency.Service, projectNumber, region)
+}
+
+// DependencyVariable returns the env variable a dependency's URL is injected
+// as: Variable if set, otherwise the upper-cased service name with a _URL
+// suffix (user-api -> USER_API_URL).
+func DependencyVariable(dependency Dependency) string {
+	if dependency.Variable != "" {
+		return dependency.Variable
+	}
+	return strings.ToUpper(strings.ReplaceAll(dependency.Service, "-", "_")) + "_URL"
+}
+
+// ResolveDependencies validates config.Dependencies and appends each
+// dependency's URL to config.Env. options.Name is the resource being
+// rendered and options.Region the default region of dependencies. The
+// returned config has no dependencies left, so resolving it again is a
+// no-op.
+func ResolveDependencies(config Config, options Options) (Config, error) {
+	if len(config.Dependencies) == 0 {
+		return config, nil
+	}
+
+	mesh := config.RunConfig.Mesh
+	defined := make(map[string]bool, len(config.Env)+len(config.Dependencies))
+	for _, entry := range config.Env {
+		defined[entry.Variable] = true
+	}
+
+	env := append([]Env{}, config.Env...)
+	for i, dependency := range config.Dependencies {
+		if !serviceNamePattern.MatchString(dependency.Service) {
+			return config, fmt.Errorf("dependencies[%d] service must be a Cloud Run service name, got %q", i, dependency.Service)
+		}
+		if dependency.Service == options.Name {
+			return config, fmt.Errorf("dependencies[%d] %q is the service itself", i, dependency.Service)
+		}
+		if mesh == "" {
+			if config.ProjectNumber == "" {
+				return config, fmt.Errorf("dependencies[%d] %q needs the project number to build its URL; set projectNumber or configure runConfig.mesh", i, dependency.Service)
+			}
+			if dependency.Region == "" && options.Region == "" {
+				return config, fmt.Errorf("dependencies[%d] %q needs a region to build its URL", i, dependency.Service)
+			}
+		}
+		if dependency.Region != "" && !regionPattern.MatchString(dependency.Region) {
+			return config, fmt.Errorf("dependencies[%d] region must be a Cloud Run region, got %q", i, dependency.Region)
+		}
+
+		variable := DependencyVariable(dependency)
+		if defined[variable] {
+			return config, fmt.Errorf("dependencies[%d] %q sets env %q, which is already defined", i, dependency.Service, variable)
+		}
+		defined[variable] = true
+
+		url := DependencyURL(dependency, config.ProjectNumber, options.Region, mesh)
+		env = append(env, Env{Variable: variable, Value: &url})
+	}
+
+	config.Env = env
+	config.Dependencies = nil
+	return config, nil
+}
+
+func validateMesh(mesh string) error {
+	if mesh != "" && !meshPattern.MatchString(mesh) {
+		return fmt.Errorf("runConfig.mesh must match projects/<project>/locations/global/meshes/<mesh>, got %q", mesh)
+	}
+	return nil
+}
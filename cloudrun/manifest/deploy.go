@@ -0,0 +1,208 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Defaults of the deploy.verify and rollout sections.
+const (
+	DefaultVerifyTimeoutSeconds = 300
+	DefaultVerifyTag            = "verify"
+	DefaultRolloutTag           = "canary"
+)
+
+// Bounds of deploy.verify.timeoutSeconds and rollout.steps[].bakeSeconds.
+const (
+	MaxVerifyTimeoutSeconds = 3600
+	MaxBakeSeconds          = 86400
+)
+
+var revisionTagPattern = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,44}[a-z0-9])?$`)
+
+// Deploy configures what a .deploy target does around gcloud replace. It
+// does not change the rendered manifest.
+type Deploy struct {
+	Verify *Verify `yaml:"verify" json:"verify,omitempty"`
+}
+
+// Verify checks a newly deployed service revision: it waits for the
+// revision to become ready, optionally requests Path on the revision's
+// tagged URL, and restores the previous traffic split when either fails.
+type Verify struct {
+	// TimeoutSeconds bounds the whole verification, readiness and smoke
+	// test together.
+	TimeoutSeconds *int `yaml:"timeoutSeconds" json:"timeoutSeconds,omitempty"`
+	// Path is requested on the tagged URL of the new revision and must
+	// answer with a 2xx status. No smoke test runs when it is empty.
+	Path string `yaml:"path" json:"path,omitempty"`
+	// Tag is the traffic tag that gives the new revision its own URL.
+	Tag string `yaml:"tag" json:"tag,omitempty"`
+	// Rollback restores the previous traffic split when verification
+	// fails. It defaults to true.
+	Rollback *bool `yaml:"rollback" json:"rollback,omitempty"`
+}
+
+// Rollout shifts traffic to a new service revision in steps. The revision
+// is deployed without traffic under Tag, then receives each step's percent
+// of the traffic for BakeSeconds before Gate decides whether to continue.
+type Rollout struct {
+	Tag   string        `yaml:"tag" json:"tag,omitempty"`
+	Steps []RolloutStep `yaml:"steps" json:"steps"`
+	Gate  *RolloutGate  `yaml:"gate" json:"gate,omitempty"`
+}
+
+// RolloutStep sends Percent of the traffic to the new revision and waits
+// BakeSeconds before the health gate runs.
+type RolloutStep struct {
+	Percent     int `yaml:"percent" json:"percent"`
+	BakeSeconds int `yaml:"bakeSeconds" json:"bakeSeconds,omitempty"`
+}
+
+// RolloutGate is the health check run after every step but the last. At
+// most one of HTTP and Command is set; without either, steps only bake.
+type RolloutGate struct {
+	HTTP *HTTPGate `yaml:"http" json:"http,omitempty"`
+	// Command is run from the workspace root and passes with exit code 0.
+	Command []string `yaml:"command" json:"command,omitempty"`
+}
+
+// HTTPGate requests Path on the new revision's tagged URL and passes on a
+// 2xx status.
+type HTTPGate struct {
+	Path string `yaml:"path" json:"path"`
+}
+
+// DeploySettings are the settings of a .deploy target: the deploy section
+// and the rollout plan.
+type DeploySettings struct {
+	Verify  *Verify  `json:"verify,omitempty"`
+	Rollout *Rollout `json:"rollout,omitempty"`
+}
+
+// ValidateDeploy checks the deploy section.
+func ValidateDeploy(deploy *Deploy) error {
+	if deploy == nil || deploy.Verify == nil {
+		return nil
+	}
+	verify := deploy.Verify
+	if verify.TimeoutSeconds != nil && (*verify.TimeoutSeconds < 1 || *verify.TimeoutSeconds > MaxVerifyTimeoutSeconds) {
+		return fmt.Errorf("deploy.verify.timeoutSeconds must be between 1 and %d, got %d", MaxVerifyTimeoutSeconds, *verify.TimeoutSeconds)
+	}
+	if err := validateURLPath(verify.Path); err != nil {
+		return fmt.Errorf("deploy.verify.path %w", err)
+	}
+	if err := validateRevisionTag(verify.Tag); err != nil {
+		return fmt.Errorf("deploy.verify.tag %w", err)
+	}
+	if verify.Tag != "" && verify.Path == "" {
+		return errors.New("deploy.verify.tag is only used with deploy.verify.path")
+	}
+	return nil
+}
+
+// ValidateRollout checks that the steps raise the traffic percent up to
+// 100, that bake times are in range, and that the gate is well formed.
+func ValidateRollout(rollout *Rollout) error {
+	if rollout == nil {
+		return nil
+	}
+	if err := validateRevisionTag(rollout.Tag); err != nil {
+		return fmt.Errorf("rollout.tag %w", err)
+	}
+	if len(rollout.Steps) == 0 {
+		return errors.New("rollout.steps must list at least one step")
+	}
+	previous := 0
+	for i, step := range rollout.Steps {
+		if step.Percent <= previous || step.Percent > 100 {
+			return fmt.Errorf("rollout.steps[%d].percent must be greater than %d and at most 100, got %d", i, previous, step.Percent)
+		}
+		if step.BakeSeconds < 0 || step.BakeSeconds > MaxBakeSeconds {
+			return fmt.Errorf("rollout.steps[%d].bakeSeconds must be between 0 and %d, got %d", i, MaxBakeSeconds, step.BakeSeconds)
+		}
+		previous = step.Percent
+	}
+	if previous != 100 {
+		return fmt.Errorf("the last of rollout.steps must send 100 percent of the traffic, got %d", previous)
+	}
+	if gate := rollout.Gate; gate != nil {
+		if gate.HTTP != nil && len(gate.Command) > 0 {
+			return errors.New("rollout.gate sets both 'http' and 'command'; set one")
+		}
+		if gate.HTTP != nil {
+			if gate.HTTP.Path == "" {
+				return errors.New("rollout.gate.http.path is required")
+			}
+			if err := validateURLPath(gate.HTTP.Path); err != nil {
+				return fmt.Errorf("rollout.gate.http.path %w", err)
+			}
+		}
+		if gate.Command != nil && (len(gate.Command) == 0 || gate.Command[0] == "") {
+			return errors.New("rollout.gate.command must name a program")
+		}
+	}
+	return nil
+}
+
+func validateURLPath(path string) error {
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("must start with '/', got %q", path)
+	}
+	if strings.ContainsAny(path, " \t\n#") {
+		return fmt.Errorf("%q must not contain whitespace or a fragment", path)
+	}
+	return nil
+}
+
+func validateRevisionTag(tag string) error {
+	if tag != "" && !revisionTagPattern.MatchString(tag) {
+		return fmt.Errorf("%q must be lowercase letters, digits, and hyphens, start with a letter, and be at most 46 characters", tag)
+	}
+	return nil
+}
+
+// BuildDeploySettings validates config.Deploy and config.Rollout and
+// returns them with defaults filled in. It returns nil when the config has
+// neither.
+func BuildDeploySettings(config Config) (*DeploySettings, error) {
+	if err := ValidateDeploy(config.Deploy); err != nil {
+		return nil, err
+	}
+	if err := ValidateRollout(config.Rollout); err != nil {
+		return nil, err
+	}
+
+	var settings DeploySettings
+	if config.Deploy != nil && config.Deploy.Verify != nil {
+		verify := *config.Deploy.Verify
+		if verify.TimeoutSeconds == nil {
+			timeout := DefaultVerifyTimeoutSeconds
+			verify.TimeoutSeconds = &timeout
+		}
+		if verify.Path != "" && verify.Tag == "" {
+			verify.Tag = DefaultVerifyTag
+		}
+		if verify.Rollback == nil {
+			rollback := true
+			verify.Rollback = &rollback
+		}
+		settings.Verify = &verify
+	}
+	if config.Rollout != nil {
+		rollout := *config.Rollout
+		if rollout.Tag == "" {
+			rollout.Tag = DefaultRolloutTag
+		}
+		settings.Rollout = &rollout
+	}
+	if settings.Verify == nil && settings.Rollout == nil {
+		return nil, nil
+	}
+	if settings.Verify != nil && settings.Rollout != nil && settings.Verify.Tag == settings.Rollout.Tag {
+		return nil, fmt.Errorf("deploy.verify.tag and rollout.tag must differ, both are %q", settings.Rollout.Tag)
+	}
+	return &settings, nil
+}
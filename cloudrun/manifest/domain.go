@@ -0,0 +1,80 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DomainMapping is a domains.cloudrun.com/v1 DomainMapping manifest that
+// routes a custom hostname to a service.
+type DomainMapping struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Spec       DomainMappingSpec `json:"spec"`
+}
+
+// DomainMappingSpec names the service a domain is mapped to.
+type DomainMappingSpec struct {
+	RouteName       string `json:"routeName"`
+	CertificateMode string `json:"certificateMode"`
+}
+
+var hostnameLabelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// ValidateDomains checks that every domain is a fully qualified lowercase
+// hostname outside run.app and that no domain is listed twice.
+func ValidateDomains(domains []string) error {
+	seen := make(map[string]int, len(domains))
+	for i, domain := range domains {
+		if err := validateHostname(domain); err != nil {
+			return fmt.Errorf("domains[%d]: %w", i, err)
+		}
+		if first, duplicate := seen[domain]; duplicate {
+			return fmt.Errorf("domain %q is listed more than once (domains[%d] and domains[%d])", domain, first, i)
+		}
+		seen[domain] = i
+	}
+	return nil
+}
+
+func validateHostname(domain string) error {
+	if len(domain) > 253 {
+		return fmt.Errorf("%q is longer than 253 characters", domain)
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%q must be a fully qualified hostname such as api.example.com", domain)
+	}
+	for _, label := range labels {
+		if len(label) > 63 || !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("%q is not a valid lowercase hostname", domain)
+		}
+	}
+	if domain == "run.app" || strings.HasSuffix(domain, ".run.app") {
+		return fmt.Errorf("%q is a run.app hostname; only custom domains can be mapped", domain)
+	}
+	return nil
+}
+
+// BuildDomainMappings builds one DomainMapping per entry in config.Domains,
+// each routing to the service named options.Name.
+func BuildDomainMappings(config Config, options Options) ([]*DomainMapping, error) {
+	if err := ValidateDomains(config.Domains); err != nil {
+		return nil, err
+	}
+	mappings := make([]*DomainMapping, 0, len(config.Domains))
+	for _, domain := range config.Domains {
+		mappings = append(mappings, &DomainMapping{
+			APIVersion: "domains.cloudrun.com/v1",
+			Kind:       "DomainMapping",
+			Metadata:   ObjectMeta{Name: domain},
+			Spec: DomainMappingSpec{
+				RouteName:       options.Name,
+				CertificateMode: "AUTOMATIC",
+			},
+		})
+	}
+	return mappings, nil
+}
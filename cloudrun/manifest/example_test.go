@@ -0,0 +1,138 @@
+package manifest_test
+
+import (
+	"fmt"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+)
+
+func ExampleParseConfig() {
+	config, err := manifest.ParseConfig([]byte(`
+runConfig:
+  cpu: 2
+  ingress: internal
+env:
+  - variable: LOG_LEVEL
+    value: info
+`))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(*config.RunConfig.CPU, config.RunConfig.Ingress, config.Env[0].Variable)
+	// Output: 2 internal LOG_LEVEL
+}
+
+func ExampleBuildService() {
+	config, err := manifest.ParseConfig([]byte(`
+runConfig:
+  maxInstances: 5
+serviceAccount: app@project.iam.gserviceaccount.com
+env:
+  - variable: API_KEY
+    secret: projects/123456789/secrets/API_KEY
+`))
+	if err != nil {
+		panic(err)
+	}
+
+	service, err := manifest.BuildService(config, manifest.Options{
+		Name:  "myapp",
+		Image: "us-docker.pkg.dev/project/repo/myapp:v1",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// Post-process the typed manifest before serializing it.
+	service.Labels = map[string]string{"team": "payments"}
+
+	data, err := manifest.Marshal(service)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print(string(data))
+	// Output:
+	// apiVersion: serving.knative.dev/v1
+	// kind: Service
+	// metadata:
+	//   annotations:
+	//     run.googleapis.com/ingress: all
+	//     run.googleapis.com/maxScale: "5"
+	//   labels:
+	//     team: payments
+	//   name: myapp
+	// spec:
+	//   template:
+	//     metadata:
+	//       annotations:
+	//         run.googleapis.com/execution-environment: gen2
+	//     spec:
+	//       containers:
+	//       - env:
+	//         - name: API_KEY
+	//           valueFrom:
+	//             secretKeyRef:
+	//               key: latest
+	//               name: API_KEY
+	//         image: us-docker.pkg.dev/project/repo/myapp:v1
+	//         resources:
+	//           limits:
+	//             cpu: "1"
+	//             memory: 512Mi
+	//       serviceAccountName: app@project.iam.gserviceaccount.com
+	//       timeoutSeconds: 300
+}
+
+func ExampleBuildJob() {
+	config, err := manifest.ParseConfig([]byte(`
+runConfig:
+  taskCount: 10
+  parallelism: 2
+`))
+	if err != nil {
+		panic(err)
+	}
+
+	job, err := manifest.BuildJob(config, manifest.Options{
+		Name:  "nightly-export",
+		Image: "us-docker.pkg.dev/project/repo/export:v1",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	execution := job.Spec.Template.Spec
+	fmt.Println(job.Kind, job.Metadata.Name, execution.TaskCount, *execution.Parallelism)
+	fmt.Println(execution.Template.Spec.Containers[0].Resources.Limits["memory"])
+	// Output:
+	// Job nightly-export 10 2
+	// 512Mi
+}
+
+func ExampleBuildWorkerPool() {
+	config, err := manifest.ParseConfig([]byte(`
+runConfig:
+  minInstances: 1
+  startupProbe:
+    tcpSocket:
+      port: 8080
+`))
+	if err != nil {
+		panic(err)
+	}
+
+	pool, err := manifest.BuildWorkerPool(config, manifest.Options{
+		Name:  "queue-consumer",
+		Image: "us-docker.pkg.dev/project/repo/consumer:v1",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	container := pool.Spec.Template.Spec.Containers[0]
+	fmt.Println(pool.Kind, pool.Metadata.Annotations["run.googleapis.com/minScale"])
+	fmt.Println(*container.StartupProbe.TCPSocket.Port)
+	// Output:
+	// WorkerPool 1
+	// 8080
+}
@@ -0,0 +1,82 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// InvokerRole is granted to the members listed in iam.invokers.
+const InvokerRole = "roles/run.invoker"
+
+// Principals that are not tied to an identity.
+const (
+	MemberAllUsers              = "allUsers"
+	MemberAllAuthenticatedUsers = "allAuthenticatedUsers"
+)
+
+var (
+	emailMemberPattern     = regexp.MustCompile(`^(user|group|serviceAccount):[^@\s:]+@[^@\s.]+(\.[^@\s.]+)+$`)
+	domainMemberPattern    = regexp.MustCompile(`^domain:[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)+$`)
+	principalMemberPattern = regexp.MustCompile(`^principal(Set)?://\S+$`)
+)
+
+// IAMPolicy is an IAM policy document in the format accepted by gcloud
+// set-iam-policy.
+type IAMPolicy struct {
+	Bindings []IAMBinding `json:"bindings"`
+}
+
+// IAMBinding grants a role to a list of members.
+type IAMBinding struct {
+	Members []string `json:"members"`
+	Role    string   `json:"role"`
+}
+
+// ValidateIAMMember checks that member is allUsers, allAuthenticatedUsers,
+// a user:, group:, or serviceAccount: email, a domain:, or a workload
+// identity principal:// or principalSet:// identifier.
+func ValidateIAMMember(member string) error {
+	switch {
+	case member == MemberAllUsers, member == MemberAllAuthenticatedUsers,
+		emailMemberPattern.MatchString(member),
+		domainMemberPattern.MatchString(member),
+		principalMemberPattern.MatchString(member):
+		return nil
+	}
+	return fmt.Errorf("%q is not a valid IAM member; use allUsers, allAuthenticatedUsers, user:<email>, group:<email>, serviceAccount:<email>, domain:<domain>, or a principal:// identifier", member)
+}
+
+// ValidateIAM checks every invoker and that no member is listed twice.
+func ValidateIAM(iam *IAM) error {
+	if iam == nil {
+		return nil
+	}
+	seen := make(map[string]int, len(iam.Invokers))
+	for i, member := range iam.Invokers {
+		if err := ValidateIAMMember(member); err != nil {
+			return fmt.Errorf("iam.invokers[%d]: %w", i, err)
+		}
+		if first, duplicate := seen[member]; duplicate {
+			return fmt.Errorf("iam.invokers member %q is listed more than once (iam.invokers[%d] and iam.invokers[%d])", member, first, i)
+		}
+		seen[member] = i
+	}
+	return nil
+}
+
+// BuildIAMPolicy builds the IAM policy granting config.IAM. It returns nil
+// when the config grants nothing.
+func BuildIAMPolicy(config Config) (*IAMPolicy, error) {
+	if err := ValidateIAM(config.IAM); err != nil {
+		return nil, err
+	}
+	if config.IAM == nil || len(config.IAM.Invokers) == 0 {
+		return nil, nil
+	}
+	return &IAMPolicy{
+		Bindings: []IAMBinding{{
+			Members: append([]string{}, config.IAM.Invokers...),
+			Role:    InvokerRole,
+		}},
+	}, nil
+}
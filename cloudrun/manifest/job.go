@@ -0,0 +1,131 @@
+package manifest
+
+import corev1 "k8s.io/api/core/v1"
+
+// Job is a run.googleapis.com/v1 Job manifest, consumed by
+// `gcloud run jobs replace`.
+type Job struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       JobSpec    `json:"spec"`
+}
+
+// JobSpec describes the executions a Job creates.
+type JobSpec struct {
+	Template ExecutionTemplate `json:"template"`
+}
+
+// ExecutionTemplate is the template for each job execution.
+type ExecutionTemplate struct {
+	Metadata *ObjectMeta   `json:"metadata,omitempty"`
+	Spec     ExecutionSpec `json:"spec"`
+}
+
+// ExecutionSpec sets how many tasks an execution runs.
+type ExecutionSpec struct {
+	TaskCount   int          `json:"taskCount"`
+	Parallelism *int         `json:"parallelism,omitempty"`
+	Template    TaskTemplate `json:"template"`
+}
+
+// TaskTemplate is the template for each task of an execution.
+type TaskTemplate struct {
+	Spec TaskSpec `json:"spec"`
+}
+
+// TaskSpec describes the containers run by a task.
+type TaskSpec struct {
+	Containers         []Container     `json:"containers"`
+	Volumes            []corev1.Volume `json:"volumes,omitempty"`
+	ServiceAccountName string          `json:"serviceAccountName,omitempty"`
+	MaxRetries         *int            `json:"maxRetries,omitempty"`
+	TimeoutSeconds     *int            `json:"timeoutSeconds,omitempty"`
+}
+
+// BuildJob builds the Cloud Run Job manifest. Probes, scaling, concurrency,
+// and mesh settings are ignored.
+func BuildJob(config Config, options Options) (*Job, error) {
+	if err := validateContainer(config.RunConfig, "job"); err != nil {
+		return nil, err
+	}
+	if err := validateScaling(config.RunConfig, "job"); err != nil {
+		return nil, err
+	}
+	if err := validateSecurity(config.Security, "job"); err != nil {
+		return nil, err
+	}
+	// Outside a mesh, dependencies are reached at their public URLs.
+	config.RunConfig.Mesh = ""
+	config, err := ResolveDependencies(config, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSecrets(config); err != nil {
+		return nil, err
+	}
+
+	taskCount := DefaultTaskCount
+	if config.RunConfig.TaskCount != nil {
+		taskCount = *config.RunConfig.TaskCount
+	}
+
+	volumes, volumeMounts := buildSecretVolumes(config)
+	container := Container{
+		Image:        options.Image,
+		Command:      config.RunConfig.Command,
+		Args:         config.RunConfig.Args,
+		WorkingDir:   config.RunConfig.WorkingDir,
+		Resources:    buildResourceLimits(config),
+		Env:          buildEnvironmentVariables(config),
+		VolumeMounts: volumeMounts,
+	}
+
+	taskSpec := TaskSpec{
+		Containers: []Container{container},
+		Volumes:    volumes,
+	}
+	if config.ServiceAccount != "" {
+		taskSpec.ServiceAccountName = config.ServiceAccount
+	}
+	if config.RunConfig.MaxRetries != nil {
+		taskSpec.MaxRetries = config.RunConfig.MaxRetries
+	}
+	if config.RunConfig.TimeoutSeconds != nil {
+		taskSpec.TimeoutSeconds = config.RunConfig.TimeoutSeconds
+	}
+
+	executionSpec := ExecutionSpec{
+		TaskCount: taskCount,
+		Template:  TaskTemplate{Spec: taskSpec},
+	}
+	if config.RunConfig.Parallelism != nil {
+		executionSpec.Parallelism = config.RunConfig.Parallelism
+	}
+
+	executionTemplate := ExecutionTemplate{
+		Spec: executionSpec,
+	}
+	annotations := map[string]string{}
+	jobAnnotations := map[string]string{}
+	buildNetworkAnnotations(config, annotations)
+	buildSecretAliases(config, annotations)
+	buildSecurityAnnotations(config.Security, jobAnnotations, annotations)
+	if len(annotations) > 0 {
+		executionTemplate.Metadata = &ObjectMeta{
+			Annotations: annotations,
+		}
+	}
+
+	metadata := ObjectMeta{Name: options.Name}
+	if len(jobAnnotations) > 0 {
+		metadata.Annotations = jobAnnotations
+	}
+
+	return &Job{
+		APIVersion: "run.googleapis.com/v1",
+		Kind:       "Job",
+		Metadata:   metadata,
+		Spec:       JobSpec{Template: executionTemplate},
+	}, nil
+}
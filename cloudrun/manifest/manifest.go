@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Defaults applied when the corresponding config field is unset.
+const (
+	DefaultCPU            = 1
+	DefaultMemoryMiB      = 512
+	DefaultTimeoutSeconds = 300
+	DefaultTaskCount      = 1
+	DefaultIngress        = "all"
+)
+
+// Options carries the values that come from the build rather than the
+// config file.
+type Options struct {
+	// Name is the Cloud Run service, job, or worker pool name.
+	Name string
+	// Image is the container image reference.
+	Image string
+	// Region is the region the resource is deployed to, used for the URLs
+	// of dependencies that do not name their own region.
+	Region string
+	// TimeoutSeconds is the service request timeout. Services fall back to
+	// DefaultTimeoutSeconds; worker pools omit the field when unset.
+	TimeoutSeconds int
+}
+
+// Marshal serializes a manifest built by this package to YAML. Services are
+// stripped of the empty status and container names that the Knative types
+// always emit, so the output is accepted by gcloud run services replace.
+func Marshal(resource interface{}) ([]byte, error) {
+	data, err := yaml.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("marshal manifest: %w", err)
+	}
+	if _, isService := resource.(*servingv1.Service); !isService {
+		return data, nil
+	}
+	cleaned, err := cleanServiceManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("clean service manifest: %w", err)
+	}
+	return cleaned, nil
+}
+
+func cleanServiceManifest(data []byte) ([]byte, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	delete(raw, "status")
+	removeEmptyContainerNames(raw)
+	return yaml.Marshal(raw)
+}
+
+func removeEmptyContainerNames(node interface{}) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if _, hasImage := v["image"]; hasImage {
+			if name, ok := v["name"].(string); ok && name == "" {
+				delete(v, "name")
+			}
+		}
+		for _, child := range v {
+			removeEmptyContainerNames(child)
+		}
+	case []interface{}:
+		for _, item := range v {
+			removeEmptyContainerNames(item)
+		}
+	}
+}
+
+func secretNameFromReference(secretReference string) string {
+	parts := strings.Split(secretReference, "/")
+	if len(parts) == 0 {
+		return secretReference
+	}
+	return parts[len(parts)-1]
+}
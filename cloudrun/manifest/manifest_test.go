@@ -0,0 +1,683 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type manifestSuite struct {
+	suite.Suite
+}
+
+func TestManifestSuite(t *testing.T) {
+	suite.Run(t, new(manifestSuite))
+}
+
+func (s *manifestSuite) TestBuildServiceIngress() {
+	s.Run("rejects unknown ingress", func() {
+		_, err := BuildService(Config{RunConfig: RunConfig{Ingress: "public"}}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "runConfig.ingress")
+	})
+}
+
+func (s *manifestSuite) TestMarshal() {
+	s.Run("leaves job and worker output untouched", func() {
+		job, err := BuildJob(Config{}, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.NoError(s.T(), err)
+		data, err := Marshal(job)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(data), "taskCount: 1")
+		require.NotContains(s.T(), string(data), "status")
+	})
+
+	s.Run("strips service status", func() {
+		service, err := BuildService(Config{}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.NoError(s.T(), err)
+		data, err := Marshal(service)
+		require.NoError(s.T(), err)
+		require.NotContains(s.T(), string(data), "status")
+		require.NotContains(s.T(), string(data), "name: \"\"")
+	})
+}
+
+func (s *manifestSuite) TestSecretNameFromReference() {
+	s.Run("extracts final segment", func() {
+		require.Equal(s.T(), "API_KEY", secretNameFromReference("projects/123456789/secrets/API_KEY"))
+	})
+
+	s.Run("returns raw value when no delimiter", func() {
+		require.Equal(s.T(), "API_KEY", secretNameFromReference("API_KEY"))
+	})
+}
+
+func (s *manifestSuite) TestValidateEnv() {
+	value := func(v string) *string { return &v }
+
+	s.Run("accepts values, empty values and secrets", func() {
+		require.NoError(s.T(), ValidateEnv([]Env{
+			{Variable: "LOG_LEVEL", Value: value("info")},
+			{Variable: "FEATURE_FLAGS", Value: value("")},
+			{Variable: "API_KEY", Secret: "projects/1/secrets/API_KEY"},
+		}))
+	})
+
+	s.Run("rejects incomplete entries", func() {
+		err := ValidateEnv([]Env{{Value: value("info")}})
+		require.ErrorContains(s.T(), err, "env[0] is missing 'variable'")
+
+		err = ValidateEnv([]Env{{Variable: "LOG_LEVEL"}})
+		require.ErrorContains(s.T(), err, "neither 'value' nor 'secret'")
+
+		err = ValidateEnv([]Env{{Variable: "LOG_LEVEL", Value: value("info"), Secret: "projects/1/secrets/X"}})
+		require.ErrorContains(s.T(), err, "both 'value' and 'secret'")
+	})
+
+	s.Run("rejects duplicate variables", func() {
+		err := ValidateEnv([]Env{
+			{Variable: "LOG_LEVEL", Value: value("info")},
+			{Variable: "API_KEY", Secret: "projects/1/secrets/API_KEY"},
+			{Variable: "LOG_LEVEL", Value: value("debug")},
+		})
+		require.ErrorContains(s.T(), err, `env "LOG_LEVEL" is defined more than once (env[0] and env[2])`)
+	})
+}
+
+func (s *manifestSuite) TestEmptyEnvValue() {
+	config, err := ParseConfig([]byte("env:\n  - variable: FEATURE_FLAGS\n    value: \"\"\n"))
+	require.NoError(s.T(), err)
+
+	s.Run("service keeps empty values", func() {
+		service, err := BuildService(config, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.NoError(s.T(), err)
+		env := service.Spec.Template.Spec.Containers[0].Env
+		require.Len(s.T(), env, 1)
+		require.Equal(s.T(), "FEATURE_FLAGS", env[0].Name)
+		require.Empty(s.T(), env[0].Value)
+	})
+
+	s.Run("job and worker pool keep empty values", func() {
+		job, err := BuildJob(config, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), job.Spec.Template.Spec.Template.Spec.Containers[0].Env, 1)
+
+		pool, err := BuildWorkerPool(config, Options{Name: "myworker", Image: "gcr.io/p/worker"})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), pool.Spec.Template.Spec.Containers[0].Env, 1)
+	})
+}
+
+func (s *manifestSuite) TestSecretVersions() {
+	s.Run("accepts latest and positive integers", func() {
+		for _, version := range []string{"", "latest", "1", "42"} {
+			require.NoError(s.T(), ValidateEnv([]Env{{Variable: "A", Secret: "projects/1/secrets/a", Version: version}}))
+		}
+	})
+
+	s.Run("rejects other versions", func() {
+		for _, version := range []string{"0", "v2", "-1", "LATEST"} {
+			err := ValidateEnv([]Env{{Variable: "A", Secret: "projects/1/secrets/a", Version: version}})
+			require.Error(s.T(), err, "expected %q to fail", version)
+			require.Contains(s.T(), err.Error(), "secret version")
+		}
+	})
+
+	s.Run("rejects a version on a literal value", func() {
+		value := "x"
+		err := ValidateEnv([]Env{{Variable: "A", Value: &value, Version: "1"}})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "'version' without 'secret'")
+	})
+}
+
+func (s *manifestSuite) TestValidateSecretVolumes() {
+	cases := []struct {
+		name    string
+		volumes []SecretVolume
+		message string
+	}{
+		{"missing secret", []SecretVolume{{MountPath: "/etc/a"}}, "missing 'secret'"},
+		{"relative mount path", []SecretVolume{{MountPath: "etc/a", Secret: "projects/1/secrets/a"}}, "absolute path"},
+		{"nested file name", []SecretVolume{{MountPath: "/etc/a", Secret: "projects/1/secrets/a", FileName: "x/y"}}, "fileName"},
+		{"bad version", []SecretVolume{{MountPath: "/etc/a", Secret: "projects/1/secrets/a", Version: "new"}}, "secret version"},
+		{"shared mount path", []SecretVolume{
+			{MountPath: "/etc/a", Secret: "projects/1/secrets/a"},
+			{MountPath: "/etc/a/", Secret: "projects/1/secrets/b"},
+		}, "used more than once"},
+	}
+	for _, tc := range cases {
+		s.Run(tc.name, func() {
+			err := ValidateSecretVolumes(tc.volumes)
+			require.Error(s.T(), err)
+			require.Contains(s.T(), err.Error(), tc.message)
+		})
+	}
+}
+
+func (s *manifestSuite) TestSecretVolumeNames() {
+	s.Run("sanitizes and deduplicates", func() {
+		names := SecretVolumeNames([]SecretVolume{
+			{Secret: "projects/1/secrets/API_KEY"},
+			{Secret: "projects/2/secrets/api-key"},
+			{Secret: "projects/1/secrets/tls"},
+		})
+		require.Equal(s.T(), []string{"secret-api-key", "secret-api-key-2", "secret-tls"}, names)
+	})
+}
+
+func (s *manifestSuite) TestSecretAliases() {
+	config := Config{
+		ProjectNumber: "111",
+		Env: []Env{
+			{Variable: "LOCAL", Secret: "projects/111/secrets/local"},
+			{Variable: "REMOTE", Secret: "projects/222/secrets/remote", Version: "5"},
+		},
+	}
+
+	s.Run("aliases only cross-project secrets", func() {
+		annotations := map[string]string{}
+		buildSecretAliases(config, annotations)
+		require.Equal(s.T(), "remote-222:projects/222/secrets/remote", annotations["run.googleapis.com/secrets"])
+
+		envVars := buildEnvironmentVariables(config)
+		require.Equal(s.T(), "local", envVars[0].ValueFrom.SecretKeyRef.Name)
+		require.Equal(s.T(), "latest", envVars[0].ValueFrom.SecretKeyRef.Key)
+		require.Equal(s.T(), "remote-222", envVars[1].ValueFrom.SecretKeyRef.Name)
+		require.Equal(s.T(), "5", envVars[1].ValueFrom.SecretKeyRef.Key)
+	})
+
+	s.Run("uses short names without a project number", func() {
+		config.ProjectNumber = ""
+		annotations := map[string]string{}
+		buildSecretAliases(config, annotations)
+		require.Empty(s.T(), annotations)
+		require.Equal(s.T(), "remote", buildEnvironmentVariables(config)[1].ValueFrom.SecretKeyRef.Name)
+	})
+}
+
+func (s *manifestSuite) TestResolveDependencies() {
+	options := Options{Name: "frontend", Region: "us-central1"}
+	value := func(config Config, variable string) string {
+		for _, entry := range config.Env {
+			if entry.Variable == variable {
+				return *entry.Value
+			}
+		}
+		return ""
+	}
+
+	s.Run("injects deterministic URLs", func() {
+		config, err := ResolveDependencies(Config{
+			ProjectNumber: "123456789",
+			Dependencies: []Dependency{
+				{Service: "user-api"},
+				{Service: "billing", Variable: "BILLING_ENDPOINT", Region: "europe-west1"},
+			},
+		}, options)
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), config.Dependencies)
+		require.Equal(s.T(), "https://user-api-123456789.us-central1.run.app", value(config, "USER_API_URL"))
+		require.Equal(s.T(), "https://billing-123456789.europe-west1.run.app", value(config, "BILLING_ENDPOINT"))
+	})
+
+	s.Run("uses mesh hostnames when a mesh is configured", func() {
+		config, err := ResolveDependencies(Config{
+			RunConfig:    RunConfig{Mesh: "projects/p/locations/global/meshes/m"},
+			Dependencies: []Dependency{{Service: "user-api"}},
+		}, options)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "http://user-api.run.app", value(config, "USER_API_URL"))
+	})
+
+	cases := []struct {
+		name    string
+		config  Config
+		message string
+	}{
+		{"self dependency", Config{ProjectNumber: "1", Dependencies: []Dependency{{Service: "frontend"}}}, "is the service itself"},
+		{"invalid service name", Config{ProjectNumber: "1", Dependencies: []Dependency{{Service: "User_API"}}}, "Cloud Run service name"},
+		{"missing project number", Config{Dependencies: []Dependency{{Service: "user-api"}}}, "project number"},
+		{"env conflict", Config{
+			ProjectNumber: "1",
+			Env:           []Env{{Variable: "USER_API_URL", Secret: "projects/1/secrets/url"}},
+			Dependencies:  []Dependency{{Service: "user-api"}},
+		}, "already defined"},
+	}
+	for _, tc := range cases {
+		s.Run(tc.name, func() {
+			_, err := ResolveDependencies(tc.config, options)
+			require.Error(s.T(), err)
+			require.Contains(s.T(), err.Error(), tc.message)
+		})
+	}
+}
+
+func (s *manifestSuite) TestBuildServiceMesh() {
+	s.Run("annotates the revision template", func() {
+		service, err := BuildService(Config{RunConfig: RunConfig{Mesh: "projects/p/locations/global/meshes/m"}}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "projects/p/locations/global/meshes/m", service.Spec.Template.Annotations["run.googleapis.com/mesh"])
+	})
+
+	s.Run("rejects malformed mesh names", func() {
+		_, err := BuildService(Config{RunConfig: RunConfig{Mesh: "my-mesh"}}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "runConfig.mesh")
+	})
+
+	s.Run("jobs ignore the mesh", func() {
+		job, err := BuildJob(Config{
+			ProjectNumber: "1",
+			RunConfig:     RunConfig{Mesh: "projects/p/locations/global/meshes/m"},
+			Dependencies:  []Dependency{{Service: "user-api"}},
+		}, Options{Name: "myjob", Image: "gcr.io/p/job", Region: "us-central1"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "https://user-api-1.us-central1.run.app", job.Spec.Template.Spec.Template.Spec.Containers[0].Env[0].Value)
+	})
+}
+
+func (s *manifestSuite) TestContainerSettings() {
+	runConfig := RunConfig{
+		Command:    []string{"/app/server"},
+		Args:       []string{"--mode", "api"},
+		WorkingDir: "/app",
+	}
+
+	s.Run("renders command, args, working directory, and port on services", func() {
+		number := int32(50051)
+		config := runConfig
+		config.Port = &Port{Number: &number, Name: PortNameH2C}
+		service, err := BuildService(Config{RunConfig: config}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.NoError(s.T(), err)
+		container := service.Spec.Template.Spec.Containers[0]
+		require.Equal(s.T(), []string{"/app/server"}, container.Command)
+		require.Equal(s.T(), []string{"--mode", "api"}, container.Args)
+		require.Equal(s.T(), "/app", container.WorkingDir)
+		require.Len(s.T(), container.Ports, 1)
+		require.Equal(s.T(), "h2c", container.Ports[0].Name)
+		require.EqualValues(s.T(), 50051, container.Ports[0].ContainerPort)
+	})
+
+	s.Run("defaults the port number", func() {
+		service, err := BuildService(Config{RunConfig: RunConfig{Port: &Port{Name: PortNameH2C}}}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.NoError(s.T(), err)
+		require.EqualValues(s.T(), DefaultPort, service.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort)
+	})
+
+	s.Run("renders command and args on jobs and workers", func() {
+		job, err := BuildJob(Config{RunConfig: runConfig}, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.NoError(s.T(), err)
+		container := job.Spec.Template.Spec.Template.Spec.Containers[0]
+		require.Equal(s.T(), []string{"/app/server"}, container.Command)
+		require.Equal(s.T(), []string{"--mode", "api"}, container.Args)
+		require.Equal(s.T(), "/app", container.WorkingDir)
+
+		worker, err := BuildWorkerPool(Config{RunConfig: runConfig}, Options{Name: "myworker", Image: "gcr.io/p/worker"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{"--mode", "api"}, worker.Spec.Template.Spec.Containers[0].Args)
+	})
+
+	s.Run("rejects invalid settings", func() {
+		zero, tooHigh := int32(0), int32(65536)
+		for _, tc := range []struct {
+			runConfig RunConfig
+			message   string
+		}{
+			{RunConfig{WorkingDir: "app"}, "runConfig.workingDir must be an absolute path"},
+			{RunConfig{Command: []string{""}}, "runConfig.command must name a program"},
+			{RunConfig{Port: &Port{Number: &zero}}, "runConfig.port.number must be between 1 and 65535, got 0"},
+			{RunConfig{Port: &Port{Number: &tooHigh}}, "runConfig.port.number must be between 1 and 65535, got 65536"},
+			{RunConfig{Port: &Port{Name: "http2"}}, `runConfig.port.name must be "http1" or "h2c", got "http2"`},
+		} {
+			_, err := BuildService(Config{RunConfig: tc.runConfig}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+			require.ErrorContains(s.T(), err, tc.message)
+		}
+	})
+
+	s.Run("rejects a port on jobs and workers", func() {
+		port := RunConfig{Port: &Port{Name: PortNameHTTP1}}
+		_, err := BuildJob(Config{RunConfig: port}, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.EqualError(s.T(), err, "runConfig.port is only supported for services, not jobs")
+		_, err = BuildWorkerPool(Config{RunConfig: port}, Options{Name: "myworker", Image: "gcr.io/p/worker"})
+		require.EqualError(s.T(), err, "runConfig.port is only supported for services, not workers")
+	})
+}
+
+func (s *manifestSuite) TestScaling() {
+	options := Options{Name: "myapp", Image: "gcr.io/p/app"}
+	intPtr := func(v int) *int { return &v }
+
+	s.Run("places service and revision bounds", func() {
+		service, err := BuildService(Config{RunConfig: RunConfig{
+			MinInstances: intPtr(1),
+			Scaling: &Scaling{
+				MaxInstances:         intPtr(20),
+				RevisionMinInstances: intPtr(2),
+				RevisionMaxInstances: intPtr(10),
+				CPUUtilization:       intPtr(60),
+			},
+		}}, options)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "1", service.Annotations["run.googleapis.com/minScale"])
+		require.Equal(s.T(), "20", service.Annotations["run.googleapis.com/maxScale"])
+		templateAnnotations := service.Spec.Template.Annotations
+		require.Equal(s.T(), "2", templateAnnotations["autoscaling.knative.dev/minScale"])
+		require.Equal(s.T(), "10", templateAnnotations["autoscaling.knative.dev/maxScale"])
+		require.Equal(s.T(), "cpu", templateAnnotations["autoscaling.knative.dev/metric"])
+		require.Equal(s.T(), "60", templateAnnotations["autoscaling.knative.dev/target"])
+	})
+
+	s.Run("rejects inconsistent bounds", func() {
+		for _, tc := range []struct {
+			runConfig RunConfig
+			message   string
+		}{
+			{RunConfig{MinInstances: intPtr(5), MaxInstances: intPtr(3)}, "runConfig.minInstances (5) must not exceed runConfig.maxInstances (3)"},
+			{RunConfig{MaxInstances: intPtr(0)}, "runConfig.maxInstances must be at least 1, got 0"},
+			{RunConfig{MinInstances: intPtr(1), Scaling: &Scaling{MinInstances: intPtr(2)}}, "both set the service minimum"},
+			{RunConfig{Scaling: &Scaling{MinInstances: intPtr(4), MaxInstances: intPtr(2)}}, "runConfig.scaling.minInstances (4) must not exceed runConfig.scaling.maxInstances (2)"},
+			{RunConfig{Scaling: &Scaling{RevisionMinInstances: intPtr(3), RevisionMaxInstances: intPtr(2)}}, "runConfig.scaling.revisionMinInstances (3) must not exceed runConfig.scaling.revisionMaxInstances (2)"},
+			{RunConfig{MaxInstances: intPtr(2), Scaling: &Scaling{RevisionMinInstances: intPtr(3)}}, "runConfig.scaling.revisionMinInstances (3) must not exceed runConfig.maxInstances (2)"},
+			{RunConfig{Scaling: &Scaling{CPUUtilization: intPtr(0)}}, "runConfig.scaling.cpuUtilization must be between 1 and 100, got 0"},
+		} {
+			_, err := BuildService(Config{RunConfig: tc.runConfig}, options)
+			require.ErrorContains(s.T(), err, tc.message)
+		}
+	})
+
+	s.Run("rejects scaling on jobs and workers", func() {
+		scaling := RunConfig{Scaling: &Scaling{CPUUtilization: intPtr(60)}}
+		_, err := BuildJob(Config{RunConfig: scaling}, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.EqualError(s.T(), err, "runConfig.scaling is only supported for services, not jobs")
+		_, err = BuildWorkerPool(Config{RunConfig: scaling}, Options{Name: "myworker", Image: "gcr.io/p/worker"})
+		require.EqualError(s.T(), err, "runConfig.scaling is only supported for services, not workers")
+	})
+}
+
+func (s *manifestSuite) TestWorkerScaling() {
+	options := Options{Name: "myworker", Image: "gcr.io/p/worker"}
+	intPtr := func(v int) *int { return &v }
+
+	s.Run("renders manual scaling and instance splits", func() {
+		worker, err := BuildWorkerPool(Config{RunConfig: RunConfig{
+			InstanceCount: intPtr(3),
+			InstanceSplits: []InstanceSplit{
+				{Revision: "myworker-00001-abc", Percent: 75},
+				{Latest: true, Percent: 25},
+			},
+		}}, options)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), map[string]string{
+			"run.googleapis.com/scalingMode":         "manual",
+			"run.googleapis.com/manualInstanceCount": "3",
+		}, worker.Metadata.Annotations)
+		require.Equal(s.T(), []InstanceSplitTarget{
+			{RevisionName: "myworker-00001-abc", Percent: 75},
+			{LatestRevision: true, Percent: 25},
+		}, worker.Spec.InstanceSplits)
+	})
+
+	s.Run("keeps autoscaling bounds without a scaling mode", func() {
+		worker, err := BuildWorkerPool(Config{RunConfig: RunConfig{MinInstances: intPtr(1), MaxInstances: intPtr(5)}}, options)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), map[string]string{
+			"run.googleapis.com/minScale": "1",
+			"run.googleapis.com/maxScale": "5",
+		}, worker.Metadata.Annotations)
+
+		worker, err = BuildWorkerPool(Config{RunConfig: RunConfig{ScalingMode: ScalingModeAutomatic, MaxInstances: intPtr(5)}}, options)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "automatic", worker.Metadata.Annotations["run.googleapis.com/scalingMode"])
+	})
+
+	s.Run("rejects invalid scaling", func() {
+		for _, tc := range []struct {
+			runConfig RunConfig
+			message   string
+		}{
+			{RunConfig{ScalingMode: "Manual"}, `runConfig.scalingMode must be "manual" or "automatic", got "Manual"`},
+			{RunConfig{ScalingMode: ScalingModeManual}, "runConfig.instanceCount is required"},
+			{RunConfig{InstanceCount: intPtr(2), MinInstances: intPtr(1)}, "do not apply to manually scaled worker pools"},
+			{RunConfig{InstanceCount: intPtr(-1)}, "runConfig.instanceCount must not be negative, got -1"},
+			{RunConfig{MinInstances: intPtr(5), MaxInstances: intPtr(3)}, "runConfig.minInstances (5) must not exceed runConfig.maxInstances (3)"},
+			{RunConfig{ScalingMode: ScalingModeAutomatic, InstanceCount: intPtr(2)}, "runConfig.instanceCount only applies to manually scaled worker pools"},
+			{RunConfig{InstanceSplits: []InstanceSplit{{Percent: 100}}}, "runConfig.instanceSplits[0] must set exactly one of 'revision' or 'latest'"},
+			{RunConfig{InstanceSplits: []InstanceSplit{{Revision: "a", Latest: true, Percent: 100}}}, "must set exactly one of 'revision' or 'latest'"},
+			{RunConfig{InstanceSplits: []InstanceSplit{{Latest: true, Percent: 50}, {Latest: true, Percent: 50}}}, "runConfig.instanceSplits[1] assigns latest twice"},
+			{RunConfig{InstanceSplits: []InstanceSplit{{Latest: true, Percent: 101}}}, "runConfig.instanceSplits[0].percent must be between 0 and 100, got 101"},
+			{RunConfig{InstanceSplits: []InstanceSplit{{Revision: "a", Percent: 60}, {Latest: true, Percent: 30}}}, "must assign 100 percent of the instances, got 90"},
+		} {
+			_, err := BuildWorkerPool(Config{RunConfig: tc.runConfig}, options)
+			require.ErrorContains(s.T(), err, tc.message)
+		}
+	})
+}
+
+func (s *manifestSuite) TestSecurity() {
+	hours := 2
+	security := &Security{
+		BinaryAuthorization:     BinaryAuthorizationDefault,
+		BreakglassJustification: "INC-1234",
+		EncryptionKey:           "projects/p/locations/us-central1/keyRings/run/cryptoKeys/images",
+		KeyRevocationAction:     KeyRevocationShutdown,
+		KeyShutdownHours:        &hours,
+	}
+
+	s.Run("annotates services and worker pools", func() {
+		service, err := BuildService(Config{Security: security}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "default", service.Annotations["run.googleapis.com/binary-authorization"])
+		require.Equal(s.T(), "INC-1234", service.Annotations["run.googleapis.com/binary-authorization-breakglass"])
+		templateAnnotations := service.Spec.Template.Annotations
+		require.Equal(s.T(), security.EncryptionKey, templateAnnotations["run.googleapis.com/encryption-key"])
+		require.Equal(s.T(), "SHUTDOWN", templateAnnotations["run.googleapis.com/post-key-revocation-action-type"])
+		require.Equal(s.T(), "2", templateAnnotations["run.googleapis.com/encryption-key-shutdown-hours"])
+
+		worker, err := BuildWorkerPool(Config{Security: security}, Options{Name: "myworker", Image: "gcr.io/p/worker"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "default", worker.Metadata.Annotations["run.googleapis.com/binary-authorization"])
+		require.Equal(s.T(), security.EncryptionKey, worker.Spec.Template.Metadata.Annotations["run.googleapis.com/encryption-key"])
+	})
+
+	s.Run("annotates jobs and their executions", func() {
+		policy := "projects/p/platforms/cloudRun/policies/strict"
+		job, err := BuildJob(Config{Security: &Security{BinaryAuthorization: policy, EncryptionKey: security.EncryptionKey}}, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), map[string]string{"run.googleapis.com/binary-authorization": policy}, job.Metadata.Annotations)
+		require.Equal(s.T(), map[string]string{"run.googleapis.com/encryption-key": security.EncryptionKey}, job.Spec.Template.Metadata.Annotations)
+
+		_, err = BuildJob(Config{Security: security}, Options{Name: "myjob", Image: "gcr.io/p/job"})
+		require.EqualError(s.T(), err, "security.keyRevocationAction is only supported for services and workers, not jobs")
+	})
+
+	s.Run("rejects invalid settings", func() {
+		zero := 0
+		key := security.EncryptionKey
+		for _, tc := range []struct {
+			security Security
+			message  string
+		}{
+			{Security{BinaryAuthorization: "strict"}, "security.binaryAuthorization must be \"default\" or match"},
+			{Security{BreakglassJustification: "INC-1234"}, "security.breakglassJustification requires security.binaryAuthorization"},
+			{Security{EncryptionKey: "projects/p/keyRings/run/cryptoKeys/images"}, "security.encryptionKey must match"},
+			{Security{EncryptionKey: key + "/cryptoKeyVersions/1"}, "security.encryptionKey must match"},
+			{Security{KeyRevocationAction: KeyRevocationShutdown}, "security.keyRevocationAction requires security.encryptionKey"},
+			{Security{EncryptionKey: key, KeyRevocationAction: "stop"}, `security.keyRevocationAction must be "prevent-new" or "shutdown", got "stop"`},
+			{Security{EncryptionKey: key, KeyRevocationAction: KeyRevocationPreventNew, KeyShutdownHours: &hours}, `security.keyShutdownHours requires security.keyRevocationAction "shutdown"`},
+			{Security{EncryptionKey: key, KeyRevocationAction: KeyRevocationShutdown, KeyShutdownHours: &zero}, "security.keyShutdownHours must be at least 1, got 0"},
+		} {
+			_, err := BuildService(Config{Security: &tc.security}, Options{Name: "myapp", Image: "gcr.io/p/app"})
+			require.ErrorContains(s.T(), err, tc.message)
+		}
+	})
+}
+
+func (s *manifestSuite) TestBuildDomainMappings() {
+	s.Run("routes each domain to the service", func() {
+		mappings, err := BuildDomainMappings(Config{Domains: []string{"api.example.com", "www.example.com"}}, Options{Name: "myapp"})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), mappings, 2)
+		data, err := Marshal(mappings[0])
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `apiVersion: domains.cloudrun.com/v1
+kind: DomainMapping
+metadata:
+  name: api.example.com
+spec:
+  certificateMode: AUTOMATIC
+  routeName: myapp
+`, string(data))
+	})
+
+	s.Run("rejects invalid hostnames", func() {
+		for _, domain := range []string{"localhost", "API.example.com", "https://api.example.com", "*.example.com", "-api.example.com", "myapp-abc.a.run.app"} {
+			_, err := BuildDomainMappings(Config{Domains: []string{domain}}, Options{Name: "myapp"})
+			require.Error(s.T(), err, "expected %q to fail", domain)
+		}
+	})
+
+	s.Run("rejects duplicates", func() {
+		err := ValidateDomains([]string{"api.example.com", "api.example.com"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "more than once")
+	})
+}
+
+func (s *manifestSuite) TestBuildIAMPolicy() {
+	s.Run("grants invokers roles/run.invoker", func() {
+		policy, err := BuildIAMPolicy(Config{IAM: &IAM{Invokers: []string{"allUsers", "group:eng@example.com"}}})
+		require.NoError(s.T(), err)
+		data, err := Marshal(policy)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `bindings:
+- members:
+  - allUsers
+  - group:eng@example.com
+  role: roles/run.invoker
+`, string(data))
+	})
+
+	s.Run("grants nothing without invokers", func() {
+		policy, err := BuildIAMPolicy(Config{})
+		require.NoError(s.T(), err)
+		require.Nil(s.T(), policy)
+
+		policy, err = BuildIAMPolicy(Config{IAM: &IAM{}})
+		require.NoError(s.T(), err)
+		require.Nil(s.T(), policy)
+	})
+
+	s.Run("accepts every member type", func() {
+		for _, member := range []string{
+			"allUsers",
+			"allAuthenticatedUsers",
+			"user:alice@example.com",
+			"group:eng@example.com",
+			"serviceAccount:scheduler@my-project.iam.gserviceaccount.com",
+			"domain:example.com",
+			"principalSet://iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/ci/*",
+		} {
+			require.NoError(s.T(), ValidateIAMMember(member), member)
+		}
+	})
+
+	s.Run("rejects invalid members", func() {
+		for _, member := range []string{"", "alice@example.com", "group:eng@", "user:alice", "allusers", "domain:Example.com", "serviceAccount:a@b@c.com"} {
+			require.Error(s.T(), ValidateIAMMember(member), "expected %q to fail", member)
+		}
+	})
+
+	s.Run("rejects duplicates", func() {
+		_, err := BuildIAMPolicy(Config{IAM: &IAM{Invokers: []string{"allUsers", "allUsers"}}})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "more than once")
+	})
+}
+
+func (s *manifestSuite) TestBuildDeploySettings() {
+	s.Run("fills in verification defaults", func() {
+		settings, err := BuildDeploySettings(Config{Deploy: &Deploy{Verify: &Verify{Path: "/healthz"}}})
+		require.NoError(s.T(), err)
+		data, err := Marshal(settings)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `verify:
+  path: /healthz
+  rollback: true
+  tag: verify
+  timeoutSeconds: 300
+`, string(data))
+	})
+
+	s.Run("keeps configured values", func() {
+		timeout, rollback := 60, false
+		settings, err := BuildDeploySettings(Config{Deploy: &Deploy{Verify: &Verify{TimeoutSeconds: &timeout, Rollback: &rollback}}})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), &Verify{TimeoutSeconds: &timeout, Rollback: &rollback}, settings.Verify)
+	})
+
+	s.Run("returns nil without a deploy section", func() {
+		settings, err := BuildDeploySettings(Config{})
+		require.NoError(s.T(), err)
+		require.Nil(s.T(), settings)
+
+		settings, err = BuildDeploySettings(Config{Deploy: &Deploy{}})
+		require.NoError(s.T(), err)
+		require.Nil(s.T(), settings)
+	})
+
+	s.Run("rejects invalid settings", func() {
+		zero, tooLong := 0, MaxVerifyTimeoutSeconds+1
+		for verify, message := range map[*Verify]string{
+			{TimeoutSeconds: &zero}:            "timeoutSeconds must be between 1 and 3600",
+			{TimeoutSeconds: &tooLong}:         "timeoutSeconds must be between 1 and 3600",
+			{Path: "healthz"}:                  "must start with '/'",
+			{Path: "/health check"}:            "must not contain whitespace",
+			{Path: "/healthz", Tag: "Verify"}:  "deploy.verify.tag",
+			{Path: "/healthz", Tag: "verify-"}: "deploy.verify.tag",
+			{Tag: "verify"}:                    "only used with deploy.verify.path",
+		} {
+			_, err := BuildDeploySettings(Config{Deploy: &Deploy{Verify: verify}})
+			require.ErrorContains(s.T(), err, message)
+		}
+	})
+
+	s.Run("fills in the rollout tag", func() {
+		steps := []RolloutStep{{Percent: 5, BakeSeconds: 600}, {Percent: 100}}
+		settings, err := BuildDeploySettings(Config{Rollout: &Rollout{Steps: steps}})
+		require.NoError(s.T(), err)
+		require.Nil(s.T(), settings.Verify)
+		require.Equal(s.T(), &Rollout{Tag: DefaultRolloutTag, Steps: steps}, settings.Rollout)
+	})
+
+	s.Run("rejects equal verification and rollout tags", func() {
+		_, err := BuildDeploySettings(Config{
+			Deploy:  &Deploy{Verify: &Verify{Path: "/", Tag: "canary"}},
+			Rollout: &Rollout{Steps: []RolloutStep{{Percent: 100}}},
+		})
+		require.ErrorContains(s.T(), err, `deploy.verify.tag and rollout.tag must differ, both are "canary"`)
+	})
+
+	s.Run("rejects invalid rollouts", func() {
+		steps := []RolloutStep{{Percent: 25}, {Percent: 100}}
+		for _, tc := range []struct {
+			rollout Rollout
+			message string
+		}{
+			{Rollout{}, "rollout.steps must list at least one step"},
+			{Rollout{Steps: []RolloutStep{{Percent: 50}, {Percent: 25}}}, "rollout.steps[1].percent must be greater than 50"},
+			{Rollout{Steps: []RolloutStep{{Percent: 0}}}, "rollout.steps[0].percent must be greater than 0"},
+			{Rollout{Steps: []RolloutStep{{Percent: 5}, {Percent: 25}}}, "must send 100 percent of the traffic, got 25"},
+			{Rollout{Steps: []RolloutStep{{Percent: 100, BakeSeconds: -1}}}, "rollout.steps[0].bakeSeconds must be between 0 and 86400"},
+			{Rollout{Tag: "Canary", Steps: steps}, "rollout.tag"},
+			{Rollout{Steps: steps, Gate: &RolloutGate{HTTP: &HTTPGate{Path: "/"}, Command: []string{"true"}}}, "sets both 'http' and 'command'"},
+			{Rollout{Steps: steps, Gate: &RolloutGate{HTTP: &HTTPGate{}}}, "rollout.gate.http.path is required"},
+			{Rollout{Steps: steps, Gate: &RolloutGate{HTTP: &HTTPGate{Path: "healthz"}}}, "must start with '/'"},
+			{Rollout{Steps: steps, Gate: &RolloutGate{Command: []string{}}}, "rollout.gate.command must name a program"},
+		} {
+			_, err := BuildDeploySettings(Config{Rollout: &tc.rollout})
+			require.ErrorContains(s.T(), err, tc.message)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Scaling annotations of a Knative Service. The run.googleapis.com keys sit
+// on the service and bound it across all revisions; the autoscaling keys
+// sit on the revision template and bound each revision.
+const (
+	serviceMinScaleAnnotation  = "run.googleapis.com/minScale"
+	serviceMaxScaleAnnotation  = "run.googleapis.com/maxScale"
+	revisionMinScaleAnnotation = "autoscaling.knative.dev/minScale"
+	revisionMaxScaleAnnotation = "autoscaling.knative.dev/maxScale"
+	scalingMetricAnnotation    = "autoscaling.knative.dev/metric"
+	scalingTargetAnnotation    = "autoscaling.knative.dev/target"
+)
+
+// ServiceScaling is runConfig.scaling with MinInstances and MaxInstances
+// taken from their runConfig shorthands when the block does not set them.
+func (r RunConfig) ServiceScaling() Scaling {
+	var scaling Scaling
+	if r.Scaling != nil {
+		scaling = *r.Scaling
+	}
+	if scaling.MinInstances == nil {
+		scaling.MinInstances = r.MinInstances
+	}
+	if scaling.MaxInstances == nil {
+		scaling.MaxInstances = r.MaxInstances
+	}
+	return scaling
+}
+
+// validateScaling checks that the instance bounds of runConfig are
+// consistent with each other and that the CPU target is a percentage. Only
+// services accept runConfig.scaling.
+func validateScaling(runConfig RunConfig, resourceType string) error {
+	minField, maxField := "runConfig.minInstances", "runConfig.maxInstances"
+	if runConfig.Scaling != nil {
+		if resourceType != "service" {
+			return fmt.Errorf("runConfig.scaling is only supported for services, not %ss", resourceType)
+		}
+		if runConfig.Scaling.MinInstances != nil {
+			if runConfig.MinInstances != nil {
+				return errors.New("runConfig.minInstances and runConfig.scaling.minInstances both set the service minimum; keep one")
+			}
+			minField = "runConfig.scaling.minInstances"
+		}
+		if runConfig.Scaling.MaxInstances != nil {
+			if runConfig.MaxInstances != nil {
+				return errors.New("runConfig.maxInstances and runConfig.scaling.maxInstances both set the service maximum; keep one")
+			}
+			maxField = "runConfig.scaling.maxInstances"
+		}
+	}
+
+	scaling := runConfig.ServiceScaling()
+	for _, bound := range []struct {
+		field string
+		value *int
+		least int
+	}{
+		{minField, scaling.MinInstances, 0},
+		{maxField, scaling.MaxInstances, 1},
+		{"runConfig.scaling.revisionMinInstances", scaling.RevisionMinInstances, 0},
+		{"runConfig.scaling.revisionMaxInstances", scaling.RevisionMaxInstances, 1},
+	} {
+		if bound.value != nil && *bound.value < bound.least {
+			return fmt.Errorf("%s must be at least %d, got %d", bound.field, bound.least, *bound.value)
+		}
+	}
+	for _, pair := range []struct {
+		minField, maxField string
+		minValue, maxValue *int
+	}{
+		{minField, maxField, scaling.MinInstances, scaling.MaxInstances},
+		{"runConfig.scaling.revisionMinInstances", "runConfig.scaling.revisionMaxInstances", scaling.RevisionMinInstances, scaling.RevisionMaxInstances},
+		{"runConfig.scaling.revisionMinInstances", maxField, scaling.RevisionMinInstances, scaling.MaxInstances},
+	} {
+		if pair.minValue != nil && pair.maxValue != nil && *pair.minValue > *pair.maxValue {
+			return fmt.Errorf("%s (%d) must not exceed %s (%d)", pair.minField, *pair.minValue, pair.maxField, *pair.maxValue)
+		}
+	}
+	if target := scaling.CPUUtilization; target != nil && (*target < 1 || *target > 100) {
+		return fmt.Errorf("runConfig.scaling.cpuUtilization must be between 1 and 100, got %d", *target)
+	}
+	return nil
+}
+
+// buildScalingAnnotations writes the service-level bounds to
+// serviceAnnotations and the revision-level bounds and CPU target to
+// templateAnnotations.
+func buildScalingAnnotations(scaling Scaling, serviceAnnotations, templateAnnotations map[string]string) {
+	for _, annotation := range []struct {
+		annotations map[string]string
+		key         string
+		value       *int
+	}{
+		{serviceAnnotations, serviceMinScaleAnnotation, scaling.MinInstances},
+		{serviceAnnotations, serviceMaxScaleAnnotation, scaling.MaxInstances},
+		{templateAnnotations, revisionMinScaleAnnotation, scaling.RevisionMinInstances},
+		{templateAnnotations, revisionMaxScaleAnnotation, scaling.RevisionMaxInstances},
+		{templateAnnotations, scalingTargetAnnotation, scaling.CPUUtilization},
+	} {
+		if annotation.value != nil {
+			annotation.annotations[annotation.key] = strconv.Itoa(*annotation.value)
+		}
+	}
+	if scaling.CPUUtilization != nil {
+		templateAnnotations[scalingMetricAnnotation] = "cpu"
+	}
+}
@@ -0,0 +1,198 @@
+package manifest
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultSecretVersion is used when an env entry or secret volume does not
+// pin a version.
+const DefaultSecretVersion = "latest"
+
+// secretsAnnotation declares aliases for secrets that live in another
+// project; Knative manifests can only name secrets by a single DNS label.
+const secretsAnnotation = "run.googleapis.com/secrets"
+
+var (
+	secretReferencePattern = regexp.MustCompile(`^projects/([^/]+)/secrets/([^/]+)$`)
+	secretVersionPattern   = regexp.MustCompile(`^[1-9][0-9]*$`)
+	volumeNameInvalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// SecretVersion returns version, or DefaultSecretVersion when it is empty.
+func SecretVersion(version string) string {
+	if version == "" {
+		return DefaultSecretVersion
+	}
+	return version
+}
+
+// SecretVolumeNames returns the volume name of each secret volume. Names are
+// derived from the secret's short name and deduplicated with a numeric
+// suffix, so every output format names the same volume the same way.
+func SecretVolumeNames(volumes []SecretVolume) []string {
+	names := make([]string, len(volumes))
+	used := map[string]bool{}
+	for i, volume := range volumes {
+		base := "secret-" + strings.Trim(volumeNameInvalidChars.ReplaceAllString(
+			strings.ToLower(secretNameFromReference(volume.Secret)), "-"), "-")
+		name := base
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// ValidateSecretVolumes checks that every secret volume names a secret and
+// an absolute mount path, pins a valid version if any, and that no two
+// volumes share a mount path.
+func ValidateSecretVolumes(volumes []SecretVolume) error {
+	seen := make(map[string]int, len(volumes))
+	for i, volume := range volumes {
+		if volume.Secret == "" {
+			return fmt.Errorf("secretVolumes[%d] is missing 'secret'", i)
+		}
+		if !path.IsAbs(volume.MountPath) {
+			return fmt.Errorf("secretVolumes[%d] mountPath must be an absolute path, got %q", i, volume.MountPath)
+		}
+		if strings.Contains(volume.FileName, "/") {
+			return fmt.Errorf("secretVolumes[%d] fileName must not contain '/', got %q", i, volume.FileName)
+		}
+		if err := validateSecretVersion(volume.Version); err != nil {
+			return fmt.Errorf("secretVolumes[%d]: %w", i, err)
+		}
+		mountPath := path.Clean(volume.MountPath)
+		if first, duplicate := seen[mountPath]; duplicate {
+			return fmt.Errorf("secretVolumes mountPath %q is used more than once (secretVolumes[%d] and secretVolumes[%d])", mountPath, first, i)
+		}
+		seen[mountPath] = i
+	}
+	return nil
+}
+
+func validateSecretVersion(version string) error {
+	if version == "" || version == DefaultSecretVersion || secretVersionPattern.MatchString(version) {
+		return nil
+	}
+	return fmt.Errorf("secret version must be %q or a positive integer, got %q", DefaultSecretVersion, version)
+}
+
+func validateSecrets(config Config) error {
+	if err := ValidateEnv(config.Env); err != nil {
+		return err
+	}
+	return ValidateSecretVolumes(config.SecretVolumes)
+}
+
+// secretLocalName is the name a Knative manifest uses for a secret: the
+// short name for secrets in the deploy project (or when the deploy project
+// is unknown), and an alias declared in the secrets annotation otherwise.
+func secretLocalName(config Config, reference string) string {
+	match := secretReferencePattern.FindStringSubmatch(reference)
+	if match == nil || config.ProjectNumber == "" || match[1] == config.ProjectNumber {
+		return secretNameFromReference(reference)
+	}
+	return match[2] + "-" + match[1]
+}
+
+// buildSecretAliases adds the secrets annotation for every secret referenced
+// through an alias.
+func buildSecretAliases(config Config, annotations map[string]string) {
+	aliases := map[string]string{}
+	references := make([]string, 0, len(config.Env)+len(config.SecretVolumes))
+	for _, entry := range config.Env {
+		if entry.Value == nil {
+			references = append(references, entry.Secret)
+		}
+	}
+	for _, volume := range config.SecretVolumes {
+		references = append(references, volume.Secret)
+	}
+	for _, reference := range references {
+		if name := secretLocalName(config, reference); name != secretNameFromReference(reference) {
+			aliases[name] = reference
+		}
+	}
+	if len(aliases) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + ":" + aliases[name]
+	}
+	annotations[secretsAnnotation] = strings.Join(pairs, ",")
+}
+
+// buildEnvironmentVariables renders env entries for every resource type.
+func buildEnvironmentVariables(config Config) []corev1.EnvVar {
+	envVars := make([]corev1.EnvVar, 0, len(config.Env))
+	for _, entry := range config.Env {
+		if entry.Value != nil {
+			envVars = append(envVars, corev1.EnvVar{
+				Name:  entry.Variable,
+				Value: *entry.Value,
+			})
+			continue
+		}
+		envVars = append(envVars, corev1.EnvVar{
+			Name: entry.Variable,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: secretLocalName(config, entry.Secret),
+					},
+					Key: SecretVersion(entry.Version),
+				},
+			},
+		})
+	}
+	return envVars
+}
+
+// buildSecretVolumes renders secret volumes and their container mounts for
+// every resource type.
+func buildSecretVolumes(config Config) ([]corev1.Volume, []corev1.VolumeMount) {
+	if len(config.SecretVolumes) == 0 {
+		return nil, nil
+	}
+	names := SecretVolumeNames(config.SecretVolumes)
+	volumes := make([]corev1.Volume, 0, len(config.SecretVolumes))
+	mounts := make([]corev1.VolumeMount, 0, len(config.SecretVolumes))
+	for i, volume := range config.SecretVolumes {
+		fileName := volume.FileName
+		if fileName == "" {
+			fileName = secretNameFromReference(volume.Secret)
+		}
+		volumes = append(volumes, corev1.Volume{
+			Name: names[i],
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secretLocalName(config, volume.Secret),
+					Items: []corev1.KeyToPath{{
+						Key:  SecretVersion(volume.Version),
+						Path: fileName,
+					}},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      names[i],
+			MountPath: volume.MountPath,
+		})
+	}
+	return volumes, mounts
+}
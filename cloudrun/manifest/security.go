@@ -0,0 +1,105 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Values accepted in the security section.
+const (
+	BinaryAuthorizationDefault = "default"
+	KeyRevocationPreventNew    = "prevent-new"
+	KeyRevocationShutdown      = "shutdown"
+)
+
+// Security annotations. Binary Authorization applies to the resource;
+// the encryption settings apply to each revision or execution.
+const (
+	binaryAuthorizationAnnotation = "run.googleapis.com/binary-authorization"
+	breakglassAnnotation          = "run.googleapis.com/binary-authorization-breakglass"
+	encryptionKeyAnnotation       = "run.googleapis.com/encryption-key"
+	keyRevocationAnnotation       = "run.googleapis.com/post-key-revocation-action-type"
+	keyShutdownHoursAnnotation    = "run.googleapis.com/encryption-key-shutdown-hours"
+)
+
+var (
+	binaryAuthorizationPolicyPattern = regexp.MustCompile(`^projects/[^/]+/platforms/cloudRun/policies/[^/]+$`)
+	encryptionKeyPattern             = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+)
+
+// KeyRevocationActionType maps keyRevocationAction (prevent-new, shutdown)
+// to the value Cloud Run expects (PREVENT_NEW, SHUTDOWN).
+func KeyRevocationActionType(action string) string {
+	return strings.ToUpper(strings.ReplaceAll(action, "-", "_"))
+}
+
+// validateSecurity checks the security section. Jobs run to completion, so
+// only services and worker pools accept a key revocation action.
+func validateSecurity(security *Security, resourceType string) error {
+	if security == nil {
+		return nil
+	}
+	switch policy := security.BinaryAuthorization; {
+	case policy == "", policy == BinaryAuthorizationDefault:
+	case !binaryAuthorizationPolicyPattern.MatchString(policy):
+		return fmt.Errorf("security.binaryAuthorization must be %q or match 'projects/<project>/platforms/cloudRun/policies/<policy>', got %q", BinaryAuthorizationDefault, policy)
+	}
+	if security.BreakglassJustification != "" && security.BinaryAuthorization == "" {
+		return errors.New("security.breakglassJustification requires security.binaryAuthorization")
+	}
+
+	if security.EncryptionKey != "" && !encryptionKeyPattern.MatchString(security.EncryptionKey) {
+		return fmt.Errorf("security.encryptionKey must match 'projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>', got %q", security.EncryptionKey)
+	}
+	if security.KeyRevocationAction == "" {
+		if security.KeyShutdownHours != nil {
+			return fmt.Errorf("security.keyShutdownHours requires security.keyRevocationAction %q", KeyRevocationShutdown)
+		}
+		return nil
+	}
+	if resourceType == "job" {
+		return errors.New("security.keyRevocationAction is only supported for services and workers, not jobs")
+	}
+	if security.EncryptionKey == "" {
+		return errors.New("security.keyRevocationAction requires security.encryptionKey")
+	}
+	switch security.KeyRevocationAction {
+	case KeyRevocationPreventNew:
+		if security.KeyShutdownHours != nil {
+			return fmt.Errorf("security.keyShutdownHours requires security.keyRevocationAction %q", KeyRevocationShutdown)
+		}
+	case KeyRevocationShutdown:
+		if security.KeyShutdownHours != nil && *security.KeyShutdownHours < 1 {
+			return fmt.Errorf("security.keyShutdownHours must be at least 1, got %d", *security.KeyShutdownHours)
+		}
+	default:
+		return fmt.Errorf("security.keyRevocationAction must be %q or %q, got %q", KeyRevocationPreventNew, KeyRevocationShutdown, security.KeyRevocationAction)
+	}
+	return nil
+}
+
+// buildSecurityAnnotations writes the Binary Authorization settings to
+// resourceAnnotations and the encryption settings to templateAnnotations.
+func buildSecurityAnnotations(security *Security, resourceAnnotations, templateAnnotations map[string]string) {
+	if security == nil {
+		return
+	}
+	if security.BinaryAuthorization != "" {
+		resourceAnnotations[binaryAuthorizationAnnotation] = security.BinaryAuthorization
+	}
+	if security.BreakglassJustification != "" {
+		resourceAnnotations[breakglassAnnotation] = security.BreakglassJustification
+	}
+	if security.EncryptionKey != "" {
+		templateAnnotations[encryptionKeyAnnotation] = security.EncryptionKey
+	}
+	if security.KeyRevocationAction != "" {
+		templateAnnotations[keyRevocationAnnotation] = KeyRevocationActionType(security.KeyRevocationAction)
+	}
+	if security.KeyShutdownHours != nil {
+		templateAnnotations[keyShutdownHoursAnnotation] = strconv.Itoa(*security.KeyShutdownHours)
+	}
+}
@@ -0,0 +1,197 @@
+package manifest
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+)
+
+// Ingress settings accepted in runConfig.ingress.
+const (
+	IngressAll                           = "all"
+	IngressInternal                      = "internal"
+	IngressInternalAndCloudLoadBalancing = "internal-and-cloud-load-balancing"
+)
+
+// BuildService builds the Knative Service consumed by
+// `gcloud run services replace`.
+func BuildService(config Config, options Options) (*servingv1.Service, error) {
+	if err := validateMesh(config.RunConfig.Mesh); err != nil {
+		return nil, err
+	}
+	if err := validateContainer(config.RunConfig, "service"); err != nil {
+		return nil, err
+	}
+	if err := validateScaling(config.RunConfig, "service"); err != nil {
+		return nil, err
+	}
+	if err := validateSecurity(config.Security, "service"); err != nil {
+		return nil, err
+	}
+	config, err := ResolveDependencies(config, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSecrets(config); err != nil {
+		return nil, err
+	}
+
+	timeout := options.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = DefaultTimeoutSeconds
+	}
+
+	cpu := DefaultCPU
+	if config.RunConfig.CPU != nil {
+		cpu = *config.RunConfig.CPU
+	}
+	memoryMiB := DefaultMemoryMiB
+	if config.RunConfig.MemoryMiB != nil {
+		memoryMiB = *config.RunConfig.MemoryMiB
+	}
+
+	limits := corev1.ResourceList{
+		corev1.ResourceCPU:    apiresource.MustParse(fmt.Sprintf("%dm", cpu*1000)),
+		corev1.ResourceMemory: apiresource.MustParse(fmt.Sprintf("%dMi", memoryMiB)),
+	}
+
+	templateAnnotations := map[string]string{
+		"run.googleapis.com/execution-environment": "gen2",
+	}
+	buildNetworkAnnotations(config, templateAnnotations)
+	buildSecretAliases(config, templateAnnotations)
+	if config.RunConfig.Mesh != "" {
+		templateAnnotations[meshAnnotation] = config.RunConfig.Mesh
+	}
+
+	volumes, volumeMounts := buildSecretVolumes(config)
+	container := corev1.Container{
+		Image:      options.Image,
+		Command:    config.RunConfig.Command,
+		Args:       config.RunConfig.Args,
+		WorkingDir: config.RunConfig.WorkingDir,
+		Ports:      buildContainerPorts(config.RunConfig.Port),
+		Resources: corev1.ResourceRequirements{
+			Limits: limits,
+		},
+		Env:            buildEnvironmentVariables(config),
+		VolumeMounts:   volumeMounts,
+		LivenessProbe:  buildCoreV1Probe(config.RunConfig.LivenessProbe),
+		ReadinessProbe: buildCoreV1Probe(config.RunConfig.ReadinessProbe),
+		StartupProbe:   buildCoreV1Probe(config.RunConfig.StartupProbe),
+	}
+
+	timeoutSeconds := int64(timeout)
+	revisionSpec := servingv1.RevisionSpec{
+		PodSpec: corev1.PodSpec{
+			Containers: []corev1.Container{container},
+			Volumes:    volumes,
+		},
+		TimeoutSeconds: &timeoutSeconds,
+	}
+	if config.ServiceAccount != "" {
+		revisionSpec.PodSpec.ServiceAccountName = config.ServiceAccount
+	}
+	if config.RunConfig.Concurrency != nil {
+		containerConcurrency := int64(*config.RunConfig.Concurrency)
+		revisionSpec.ContainerConcurrency = &containerConcurrency
+	}
+
+	ingress := config.RunConfig.Ingress
+	if ingress == "" {
+		ingress = DefaultIngress
+	}
+	switch ingress {
+	case IngressAll, IngressInternal, IngressInternalAndCloudLoadBalancing:
+	default:
+		return nil, fmt.Errorf("runConfig.ingress must be %q, %q, or %q, got %q",
+			IngressAll, IngressInternal, IngressInternalAndCloudLoadBalancing, ingress)
+	}
+
+	serviceAnnotations := map[string]string{
+		"run.googleapis.com/ingress": ingress,
+	}
+	buildScalingAnnotations(config.RunConfig.ServiceScaling(), serviceAnnotations, templateAnnotations)
+	buildSecurityAnnotations(config.Security, serviceAnnotations, templateAnnotations)
+	if config.RunConfig.LivenessProbe != nil || config.RunConfig.ReadinessProbe != nil || config.RunConfig.StartupProbe != nil {
+		serviceAnnotations["run.googleapis.com/launch-stage"] = "BETA"
+	}
+
+	service := &servingv1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "serving.knative.dev/v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.Name,
+			Annotations: serviceAnnotations,
+		},
+		Spec: servingv1.ServiceSpec{
+			ConfigurationSpec: servingv1.ConfigurationSpec{
+				Template: servingv1.RevisionTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: templateAnnotations,
+					},
+					Spec: revisionSpec,
+				},
+			},
+		},
+	}
+	return service, nil
+}
+
+func buildCoreV1Probe(p *Probe) *corev1.Probe {
+	if p == nil {
+		return nil
+	}
+	probe := &corev1.Probe{}
+	if p.InitialDelaySeconds != nil {
+		probe.InitialDelaySeconds = *p.InitialDelaySeconds
+	}
+	if p.TimeoutSeconds != nil {
+		probe.TimeoutSeconds = *p.TimeoutSeconds
+	}
+	if p.PeriodSeconds != nil {
+		probe.PeriodSeconds = *p.PeriodSeconds
+	}
+	if p.SuccessThreshold != nil {
+		probe.SuccessThreshold = *p.SuccessThreshold
+	}
+	if p.FailureThreshold != nil {
+		probe.FailureThreshold = *p.FailureThreshold
+	}
+
+	if p.HTTPGet != nil {
+		probe.HTTPGet = &corev1.HTTPGetAction{
+			Path: p.HTTPGet.Path,
+		}
+		if p.HTTPGet.Port != nil {
+			probe.HTTPGet.Port = intstr.FromInt32(*p.HTTPGet.Port)
+		}
+		for _, h := range p.HTTPGet.HTTPHeaders {
+			probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, corev1.HTTPHeader{
+				Name:  h.Name,
+				Value: h.Value,
+			})
+		}
+	} else if p.TCPSocket != nil {
+		probe.TCPSocket = &corev1.TCPSocketAction{}
+		if p.TCPSocket.Port != nil {
+			probe.TCPSocket.Port = intstr.FromInt32(*p.TCPSocket.Port)
+		}
+	} else if p.GRPC != nil {
+		probe.GRPC = &corev1.GRPCAction{}
+		if p.GRPC.Port != nil {
+			probe.GRPC.Port = *p.GRPC.Port
+		}
+		if p.GRPC.Service != "" {
+			service := p.GRPC.Service
+			probe.GRPC.Service = &service
+		}
+	}
+	return probe
+}
@@ -0,0 +1,235 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Worker pool scaling modes accepted in runConfig.scalingMode.
+const (
+	ScalingModeManual    = "manual"
+	ScalingModeAutomatic = "automatic"
+)
+
+// WorkerPool is a run.googleapis.com/v1 WorkerPool manifest, consumed by
+// `gcloud beta run worker-pools replace`.
+type WorkerPool struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   ObjectMeta     `json:"metadata"`
+	Spec       WorkerPoolSpec `json:"spec"`
+}
+
+// WorkerPoolSpec describes the revisions a WorkerPool creates.
+type WorkerPoolSpec struct {
+	Template       RevisionTemplate      `json:"template"`
+	InstanceSplits []InstanceSplitTarget `json:"instanceSplits,omitempty"`
+}
+
+// InstanceSplitTarget is the rendered form of an InstanceSplit.
+type InstanceSplitTarget struct {
+	RevisionName   string `json:"revisionName,omitempty"`
+	LatestRevision bool   `json:"latestRevision,omitempty"`
+	Percent        int    `json:"percent"`
+}
+
+// RevisionTemplate is the template for each worker pool revision.
+type RevisionTemplate struct {
+	Metadata *ObjectMeta  `json:"metadata,omitempty"`
+	Spec     RevisionSpec `json:"spec"`
+}
+
+// RevisionSpec describes the containers run by a worker pool revision.
+type RevisionSpec struct {
+	Containers         []Container     `json:"containers"`
+	Volumes            []corev1.Volume `json:"volumes,omitempty"`
+	ServiceAccountName string          `json:"serviceAccountName,omitempty"`
+	TimeoutSeconds     *int            `json:"timeoutSeconds,omitempty"`
+}
+
+// WorkerScalingMode is the scaling mode of a worker pool: scalingMode when
+// set, manual when instanceCount is set, and automatic when minInstances or
+// maxInstances are. It is empty when the config sets none of them.
+func (r RunConfig) WorkerScalingMode() string {
+	switch {
+	case r.ScalingMode != "":
+		return r.ScalingMode
+	case r.InstanceCount != nil:
+		return ScalingModeManual
+	case r.MinInstances != nil || r.MaxInstances != nil:
+		return ScalingModeAutomatic
+	}
+	return ""
+}
+
+// BuildWorkerPool builds the Cloud Run WorkerPool manifest. Concurrency,
+// ingress, and mesh settings are ignored.
+func BuildWorkerPool(config Config, options Options) (*WorkerPool, error) {
+	if err := validateContainer(config.RunConfig, "worker"); err != nil {
+		return nil, err
+	}
+	if err := validateScaling(config.RunConfig, "worker"); err != nil {
+		return nil, err
+	}
+	if err := validateSecurity(config.Security, "worker"); err != nil {
+		return nil, err
+	}
+	if err := validateWorkerScaling(config.RunConfig); err != nil {
+		return nil, err
+	}
+	// Outside a mesh, dependencies are reached at their public URLs.
+	config.RunConfig.Mesh = ""
+	config, err := ResolveDependencies(config, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSecrets(config); err != nil {
+		return nil, err
+	}
+
+	volumes, volumeMounts := buildSecretVolumes(config)
+	container := Container{
+		Image:          options.Image,
+		Command:        config.RunConfig.Command,
+		Args:           config.RunConfig.Args,
+		WorkingDir:     config.RunConfig.WorkingDir,
+		Resources:      buildResourceLimits(config),
+		Env:            buildEnvironmentVariables(config),
+		VolumeMounts:   volumeMounts,
+		LivenessProbe:  buildContainerProbe(config.RunConfig.LivenessProbe),
+		ReadinessProbe: buildContainerProbe(config.RunConfig.ReadinessProbe),
+		StartupProbe:   buildContainerProbe(config.RunConfig.StartupProbe),
+	}
+
+	revisionSpec := RevisionSpec{
+		Containers: []Container{container},
+		Volumes:    volumes,
+	}
+	if config.ServiceAccount != "" {
+		revisionSpec.ServiceAccountName = config.ServiceAccount
+	}
+	timeout := options.TimeoutSeconds
+	if timeout > 0 {
+		revisionSpec.TimeoutSeconds = &timeout
+	}
+
+	templateAnnotations := map[string]string{
+		"run.googleapis.com/execution-environment": "gen2",
+	}
+	workerAnnotations := map[string]string{}
+	buildNetworkAnnotations(config, templateAnnotations)
+	buildSecretAliases(config, templateAnnotations)
+	buildSecurityAnnotations(config.Security, workerAnnotations, templateAnnotations)
+
+	template := RevisionTemplate{
+		Metadata: &ObjectMeta{Annotations: templateAnnotations},
+		Spec:     revisionSpec,
+	}
+
+	mode := config.RunConfig.WorkerScalingMode()
+	if mode == ScalingModeManual || config.RunConfig.ScalingMode != "" {
+		workerAnnotations["run.googleapis.com/scalingMode"] = mode
+	}
+	if config.RunConfig.InstanceCount != nil {
+		workerAnnotations["run.googleapis.com/manualInstanceCount"] = strconv.Itoa(*config.RunConfig.InstanceCount)
+	}
+	if config.RunConfig.MinInstances != nil {
+		workerAnnotations["run.googleapis.com/minScale"] = strconv.Itoa(*config.RunConfig.MinInstances)
+	}
+	if config.RunConfig.MaxInstances != nil {
+		workerAnnotations["run.googleapis.com/maxScale"] = strconv.Itoa(*config.RunConfig.MaxInstances)
+	}
+	if config.RunConfig.LivenessProbe != nil || config.RunConfig.ReadinessProbe != nil || config.RunConfig.StartupProbe != nil {
+		workerAnnotations["run.googleapis.com/launch-stage"] = "BETA"
+	}
+
+	metadata := ObjectMeta{
+		Name: options.Name,
+	}
+	if len(workerAnnotations) > 0 {
+		metadata.Annotations = workerAnnotations
+	}
+
+	return &WorkerPool{
+		APIVersion: "run.googleapis.com/v1",
+		Kind:       "WorkerPool",
+		Metadata:   metadata,
+		Spec: WorkerPoolSpec{
+			Template:       template,
+			InstanceSplits: buildInstanceSplits(config.RunConfig.InstanceSplits),
+		},
+	}, nil
+}
+
+// validateWorkerScaling checks the scaling settings of a worker pool.
+// Manually scaled pools run exactly instanceCount instances, so the
+// autoscaling bounds do not apply to them, and vice versa.
+func validateWorkerScaling(runConfig RunConfig) error {
+	switch runConfig.WorkerScalingMode() {
+	case "":
+	case ScalingModeManual:
+		if runConfig.MinInstances != nil || runConfig.MaxInstances != nil {
+			return errors.New("runConfig.minInstances and runConfig.maxInstances do not apply to manually scaled worker pools; set runConfig.instanceCount")
+		}
+		if runConfig.InstanceCount == nil {
+			return errors.New("runConfig.instanceCount is required when runConfig.scalingMode is manual")
+		}
+		if *runConfig.InstanceCount < 0 {
+			return fmt.Errorf("runConfig.instanceCount must not be negative, got %d", *runConfig.InstanceCount)
+		}
+	case ScalingModeAutomatic:
+		if runConfig.InstanceCount != nil {
+			return errors.New("runConfig.instanceCount only applies to manually scaled worker pools; set runConfig.scalingMode to manual")
+		}
+	default:
+		return fmt.Errorf("runConfig.scalingMode must be %q or %q, got %q", ScalingModeManual, ScalingModeAutomatic, runConfig.ScalingMode)
+	}
+	return validateInstanceSplits(runConfig.InstanceSplits)
+}
+
+// validateInstanceSplits checks that each split names one revision, or the
+// latest one, and that the splits assign every instance.
+func validateInstanceSplits(splits []InstanceSplit) error {
+	if len(splits) == 0 {
+		return nil
+	}
+	total := 0
+	seen := map[string]bool{}
+	for i, split := range splits {
+		field := fmt.Sprintf("runConfig.instanceSplits[%d]", i)
+		if (split.Revision == "") == !split.Latest {
+			return fmt.Errorf("%s must set exactly one of 'revision' or 'latest'", field)
+		}
+		target := split.Revision
+		if split.Latest {
+			target = "latest"
+		}
+		if seen[target] {
+			return fmt.Errorf("%s assigns %s twice", field, target)
+		}
+		seen[target] = true
+		if split.Percent < 0 || split.Percent > 100 {
+			return fmt.Errorf("%s.percent must be between 0 and 100, got %d", field, split.Percent)
+		}
+		total += split.Percent
+	}
+	if total != 100 {
+		return fmt.Errorf("runConfig.instanceSplits must assign 100 percent of the instances, got %d", total)
+	}
+	return nil
+}
+
+func buildInstanceSplits(splits []InstanceSplit) []InstanceSplitTarget {
+	var targets []InstanceSplitTarget
+	for _, split := range splits {
+		targets = append(targets, InstanceSplitTarget{
+			RevisionName:   split.Revision,
+			LatestRevision: split.Latest,
+			Percent:        split.Percent,
+		})
+	}
+	return targets
+}
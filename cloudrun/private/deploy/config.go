@@ -0,0 +1,233 @@
+// Package deploy implements the .deploy targets: it reads the deploy config
+// assembled at build time and drives gcloud to push, deploy, and configure
+// a rendered Cloud Run resource.
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Resource types accepted in Config.ResourceType.
+const (
+	ResourceTypeService = "service"
+	ResourceTypeJob     = "job"
+	ResourceTypeWorker  = "worker"
+)
+
+// Config is the deploy config of one .deploy target. The build-time
+// constants are written by the rule; Manifest, Domains, IAMBindings,
+// Verify, and Rollout are filled in from the render outputs by Assemble.
+type Config struct {
+	// Name is the Cloud Run service, job, or worker pool name.
+	Name         string   `json:"name"`
+	ResourceType string   `json:"resourceType"`
+	Regions      []string `json:"regions,omitempty"`
+	Project      string   `json:"project,omitempty"`
+	// GcloudTrack is the gcloud release track, such as beta, that replace
+	// runs under.
+	GcloudTrack string `json:"gcloudTrack,omitempty"`
+	// PushRunfile and GcloudRunfile are runfiles-relative paths of the image
+	// push binary and the hermetic gcloud; gcloud is looked up on PATH when
+	// GcloudRunfile is empty.
+	PushRunfile   string `json:"pushRunfile,omitempty"`
+	GcloudRunfile string `json:"gcloudRunfile,omitempty"`
+	// ImagePlaceholder is the image rendered when the rule has no image; a
+	// manifest still referencing it needs --image at deploy time.
+	ImagePlaceholder string       `json:"imagePlaceholder"`
+	Manifest         string       `json:"manifest"`
+	Domains          []string     `json:"domains,omitempty"`
+	IAMBindings      []IAMBinding `json:"iamBindings,omitempty"`
+	// Verify, when set, checks the new revision of a service after replace.
+	Verify *manifest.Verify `json:"verify,omitempty"`
+	// Rollout, when set, shifts traffic to the new revision of a service in
+	// steps.
+	Rollout *manifest.Rollout `json:"rollout,omitempty"`
+	// RunOnDeploy executes a job after every deploy and waits for the
+	// execution, as if --wait were passed.
+	RunOnDeploy bool `json:"runOnDeploy,omitempty"`
+}
+
+// IAMBinding grants Role to a single Member.
+type IAMBinding struct {
+	Role   string `json:"role"`
+	Member string `json:"member"`
+}
+
+// LoadConfig reads a deploy config written by Assemble.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read deploy config: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("parse deploy config %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("deploy config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c Config) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	switch c.ResourceType {
+	case ResourceTypeService, ResourceTypeJob, ResourceTypeWorker:
+	default:
+		return fmt.Errorf("resourceType must be %q, %q, or %q, got %q",
+			ResourceTypeService, ResourceTypeJob, ResourceTypeWorker, c.ResourceType)
+	}
+	if c.Manifest == "" {
+		return errors.New("manifest is empty")
+	}
+	if c.Verify != nil && c.ResourceType != ResourceTypeService {
+		return fmt.Errorf("verify is only supported for services, not %ss", c.ResourceType)
+	}
+	if c.Rollout != nil && c.ResourceType != ResourceTypeService {
+		return fmt.Errorf("rollout is only supported for services, not %ss", c.ResourceType)
+	}
+	if c.RunOnDeploy && c.ResourceType != ResourceTypeJob {
+		return fmt.Errorf("runOnDeploy is only supported for jobs, not %ss", c.ResourceType)
+	}
+	return nil
+}
+
+// AssembleOptions names the files Assemble combines into a deploy config.
+type AssembleOptions struct {
+	// ConstantsPath is the JSON config written by the rule, without the
+	// manifest.
+	ConstantsPath string
+	ManifestPath  string
+	// DomainMappingsPath, IAMPolicyPath, and DeploySettingsPath are the
+	// optional render outputs holding the DomainMapping manifests, the IAM
+	// policy, and the deploy section.
+	DomainMappingsPath string
+	IAMPolicyPath      string
+	DeploySettingsPath string
+	OutputPath         string
+}
+
+// Assemble embeds the rendered manifest, the domains of the DomainMapping
+// manifests, the IAM policy bindings, and the deploy settings into the
+// rule's constants and writes the resulting deploy config.
+func Assemble(options AssembleOptions) error {
+	data, err := os.ReadFile(options.ConstantsPath)
+	if err != nil {
+		return fmt.Errorf("read deploy constants: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parse deploy constants %s: %w", options.ConstantsPath, err)
+	}
+
+	manifestContent, err := os.ReadFile(options.ManifestPath)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	config.Manifest = string(manifestContent)
+
+	if options.DomainMappingsPath != "" {
+		config.Domains, err = readDomains(options.DomainMappingsPath)
+		if err != nil {
+			return err
+		}
+	}
+	if options.IAMPolicyPath != "" {
+		config.IAMBindings, err = readIAMBindings(options.IAMPolicyPath)
+		if err != nil {
+			return err
+		}
+	}
+	if options.DeploySettingsPath != "" {
+		settings, err := readDeploySettings(options.DeploySettingsPath)
+		if err != nil {
+			return err
+		}
+		config.Verify, config.Rollout = settings.Verify, settings.Rollout
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(config); err != nil {
+		return fmt.Errorf("marshal deploy config: %w", err)
+	}
+	if err := os.WriteFile(options.OutputPath, buffer.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write deploy config: %w", err)
+	}
+	return nil
+}
+
+// readDomains returns the metadata.name of every document in a
+// multi-document DomainMapping file.
+func readDomains(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read domain mappings: %w", err)
+	}
+	var domains []string
+	decoder := yamlv3.NewDecoder(bytes.NewReader(data))
+	for {
+		var mapping struct {
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+		}
+		if err := decoder.Decode(&mapping); errors.Is(err, io.EOF) {
+			return domains, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("parse domain mappings %s: %w", path, err)
+		}
+		if mapping.Metadata.Name != "" {
+			domains = append(domains, mapping.Metadata.Name)
+		}
+	}
+}
+
+// readIAMBindings flattens an IAM policy into one binding per member.
+func readIAMBindings(path string) ([]IAMBinding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read IAM policy: %w", err)
+	}
+	var policy manifest.IAMPolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse IAM policy %s: %w", path, err)
+	}
+	var bindings []IAMBinding
+	for _, binding := range policy.Bindings {
+		for _, member := range binding.Members {
+			bindings = append(bindings, IAMBinding{Role: binding.Role, Member: member})
+		}
+	}
+	return bindings, nil
+}
+
+// readDeploySettings reads the rendered deploy settings; an empty file
+// yields empty settings.
+func readDeploySettings(path string) (manifest.DeploySettings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest.DeploySettings{}, fmt.Errorf("read deploy settings: %w", err)
+	}
+	var settings manifest.DeploySettings
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return manifest.DeploySettings{}, fmt.Errorf("parse deploy settings %s: %w", path, err)
+	}
+	return settings, nil
+}
@@ -0,0 +1,109 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+func (s *deploySuite) TestAssemble() {
+	write := func(dir, name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(s.T(), os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	s.Run("embeds the render outputs", func() {
+		dir := s.T().TempDir()
+		options := AssembleOptions{
+			ConstantsPath: write(dir, "constants.json", `{"name": "myapp", "resourceType": "service", "regions": ["us-central1"], "imagePlaceholder": "placeholder"}`),
+			ManifestPath:  write(dir, "manifest.yaml", "apiVersion: serving.knative.dev/v1\nkind: Service\n"),
+			DomainMappingsPath: write(dir, "domains.yaml", `apiVersion: domains.cloudrun.com/v1
+kind: DomainMapping
+metadata:
+  name: api.example.com
+---
+apiVersion: domains.cloudrun.com/v1
+kind: DomainMapping
+metadata:
+  name: www.example.com
+`),
+			IAMPolicyPath: write(dir, "iam.yaml", `bindings:
+- members:
+  - allUsers
+  - group:eng@example.com
+  role: roles/run.invoker
+`),
+			DeploySettingsPath: write(dir, "deploy.yaml", "verify:\n  path: /healthz\n  rollback: true\n  tag: verify\n  timeoutSeconds: 120\n"),
+			OutputPath:         filepath.Join(dir, "deploy.json"),
+		}
+		require.NoError(s.T(), Assemble(options))
+
+		config, err := LoadConfig(options.OutputPath)
+		require.NoError(s.T(), err)
+		timeout, rollback := 120, true
+		require.Equal(s.T(), Config{
+			Name:             "myapp",
+			ResourceType:     "service",
+			Regions:          []string{"us-central1"},
+			ImagePlaceholder: "placeholder",
+			Manifest:         "apiVersion: serving.knative.dev/v1\nkind: Service\n",
+			Domains:          []string{"api.example.com", "www.example.com"},
+			IAMBindings: []IAMBinding{
+				{Role: "roles/run.invoker", Member: "allUsers"},
+				{Role: "roles/run.invoker", Member: "group:eng@example.com"},
+			},
+			Verify: &manifest.Verify{Path: "/healthz", Tag: "verify", TimeoutSeconds: &timeout, Rollback: &rollback},
+		}, config)
+	})
+
+	s.Run("accepts empty domain mappings and IAM policy", func() {
+		dir := s.T().TempDir()
+		options := AssembleOptions{
+			ConstantsPath:      write(dir, "constants.json", `{"name": "myjob", "resourceType": "job"}`),
+			ManifestPath:       write(dir, "manifest.yaml", "kind: Job\n"),
+			DomainMappingsPath: write(dir, "domains.yaml", ""),
+			IAMPolicyPath:      write(dir, "iam.yaml", ""),
+			DeploySettingsPath: write(dir, "deploy.yaml", ""),
+			OutputPath:         filepath.Join(dir, "deploy.json"),
+		}
+		require.NoError(s.T(), Assemble(options))
+
+		config, err := LoadConfig(options.OutputPath)
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), config.Domains)
+		require.Empty(s.T(), config.IAMBindings)
+		require.Nil(s.T(), config.Verify)
+	})
+
+	s.Run("rejects verification and rollouts of other resource types", func() {
+		dir := s.T().TempDir()
+		err := Assemble(AssembleOptions{
+			ConstantsPath:      write(dir, "constants.json", `{"name": "myjob", "resourceType": "job"}`),
+			ManifestPath:       write(dir, "manifest.yaml", "kind: Job\n"),
+			DeploySettingsPath: write(dir, "deploy.yaml", "verify:\n  timeoutSeconds: 60\n"),
+			OutputPath:         filepath.Join(dir, "deploy.json"),
+		})
+		require.ErrorContains(s.T(), err, "verify is only supported for services, not jobs")
+
+		err = Assemble(AssembleOptions{
+			ConstantsPath:      write(dir, "constants.json", `{"name": "myworker", "resourceType": "worker"}`),
+			ManifestPath:       write(dir, "manifest.yaml", "kind: WorkerPool\n"),
+			DeploySettingsPath: write(dir, "deploy.yaml", "rollout:\n  steps:\n  - percent: 100\n"),
+			OutputPath:         filepath.Join(dir, "deploy.json"),
+		})
+		require.ErrorContains(s.T(), err, "rollout is only supported for services, not workers")
+	})
+
+	s.Run("rejects unknown resource types", func() {
+		dir := s.T().TempDir()
+		err := Assemble(AssembleOptions{
+			ConstantsPath: write(dir, "constants.json", `{"name": "myapp", "resourceType": "function"}`),
+			ManifestPath:  write(dir, "manifest.yaml", "kind: Service\n"),
+			OutputPath:    filepath.Join(dir, "deploy.json"),
+		})
+		require.ErrorContains(s.T(), err, `resourceType must be`)
+	})
+}
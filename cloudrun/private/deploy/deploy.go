@@ -0,0 +1,583 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/private/resource"
+)
+
+// Deployer deploys the manifest of a Config with gcloud.
+type Deployer struct {
+	config   Config
+	executor Executor
+	// runfiles is the runfiles directory of the .deploy target, which holds
+	// the push binary and gcloud.
+	runfiles string
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	getenv   func(string) string
+	// httpClient, now, and sleep drive the post-deploy verification.
+	httpClient *http.Client
+	now        func() time.Time
+	sleep      func(context.Context, time.Duration) error
+}
+
+// NewDeployer creates a Deployer that runs commands through executor,
+// defaulting to OSExecutor.
+func NewDeployer(config Config, executor Executor, runfiles string) *Deployer {
+	if executor == nil {
+		executor = OSExecutor{}
+	}
+	return &Deployer{
+		config:   config,
+		executor: executor,
+		runfiles: runfiles,
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		getenv:   os.Getenv,
+
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		now:        time.Now,
+		sleep:      sleepContext,
+	}
+}
+
+// sleepContext pauses for duration or until ctx is done.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// runtimeOptions are the arguments passed to the .deploy target.
+type runtimeOptions struct {
+	// images override container images; they must be pinned by digest.
+	images []resource.ImagePatch
+	// gcloudArgs are appended to the replace command.
+	gcloudArgs []string
+	// dryRun prints what would be deployed without pushing or deploying.
+	dryRun bool
+	// plan diffs the manifest against the deployed resource and asks for
+	// confirmation unless yes is set.
+	plan bool
+	yes  bool
+	// resume continues and abort rolls back an interrupted rollout.
+	resume bool
+	abort  bool
+	// execute starts a job execution after the deploy and wait also waits
+	// for it; noExecute skips the execution of a job that runs on deploy.
+	execute   bool
+	wait      bool
+	noExecute bool
+	execution executionOverrides
+}
+
+// valueFlags are the runtime flags that take a value, as --flag=value or
+// --flag value.
+var valueFlags = []string{"--image", "--exec-arg", "--exec-env", "--exec-tasks", "--exec-task-timeout"}
+
+// splitValueFlag returns the name and value of a value flag at args[*i],
+// advancing *i past a separate value. ok is false for other arguments.
+func splitValueFlag(args []string, i *int) (name, value string, ok bool, err error) {
+	arg := args[*i]
+	for _, flag := range valueFlags {
+		switch {
+		case strings.HasPrefix(arg, flag+"="):
+			return flag, strings.TrimPrefix(arg, flag+"="), true, nil
+		case arg == flag:
+			if *i+1 == len(args) {
+				return "", "", false, fmt.Errorf("%s requires a value", flag)
+			}
+			*i++
+			return flag, args[*i], true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+func parseArgs(args []string) (runtimeOptions, error) {
+	var options runtimeOptions
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dry-run":
+			options.dryRun = true
+			continue
+		case "--plan":
+			options.plan = true
+			continue
+		case "--yes":
+			options.yes = true
+			continue
+		case "--resume":
+			options.resume = true
+			continue
+		case "--abort":
+			options.abort = true
+			continue
+		case "--execute":
+			options.execute = true
+			continue
+		case "--wait":
+			options.wait = true
+			continue
+		case "--no-execute":
+			options.noExecute = true
+			continue
+		}
+		name, value, ok, err := splitValueFlag(args, &i)
+		if err != nil {
+			return runtimeOptions{}, err
+		}
+		if !ok {
+			options.gcloudArgs = append(options.gcloudArgs, args[i])
+			continue
+		}
+		switch name {
+		case "--image":
+			patch, err := resource.ParseImagePatch(value)
+			if err != nil {
+				return runtimeOptions{}, fmt.Errorf("--image: %w", err)
+			}
+			options.images = append(options.images, patch)
+		case "--exec-arg":
+			options.execution.args = append(options.execution.args, value)
+		case "--exec-env":
+			if key, _, found := strings.Cut(value, "="); !found || key == "" {
+				return runtimeOptions{}, fmt.Errorf("--exec-env must be KEY=VALUE, got %q", value)
+			}
+			options.execution.env = append(options.execution.env, value)
+		case "--exec-tasks":
+			tasks, err := strconv.Atoi(value)
+			if err != nil || tasks < 1 {
+				return runtimeOptions{}, fmt.Errorf("--exec-tasks must be a positive integer, got %q", value)
+			}
+			options.execution.tasks = tasks
+		case "--exec-task-timeout":
+			if value == "" {
+				return runtimeOptions{}, errors.New("--exec-task-timeout requires a value")
+			}
+			options.execution.taskTimeout = value
+		}
+	}
+	if options.resume && options.abort {
+		return runtimeOptions{}, errors.New("--resume and --abort are mutually exclusive")
+	}
+	if options.noExecute && (options.execute || options.wait) {
+		return runtimeOptions{}, errors.New("--no-execute cannot be combined with --execute or --wait")
+	}
+	return options, nil
+}
+
+// overridesMainImage reports whether the main container's image is
+// replaced at runtime, which makes the image push pointless.
+func (o runtimeOptions) overridesMainImage() bool {
+	for _, patch := range o.images {
+		if patch.Container == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Run deploys the resource: it applies the runtime image overrides, pushes
+// the image, runs gcloud replace, verifies the new revision and rolls it out
+// when configured, maps domains, grants IAM bindings, and finally executes
+// a job when requested. args are the arguments passed to the .deploy
+// target; --image [<container>=]<ref>, --dry-run, --plan, --yes, --resume,
+// --abort, --execute, --wait, --no-execute, and the --exec-* overrides are
+// handled here and everything else is passed to gcloud replace.
+func (d *Deployer) Run(ctx context.Context, args []string) error {
+	options, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+	if err := d.checkExecution(options); err != nil {
+		return err
+	}
+	gcloud, err := d.gcloudPath()
+	if err != nil {
+		return err
+	}
+	if options.abort {
+		return d.abortRollout(ctx, gcloud)
+	}
+	if options.resume {
+		if err := d.resumeRollout(ctx, gcloud); err != nil {
+			return err
+		}
+		if err := d.mapDomains(ctx, gcloud); err != nil {
+			return err
+		}
+		return d.grantIAM(ctx, gcloud)
+	}
+	manifestContent, err := d.resolveManifest(options.images)
+	if err != nil {
+		return err
+	}
+	changed := false
+	if options.plan {
+		d.installComponents(ctx, gcloud)
+		if changed, err = d.plan(ctx, gcloud, manifestContent); err != nil {
+			return err
+		}
+	}
+	if options.dryRun {
+		d.printDryRun(ctx, gcloud, manifestContent, options)
+		return nil
+	}
+	if changed && !options.yes && !d.confirm() {
+		return errAborted
+	}
+	if d.config.Rollout != nil {
+		if err := d.checkNoRolloutInProgress(); err != nil {
+			return err
+		}
+	}
+	if err := d.push(ctx, options.overridesMainImage()); err != nil {
+		return err
+	}
+
+	d.printHeader(options.images)
+	if !options.plan {
+		d.installComponents(ctx, gcloud)
+	}
+
+	var previous []trafficTarget
+	if d.config.Verify != nil || d.config.Rollout != nil {
+		previous = d.servingTraffic(ctx, gcloud)
+	}
+	// A rollout deploys the new revision without traffic. The first
+	// revision of a service has nothing to roll out from.
+	rollout := d.config.Rollout != nil && len(previous) > 0
+	if rollout {
+		if manifestContent, err = canaryManifest(manifestContent, previous, d.config.Rollout.Tag); err != nil {
+			return err
+		}
+	} else if d.config.Rollout != nil {
+		_, _ = fmt.Fprintln(d.stdout, "No revision serves traffic yet; deploying without a rollout")
+	}
+
+	manifestPath, err := writeTempManifest(manifestContent)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(manifestPath)
+
+	if err := d.replace(ctx, gcloud, manifestPath, options.gcloudArgs); err != nil {
+		return err
+	}
+	if d.config.Verify != nil {
+		if err := d.verify(ctx, gcloud, previous); err != nil {
+			if rollout {
+				d.removeTag(ctx, gcloud, d.config.Rollout.Tag)
+			}
+			return err
+		}
+	}
+	if rollout {
+		if err := d.startRollout(ctx, gcloud, previous); err != nil {
+			return err
+		}
+	}
+	if err := d.mapDomains(ctx, gcloud); err != nil {
+		return err
+	}
+	if err := d.grantIAM(ctx, gcloud); err != nil {
+		return err
+	}
+	return d.executeJob(ctx, gcloud, options.executionMode(d.config.RunOnDeploy), options.execution)
+}
+
+func (d *Deployer) gcloudPath() (string, error) {
+	if d.config.GcloudRunfile == "" {
+		return "gcloud", nil
+	}
+	path := filepath.Join(d.runfiles, d.config.GcloudRunfile)
+	if info, err := os.Stat(path); err != nil || info.Mode()&0o111 == 0 {
+		return "", fmt.Errorf("gcloud binary not found at %s", path)
+	}
+	return path, nil
+}
+
+// resolveManifest applies the image overrides, and refuses a manifest that
+// still references the placeholder image afterwards.
+func (d *Deployer) resolveManifest(images []resource.ImagePatch) (string, error) {
+	manifestContent := d.config.Manifest
+	if len(images) > 0 {
+		patched, err := resource.PatchImages([]byte(manifestContent), images)
+		if err != nil {
+			return "", fmt.Errorf("--image: %w", err)
+		}
+		manifestContent = string(patched)
+	}
+	if d.config.ImagePlaceholder == "" {
+		return manifestContent, nil
+	}
+	containerImages, err := resource.ContainerImages([]byte(manifestContent))
+	if err != nil {
+		return "", err
+	}
+	for _, image := range containerImages {
+		if image == d.config.ImagePlaceholder {
+			return "", fmt.Errorf("manifest still references the placeholder image %s\n"+
+				"       Configure image/image_repo on the rule or pass --image=<repo>@sha256:<digest>.", d.config.ImagePlaceholder)
+		}
+	}
+	return manifestContent, nil
+}
+
+// push runs the image push binary unless the main image is overridden at
+// runtime or SKIP_PUSH=1.
+func (d *Deployer) push(ctx context.Context, imageOverridden bool) error {
+	if d.config.PushRunfile == "" {
+		return nil
+	}
+	if imageOverridden {
+		_, _ = fmt.Fprint(d.stdout, "Runtime image override provided; skipping push step.\n\n")
+		return nil
+	}
+	if d.getenv("SKIP_PUSH") == "1" {
+		return nil
+	}
+	path := filepath.Join(d.runfiles, d.config.PushRunfile)
+	if info, err := os.Stat(path); err != nil || info.Mode()&0o111 == 0 {
+		return fmt.Errorf("push binary not found: %s", path)
+	}
+	_, _ = fmt.Fprintf(d.stdout, "Pushing image via %s\n", path)
+	if err := d.executor.Run(ctx, Command{Path: path, Stdin: d.stdin, Stdout: d.stdout, Stderr: d.stderr}); err != nil {
+		return fmt.Errorf("push image: %w", err)
+	}
+	_, _ = fmt.Fprintln(d.stdout)
+	return nil
+}
+
+func (d *Deployer) printHeader(images []resource.ImagePatch) {
+	_, _ = fmt.Fprintf(d.stdout, "Deploying Cloud Run %s: %s\n", d.config.ResourceType, d.config.Name)
+	if d.config.Project != "" {
+		_, _ = fmt.Fprintf(d.stdout, "  Project: %s\n", d.config.Project)
+	}
+	if len(d.config.Regions) > 0 {
+		_, _ = fmt.Fprintf(d.stdout, "  Region:  %s\n", strings.Join(d.config.Regions, ","))
+	}
+	d.printImageOverrides(images)
+	_, _ = fmt.Fprintln(d.stdout)
+}
+
+func (d *Deployer) printImageOverrides(images []resource.ImagePatch) {
+	for _, patch := range images {
+		if patch.Container == "" {
+			_, _ = fmt.Fprintf(d.stdout, "  Image:   %s (runtime override)\n", patch.Image)
+		} else {
+			_, _ = fmt.Fprintf(d.stdout, "  Image:   %s=%s (runtime override)\n", patch.Container, patch.Image)
+		}
+	}
+}
+
+// installComponents makes sure the gcloud release track is installed.
+// Failures are ignored: a gcloud without the component manager (such as a
+// distribution package) usually ships every track already.
+func (d *Deployer) installComponents(ctx context.Context, gcloud string) {
+	if d.config.GcloudTrack == "" {
+		return
+	}
+	_ = d.executor.Run(ctx, Command{
+		Path:   gcloud,
+		Args:   []string{"components", "install", d.config.GcloudTrack, "--quiet"},
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	})
+}
+
+func writeTempManifest(content string) (string, error) {
+	file, err := os.CreateTemp("", "cloudrun-*.yaml")
+	if err != nil {
+		return "", fmt.Errorf("create manifest file: %w", err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("write manifest file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("write manifest file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// multiRegion reports whether the resource is a service deployed with
+// gcloud run multi-region-services.
+func (d *Deployer) multiRegion() bool {
+	return d.config.ResourceType == ResourceTypeService && len(d.config.Regions) > 1
+}
+
+// gcloudGroup is the gcloud run command group of the resource type.
+func (d *Deployer) gcloudGroup() string {
+	switch {
+	case d.config.ResourceType == ResourceTypeJob:
+		return "jobs"
+	case d.config.ResourceType == ResourceTypeWorker:
+		return "worker-pools"
+	case d.multiRegion():
+		return "multi-region-services"
+	default:
+		return "services"
+	}
+}
+
+// regionFlag is --regions=<a,b> for multi-region services and
+// --region=<first region> otherwise.
+func (d *Deployer) regionFlag() string {
+	switch {
+	case len(d.config.Regions) == 0:
+		return ""
+	case d.multiRegion():
+		return "--regions=" + strings.Join(d.config.Regions, ",")
+	default:
+		return "--region=" + d.config.Regions[0]
+	}
+}
+
+// locationArgs returns the region and project flags of commands that
+// address the deployed resource.
+func (d *Deployer) locationArgs() []string {
+	var args []string
+	if flag := d.regionFlag(); flag != "" {
+		args = append(args, flag)
+	}
+	if d.config.Project != "" {
+		args = append(args, "--project="+d.config.Project)
+	}
+	return args
+}
+
+func (d *Deployer) replace(ctx context.Context, gcloud, manifestPath string, extraArgs []string) error {
+	if err := d.executor.Run(ctx, d.replaceCommand(gcloud, manifestPath, extraArgs)); err != nil {
+		return fmt.Errorf("gcloud run %s replace: %w", d.gcloudGroup(), err)
+	}
+	return nil
+}
+
+func (d *Deployer) replaceCommand(gcloud, manifestPath string, extraArgs []string) Command {
+	var args []string
+	if d.config.GcloudTrack != "" {
+		args = append(args, d.config.GcloudTrack)
+	}
+	args = append(args, "run", d.gcloudGroup(), "replace", manifestPath)
+
+	command := Command{Path: gcloud, Stdin: d.stdin, Stdout: d.stdout, Stderr: d.stderr}
+	// Worker pools: 'replace' does not accept --region; pass it through the
+	// environment instead.
+	if d.config.ResourceType == ResourceTypeWorker && len(d.config.Regions) > 0 {
+		command.Env = []string{"CLOUDSDK_RUN_REGION=" + d.config.Regions[0]}
+		if d.config.Project != "" {
+			args = append(args, "--project="+d.config.Project)
+		}
+	} else {
+		args = append(args, d.locationArgs()...)
+	}
+	args = append(args, "--quiet")
+	command.Args = append(args, extraArgs...)
+	return command
+}
+
+// mapDomains creates the domain mappings that do not exist yet. Mappings
+// that already route to this service are left alone, so re-running the
+// deploy is safe.
+func (d *Deployer) mapDomains(ctx context.Context, gcloud string) error {
+	for _, domain := range d.config.Domains {
+		var route, listErr bytes.Buffer
+		listArgs := append([]string{"beta", "run", "domain-mappings", "list", "--filter=metadata.name=" + domain}, d.locationArgs()...)
+		// list prints nothing for a domain that is not mapped yet, so any
+		// failure is a real error.
+		if err := d.executor.Run(ctx, Command{
+			Path:   gcloud,
+			Args:   append(listArgs, "--format=value(spec.routeName)"),
+			Stdout: &route,
+			Stderr: &listErr,
+		}); err != nil {
+			return fmt.Errorf("look up domain mapping %s: %w: %s", domain, err, strings.TrimSpace(listErr.String()))
+		}
+
+		switch routeName := strings.TrimSpace(route.String()); routeName {
+		case d.config.Name:
+			_, _ = fmt.Fprintf(d.stdout, "Domain mapping %s already routes to %s\n", domain, d.config.Name)
+		case "":
+			_, _ = fmt.Fprintf(d.stdout, "Mapping domain %s to %s\n", domain, d.config.Name)
+			createArgs := append([]string{"beta", "run", "domain-mappings", "create", "--service", d.config.Name, "--domain", domain}, d.locationArgs()...)
+			if err := d.executor.Run(ctx, Command{
+				Path:   gcloud,
+				Args:   append(createArgs, "--quiet"),
+				Stdin:  d.stdin,
+				Stdout: d.stdout,
+				Stderr: d.stderr,
+			}); err != nil {
+				return fmt.Errorf("map domain %s: %w", domain, err)
+			}
+		default:
+			return fmt.Errorf("domain %s is mapped to service %s, not %s", domain, routeName, d.config.Name)
+		}
+	}
+	return nil
+}
+
+// grantIAM adds every IAM binding. Bindings are only ever added: removing a
+// member from the config does not revoke its role. Multi-region services
+// are granted region by region.
+func (d *Deployer) grantIAM(ctx context.Context, gcloud string) error {
+	if len(d.config.IAMBindings) == 0 {
+		return nil
+	}
+	group := d.gcloudGroup()
+	if d.multiRegion() {
+		group = "services"
+	}
+	regions := d.config.Regions
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+	for _, region := range regions {
+		var location []string
+		if region != "" {
+			location = append(location, "--region="+region)
+		}
+		if d.config.Project != "" {
+			location = append(location, "--project="+d.config.Project)
+		}
+		for _, binding := range d.config.IAMBindings {
+			if region == "" {
+				_, _ = fmt.Fprintf(d.stdout, "Granting %s to %s\n", binding.Role, binding.Member)
+			} else {
+				_, _ = fmt.Fprintf(d.stdout, "Granting %s to %s in %s\n", binding.Role, binding.Member, region)
+			}
+			args := append([]string{"run", group, "add-iam-policy-binding", d.config.Name,
+				"--member=" + binding.Member, "--role=" + binding.Role}, location...)
+			if err := d.executor.Run(ctx, Command{
+				Path:   gcloud,
+				Args:   append(args, "--quiet"),
+				Stdout: io.Discard,
+				Stderr: d.stderr,
+			}); err != nil {
+				return fmt.Errorf("grant %s to %s: %w", binding.Role, binding.Member, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+type deploySuite struct {
+	suite.Suite
+}
+
+func TestDeploySuite(t *testing.T) {
+	suite.Run(t, new(deploySuite))
+}
+
+// exitError is returned by fakeExecutor for commands that fail.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+// recordedCommand is a command run by fakeExecutor, with the content of the
+// manifest it was given, if any.
+type recordedCommand struct {
+	Command
+	manifest string
+}
+
+// fakeExecutor records every command. respond, when set, writes the
+// command's output and returns its error.
+type fakeExecutor struct {
+	commands []recordedCommand
+	respond  func(command Command) (string, error)
+}
+
+func (f *fakeExecutor) Run(_ context.Context, command Command) error {
+	recorded := recordedCommand{Command: command}
+	for _, arg := range command.Args {
+		if strings.HasSuffix(arg, ".yaml") {
+			content, err := os.ReadFile(arg)
+			if err == nil {
+				recorded.manifest = string(content)
+			}
+		}
+	}
+	f.commands = append(f.commands, recorded)
+	if f.respond == nil {
+		return nil
+	}
+	output, err := f.respond(command)
+	if command.Stdout != nil {
+		_, _ = io.WriteString(command.Stdout, output)
+	}
+	return err
+}
+
+// commandLines returns every command except components install as
+// space-joined arguments.
+func (f *fakeExecutor) commandLines() []string {
+	var lines []string
+	for _, command := range f.commands {
+		if len(command.Args) > 0 && command.Args[0] == "components" {
+			continue
+		}
+		lines = append(lines, strings.Join(command.Args, " "))
+	}
+	return lines
+}
+
+func testConfig(resourceType string, regions ...string) Config {
+	return Config{
+		Name:             "myapp",
+		ResourceType:     resourceType,
+		Regions:          regions,
+		Project:          "my-project",
+		GcloudTrack:      "beta",
+		ImagePlaceholder: "rules-cloudrun.invalid/override-required:latest",
+		Manifest:         "spec:\n  containers:\n  - image: example.com/myapp@" + testDigest + "\n",
+	}
+}
+
+func (s *deploySuite) deploy(config Config, executor *fakeExecutor, args ...string) (string, error) {
+	return s.run(config, executor, "", args...)
+}
+
+// run deploys config with answer on stdin.
+func (s *deploySuite) run(config Config, executor Executor, answer string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	deployer := NewDeployer(config, executor, s.T().TempDir())
+	deployer.stdin = strings.NewReader(answer)
+	deployer.stdout = &stdout
+	deployer.stderr = io.Discard
+	deployer.getenv = func(string) string { return "" }
+	err := deployer.Run(context.Background(), args)
+	return stdout.String(), err
+}
+
+func (s *deploySuite) TestReplace() {
+	cases := []struct {
+		name     string
+		config   Config
+		expected string
+		env      []string
+	}{
+		{
+			name:     "service",
+			config:   testConfig(ResourceTypeService, "us-central1"),
+			expected: "beta run services replace <manifest> --region=us-central1 --project=my-project --quiet",
+		},
+		{
+			name:     "multi-region service",
+			config:   testConfig(ResourceTypeService, "us-central1", "europe-west1"),
+			expected: "beta run multi-region-services replace <manifest> --regions=us-central1,europe-west1 --project=my-project --quiet",
+		},
+		{
+			name:     "job",
+			config:   testConfig(ResourceTypeJob, "us-central1"),
+			expected: "beta run jobs replace <manifest> --region=us-central1 --project=my-project --quiet",
+		},
+		{
+			name:     "worker pool passes the region through the environment",
+			config:   testConfig(ResourceTypeWorker, "us-central1"),
+			expected: "beta run worker-pools replace <manifest> --project=my-project --quiet",
+			env:      []string{"CLOUDSDK_RUN_REGION=us-central1"},
+		},
+	}
+
+	for _, tc := range cases {
+		s.Run(tc.name, func() {
+			executor := &fakeExecutor{}
+			_, err := s.deploy(tc.config, executor)
+			require.NoError(s.T(), err)
+			require.Len(s.T(), executor.commands, 2)
+			require.Equal(s.T(), []string{"components", "install", "beta", "--quiet"}, executor.commands[0].Args)
+
+			replace := executor.commands[1]
+			require.Equal(s.T(), "gcloud", replace.Path)
+			manifestPath := replace.Args[4]
+			require.Equal(s.T(), tc.expected, strings.Replace(strings.Join(replace.Args, " "), manifestPath, "<manifest>", 1))
+			require.Equal(s.T(), tc.env, replace.Env)
+			require.Equal(s.T(), tc.config.Manifest, replace.manifest)
+			_, err = os.Stat(manifestPath)
+			require.True(s.T(), os.IsNotExist(err), "the temporary manifest is removed")
+		})
+	}
+
+	s.Run("passes extra arguments to gcloud", func() {
+		executor := &fakeExecutor{}
+		_, err := s.deploy(testConfig(ResourceTypeService, "us-central1"), executor, "--verbosity=debug")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "--verbosity=debug", executor.commands[1].Args[len(executor.commands[1].Args)-1])
+	})
+
+	s.Run("returns the gcloud exit code", func() {
+		executor := &fakeExecutor{respond: func(Command) (string, error) { return "", exitError(3) }}
+		_, err := s.deploy(testConfig(ResourceTypeService, "us-central1"), executor)
+		require.Error(s.T(), err)
+		var exitErr interface{ ExitCode() int }
+		require.ErrorAs(s.T(), err, &exitErr)
+		require.Equal(s.T(), 3, exitErr.ExitCode())
+	})
+
+	s.Run("uses gcloud from runfiles", func() {
+		runfiles := s.T().TempDir()
+		gcloud := filepath.Join(runfiles, "gcloud_sdk", "gcloud")
+		require.NoError(s.T(), os.MkdirAll(filepath.Dir(gcloud), 0o755))
+		require.NoError(s.T(), os.WriteFile(gcloud, []byte("#!/bin/sh\n"), 0o755))
+
+		config := testConfig(ResourceTypeService, "us-central1")
+		config.GcloudRunfile = "gcloud_sdk/gcloud"
+		executor := &fakeExecutor{}
+		deployer := NewDeployer(config, executor, runfiles)
+		deployer.stdout = io.Discard
+		require.NoError(s.T(), deployer.Run(context.Background(), nil))
+		require.Equal(s.T(), gcloud, executor.commands[1].Path)
+
+		config.GcloudRunfile = "missing/gcloud"
+		err := NewDeployer(config, executor, runfiles).Run(context.Background(), nil)
+		require.ErrorContains(s.T(), err, "gcloud binary not found")
+	})
+}
+
+func (s *deploySuite) TestImageOverride() {
+	override := "example.com/other@sha256:" + strings.Repeat("f", 64)
+
+	s.Run("rewrites the manifest image", func() {
+		executor := &fakeExecutor{}
+		output, err := s.deploy(testConfig(ResourceTypeService, "us-central1"), executor, "--image", override)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), executor.commands[1].manifest, "image: "+override+"\n")
+		require.Contains(s.T(), output, "Image:   "+override+" (runtime override)")
+	})
+
+	s.Run("accepts --image=<ref>", func() {
+		executor := &fakeExecutor{}
+		_, err := s.deploy(testConfig(ResourceTypeService, "us-central1"), executor, "--image="+override)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), executor.commands[1].manifest, "image: "+override+"\n")
+	})
+
+	s.Run("rejects tags", func() {
+		executor := &fakeExecutor{}
+		_, err := s.deploy(testConfig(ResourceTypeService, "us-central1"), executor, "--image=example.com/other:latest")
+		require.ErrorContains(s.T(), err, "must be a digest reference")
+		require.Empty(s.T(), executor.commands)
+	})
+
+	s.Run("requires a value", func() {
+		_, err := s.deploy(testConfig(ResourceTypeService, "us-central1"), &fakeExecutor{}, "--image")
+		require.ErrorContains(s.T(), err, "--image requires a value")
+	})
+
+	s.Run("overrides a named container", func() {
+		config := testConfig(ResourceTypeService, "us-central1")
+		config.Manifest = "spec:\n  containers:\n  - image: example.com/myapp@" + testDigest + "\n    name: app\n    ports:\n    - containerPort: 8080\n" +
+			"  - image: example.com/proxy@" + testDigest + "\n    name: proxy\n"
+		executor := &fakeExecutor{}
+		output, err := s.deploy(config, executor, "--image", "proxy="+override)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), executor.commands[1].manifest, "image: example.com/myapp@"+testDigest+"\n")
+		require.Contains(s.T(), executor.commands[1].manifest, "image: "+override+"\n    name: proxy\n")
+		require.Contains(s.T(), output, "Image:   proxy="+override+" (runtime override)")
+
+		_, err = s.deploy(config, &fakeExecutor{}, "--image", "cache="+override)
+		require.ErrorContains(s.T(), err, `no container named "cache" (containers: app, proxy)`)
+	})
+
+	s.Run("refuses the placeholder image", func() {
+		config := testConfig(ResourceTypeService, "us-central1")
+		config.Manifest = "spec:\n  containers:\n  - image: " + config.ImagePlaceholder + "\n"
+		executor := &fakeExecutor{}
+		_, err := s.deploy(config, executor)
+		require.ErrorContains(s.T(), err, "still references the placeholder image")
+		require.Empty(s.T(), executor.commands)
+
+		_, err = s.deploy(config, executor, "--image="+override)
+		require.NoError(s.T(), err)
+	})
+
+	s.Run("refuses a quoted or flow-style placeholder image", func() {
+		config := testConfig(ResourceTypeService, "us-central1")
+		for _, manifest := range []string{
+			"spec:\n  containers:\n  - image: \"" + config.ImagePlaceholder + "\"\n",
+			"spec:\n  containers:\n  - {name: app, image: '" + config.ImagePlaceholder + "'}\n",
+		} {
+			config.Manifest = manifest
+			_, err := s.deploy(config, &fakeExecutor{})
+			require.ErrorContains(s.T(), err, "still references the placeholder image")
+		}
+	})
+
+	s.Run("allows the placeholder text outside container images", func() {
+		config := testConfig(ResourceTypeService, "us-central1")
+		config.Manifest = "spec:\n  containers:\n  - image: example.com/myapp@" + testDigest + "\n    env:\n    - name: NOTE\n      value: 'image: " + config.ImagePlaceholder + "'\n"
+		_, err := s.deploy(config, &fakeExecutor{})
+		require.NoError(s.T(), err)
+	})
+}
+
+func (s *deploySuite) TestPush() {
+	runfiles := s.T().TempDir()
+	push := filepath.Join(runfiles, "app", "image.push")
+	require.NoError(s.T(), os.MkdirAll(filepath.Dir(push), 0o755))
+	require.NoError(s.T(), os.WriteFile(push, []byte("#!/bin/sh\n"), 0o755))
+
+	config := testConfig(ResourceTypeService, "us-central1")
+	config.PushRunfile = "app/image.push"
+	run := func(env string, args ...string) (*fakeExecutor, string, error) {
+		executor := &fakeExecutor{}
+		var stdout bytes.Buffer
+		deployer := NewDeployer(config, executor, runfiles)
+		deployer.stdout = &stdout
+		deployer.getenv = func(string) string { return env }
+		err := deployer.Run(context.Background(), args)
+		return executor, stdout.String(), err
+	}
+
+	s.Run("pushes before deploying", func() {
+		executor, _, err := run("")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), push, executor.commands[0].Path)
+		require.Len(s.T(), executor.commands, 3)
+	})
+
+	s.Run("skips the push with SKIP_PUSH=1", func() {
+		executor, _, err := run("1")
+		require.NoError(s.T(), err)
+		require.Len(s.T(), executor.commands, 2)
+	})
+
+	s.Run("skips the push with a runtime image", func() {
+		executor, output, err := run("", "--image=example.com/other@"+testDigest)
+		require.NoError(s.T(), err)
+		require.Len(s.T(), executor.commands, 2)
+		require.Contains(s.T(), output, "skipping push step")
+	})
+}
+
+func (s *deploySuite) TestMapDomains() {
+	config := testConfig(ResourceTypeService, "us-central1")
+	config.Domains = []string{"api.example.com", "www.example.com"}
+
+	s.Run("creates missing mappings and keeps existing ones", func() {
+		executor := &fakeExecutor{respond: func(command Command) (string, error) {
+			if strings.Contains(strings.Join(command.Args, " "), "list --filter=metadata.name=api.example.com") {
+				return "myapp\n", nil
+			}
+			return "", nil
+		}}
+		output, err := s.deploy(config, executor)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{
+			"beta run services replace " + executor.commands[1].Args[4] + " --region=us-central1 --project=my-project --quiet",
+			"beta run domain-mappings list --filter=metadata.name=api.example.com --region=us-central1 --project=my-project --format=value(spec.routeName)",
+			"beta run domain-mappings list --filter=metadata.name=www.example.com --region=us-central1 --project=my-project --format=value(spec.routeName)",
+			"beta run domain-mappings create --service myapp --domain www.example.com --region=us-central1 --project=my-project --quiet",
+		}, executor.commandLines())
+		require.Contains(s.T(), output, "Domain mapping api.example.com already routes to myapp")
+	})
+
+	s.Run("fails on a domain mapped to another service", func() {
+		executor := &fakeExecutor{respond: func(command Command) (string, error) {
+			if command.Args[len(command.Args)-1] == "--format=value(spec.routeName)" {
+				return "other\n", nil
+			}
+			return "", nil
+		}}
+		_, err := s.deploy(config, executor)
+		require.ErrorContains(s.T(), err, "domain api.example.com is mapped to service other, not myapp")
+	})
+
+	s.Run("fails when the lookup fails", func() {
+		executor := &fakeExecutor{respond: func(command Command) (string, error) {
+			if command.Args[len(command.Args)-1] == "--format=value(spec.routeName)" {
+				_, _ = io.WriteString(command.Stderr, "ERROR: (gcloud.beta.run.domain-mappings.list) PERMISSION_DENIED: Permission 'run.domainmappings.list' denied\n")
+				return "", exitError(1)
+			}
+			return "", nil
+		}}
+		_, err := s.deploy(config, executor)
+		require.ErrorContains(s.T(), err, "look up domain mapping api.example.com: exit status 1: ERROR: (gcloud.beta.run.domain-mappings.list) PERMISSION_DENIED")
+		for _, line := range executor.commandLines() {
+			require.NotContains(s.T(), line, "domain-mappings create")
+		}
+	})
+}
+
+func (s *deploySuite) TestGrantIAM() {
+	bindings := []IAMBinding{
+		{Role: "roles/run.invoker", Member: "allUsers"},
+		{Role: "roles/run.invoker", Member: "group:eng@example.com"},
+	}
+
+	s.Run("grants each binding", func() {
+		config := testConfig(ResourceTypeJob, "us-central1")
+		config.IAMBindings = bindings
+		executor := &fakeExecutor{}
+		_, err := s.deploy(config, executor)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{
+			"run jobs add-iam-policy-binding myapp --member=allUsers --role=roles/run.invoker --region=us-central1 --project=my-project --quiet",
+			"run jobs add-iam-policy-binding myapp --member=group:eng@example.com --role=roles/run.invoker --region=us-central1 --project=my-project --quiet",
+		}, executor.commandLines()[1:])
+	})
+
+	s.Run("grants multi-region services region by region", func() {
+		config := testConfig(ResourceTypeService, "us-central1", "europe-west1")
+		config.IAMBindings = bindings[:1]
+		executor := &fakeExecutor{}
+		_, err := s.deploy(config, executor)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{
+			"run services add-iam-policy-binding myapp --member=allUsers --role=roles/run.invoker --region=us-central1 --project=my-project --quiet",
+			"run services add-iam-policy-binding myapp --member=allUsers --role=roles/run.invoker --region=europe-west1 --project=my-project --quiet",
+		}, executor.commandLines()[1:])
+	})
+}
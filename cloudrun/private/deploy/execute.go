@@ -0,0 +1,208 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// executionPollInterval is the pause between status polls of a job
+// execution.
+const executionPollInterval = 5 * time.Second
+
+// Execution modes of a job after replace.
+const (
+	// executeAsync starts an execution and returns.
+	executeAsync = "execute"
+	// executeWait starts an execution and waits for it to finish.
+	executeWait = "wait"
+)
+
+// executionOverrides change a single job execution. Zero values keep the
+// job's settings.
+type executionOverrides struct {
+	args        []string
+	env         []string
+	tasks       int
+	taskTimeout string
+}
+
+func (o executionOverrides) empty() bool {
+	return len(o.args) == 0 && len(o.env) == 0 && o.tasks == 0 && o.taskTimeout == ""
+}
+
+// executionDescription is the part of a job execution, as printed by
+// `gcloud run jobs execute` and `gcloud run jobs executions describe` with
+// --format=json, the deployer reads.
+type executionDescription struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		TaskCount int `json:"taskCount"`
+	} `json:"spec"`
+	Status struct {
+		RunningCount   int    `json:"runningCount"`
+		SucceededCount int    `json:"succeededCount"`
+		FailedCount    int    `json:"failedCount"`
+		CancelledCount int    `json:"cancelledCount"`
+		CompletionTime string `json:"completionTime"`
+		LogURI         string `json:"logUri"`
+		Conditions     []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// progress summarizes the task counts of the execution.
+func (e executionDescription) progress() string {
+	status := e.Status
+	summary := fmt.Sprintf("%d running, %d succeeded, %d failed", status.RunningCount, status.SucceededCount, status.FailedCount)
+	if status.CancelledCount > 0 {
+		summary += fmt.Sprintf(", %d cancelled", status.CancelledCount)
+	}
+	if e.Spec.TaskCount > 0 {
+		summary += fmt.Sprintf(" of %d tasks", e.Spec.TaskCount)
+	}
+	return summary
+}
+
+// completed reports whether the execution finished and, if so, the reason
+// it failed.
+func (e executionDescription) completed() (bool, error) {
+	for _, condition := range e.Status.Conditions {
+		if condition.Type != "Completed" {
+			continue
+		}
+		switch condition.Status {
+		case "True":
+			return true, nil
+		case "False":
+			return true, errors.New(condition.Message)
+		}
+	}
+	if e.Status.CompletionTime == "" {
+		return false, nil
+	}
+	if e.Status.FailedCount > 0 || e.Status.CancelledCount > 0 {
+		return true, fmt.Errorf("%s", e.progress())
+	}
+	return true, nil
+}
+
+// executionMode is the mode requested by the arguments, falling back to
+// waiting when the job runs on deploy. It is empty when the job is not
+// executed.
+func (o runtimeOptions) executionMode(runOnDeploy bool) string {
+	switch {
+	case o.noExecute:
+		return ""
+	case o.wait:
+		return executeWait
+	case o.execute:
+		return executeAsync
+	case runOnDeploy:
+		return executeWait
+	}
+	return ""
+}
+
+// checkExecution rejects execution flags that do not apply to the resource.
+func (d *Deployer) checkExecution(options runtimeOptions) error {
+	mode := options.executionMode(d.config.RunOnDeploy)
+	if mode != "" && d.config.ResourceType != ResourceTypeJob {
+		return fmt.Errorf("--execute and --wait are only supported for jobs, not %ss", d.config.ResourceType)
+	}
+	if mode == "" && !options.execution.empty() {
+		return errors.New("--exec-arg, --exec-env, --exec-tasks, and --exec-task-timeout need --execute or --wait")
+	}
+	return nil
+}
+
+// executeCommand starts an execution of the job with the overrides and
+// prints it as JSON.
+func (d *Deployer) executeCommand(gcloud string, overrides executionOverrides) Command {
+	args := []string{"run", "jobs", "execute", d.config.Name}
+	if len(overrides.args) > 0 {
+		args = append(args, "--args="+gcloudList(overrides.args))
+	}
+	if len(overrides.env) > 0 {
+		args = append(args, "--update-env-vars="+gcloudList(overrides.env))
+	}
+	if overrides.tasks > 0 {
+		args = append(args, "--tasks="+strconv.Itoa(overrides.tasks))
+	}
+	if overrides.taskTimeout != "" {
+		args = append(args, "--task-timeout="+overrides.taskTimeout)
+	}
+	args = append(append(args, d.locationArgs()...), "--async", "--format=json")
+	return Command{Path: gcloud, Args: args, Stderr: d.stderr}
+}
+
+// gcloudList joins values into a gcloud list argument. When a value
+// contains a comma, the list uses gcloud's ^<delimiter>^ escaping with a
+// delimiter no value contains.
+func gcloudList(values []string) string {
+	joined := strings.Join(values, "")
+	if !strings.Contains(joined, ",") {
+		return strings.Join(values, ",")
+	}
+	for _, delimiter := range []string{"|", "@", ";", "#", "~"} {
+		if !strings.Contains(joined, delimiter) {
+			return "^" + delimiter + "^" + strings.Join(values, delimiter)
+		}
+	}
+	return "^\x1f^" + strings.Join(values, "\x1f")
+}
+
+// executeJob runs the job in mode. In wait mode it polls the execution,
+// printing its task counts whenever they change, and fails when a task
+// fails.
+func (d *Deployer) executeJob(ctx context.Context, gcloud, mode string, overrides executionOverrides) error {
+	if mode == "" {
+		return nil
+	}
+	var execution executionDescription
+	if err := d.runJSON(ctx, d.executeCommand(gcloud, overrides), &execution); err != nil {
+		return fmt.Errorf("execute job %s: %w", d.config.Name, err)
+	}
+	name := execution.Metadata.Name
+	if name == "" {
+		return fmt.Errorf("execute job %s: gcloud printed no execution name", d.config.Name)
+	}
+	_, _ = fmt.Fprintf(d.stdout, "Started execution %s\n", name)
+	if execution.Status.LogURI != "" {
+		_, _ = fmt.Fprintf(d.stdout, "Logs: %s\n", execution.Status.LogURI)
+	}
+	if mode == executeAsync {
+		return nil
+	}
+
+	last := ""
+	for {
+		var execution executionDescription
+		if err := d.describeJSON(ctx, gcloud, []string{"run", "jobs", "executions", "describe", name}, &execution); err != nil {
+			return fmt.Errorf("describe execution %s: %w", name, err)
+		}
+		if progress := execution.progress(); progress != last {
+			_, _ = fmt.Fprintf(d.stdout, "Execution %s: %s\n", name, progress)
+			last = progress
+		}
+		done, err := execution.completed()
+		if err != nil {
+			return fmt.Errorf("execution %s failed: %w", name, err)
+		}
+		if done {
+			_, _ = fmt.Fprintf(d.stdout, "Execution %s succeeded\n", name)
+			return nil
+		}
+		if err := d.sleep(ctx, executionPollInterval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExecution answers the gcloud commands of a job execution. Successive
+// describes return the statuses in order; the last one repeats.
+type fakeExecution struct {
+	statuses []string
+	describe int
+}
+
+func (f *fakeExecution) respond(command Command) (string, error) {
+	line := strings.Join(command.Args, " ")
+	switch {
+	case strings.HasPrefix(line, "run jobs execute"):
+		return `{"metadata": {"name": "myapp-x7k2p"}, "spec": {"taskCount": 2},
+			"status": {"logUri": "https://console.cloud.google.com/logs/myapp-x7k2p"}}`, nil
+	case strings.HasPrefix(line, "run jobs executions describe myapp-x7k2p"):
+		status := f.statuses[min(f.describe, len(f.statuses)-1)]
+		f.describe++
+		return fmt.Sprintf(`{"metadata": {"name": "myapp-x7k2p"}, "spec": {"taskCount": 2}, "status": %s}`, status), nil
+	}
+	return "", nil
+}
+
+// execute deploys config with a no-op sleep.
+func (s *deploySuite) execute(config Config, executor *fakeExecutor, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	deployer := NewDeployer(config, executor, s.T().TempDir())
+	deployer.stdout = &stdout
+	deployer.stderr = io.Discard
+	deployer.getenv = func(string) string { return "" }
+	deployer.sleep = func(context.Context, time.Duration) error { return nil }
+	err := deployer.Run(context.Background(), args)
+	return stdout.String(), err
+}
+
+func (s *deploySuite) TestExecute() {
+	location := " --region=us-central1 --project=my-project"
+	running := `{"runningCount": 2}`
+	succeeded := `{"succeededCount": 2, "completionTime": "2026-01-01T00:00:00Z",
+		"conditions": [{"type": "Completed", "status": "True"}]}`
+	failed := `{"succeededCount": 1, "failedCount": 1, "completionTime": "2026-01-01T00:00:00Z",
+		"conditions": [{"type": "Completed", "status": "False", "message": "Task myapp-x7k2p-1 failed with exit code 1"}]}`
+
+	s.Run("waits for the execution after replace", func() {
+		execution := &fakeExecution{statuses: []string{running, running, succeeded}}
+		executor := &fakeExecutor{respond: execution.respond}
+		output, err := s.execute(testConfig(ResourceTypeJob, "us-central1"), executor,
+			"--wait", "--exec-arg=--migrate", "--exec-arg", "--target=head", "--exec-env=MODE=up", "--exec-tasks=2", "--exec-task-timeout=30m")
+		require.NoError(s.T(), err)
+
+		lines := executor.commandLines()
+		require.Contains(s.T(), lines[0], "beta run jobs replace ")
+		require.Equal(s.T(), []string{
+			"run jobs execute myapp --args=--migrate,--target=head --update-env-vars=MODE=up --tasks=2 --task-timeout=30m" + location + " --async --format=json",
+			"run jobs executions describe myapp-x7k2p" + location + " --format=json",
+			"run jobs executions describe myapp-x7k2p" + location + " --format=json",
+			"run jobs executions describe myapp-x7k2p" + location + " --format=json",
+		}, lines[1:])
+		require.Contains(s.T(), output, `Started execution myapp-x7k2p
+Logs: https://console.cloud.google.com/logs/myapp-x7k2p
+Execution myapp-x7k2p: 2 running, 0 succeeded, 0 failed of 2 tasks
+Execution myapp-x7k2p: 0 running, 2 succeeded, 0 failed of 2 tasks
+Execution myapp-x7k2p succeeded
+`)
+	})
+
+	s.Run("fails when a task fails", func() {
+		execution := &fakeExecution{statuses: []string{failed}}
+		_, err := s.execute(testConfig(ResourceTypeJob, "us-central1"), &fakeExecutor{respond: execution.respond}, "--wait")
+		require.EqualError(s.T(), err, "execution myapp-x7k2p failed: Task myapp-x7k2p-1 failed with exit code 1")
+	})
+
+	s.Run("returns after starting the execution with --execute", func() {
+		execution := &fakeExecution{statuses: []string{running}}
+		executor := &fakeExecutor{respond: execution.respond}
+		_, err := s.execute(testConfig(ResourceTypeJob, "us-central1"), executor, "--execute")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 0, execution.describe)
+	})
+
+	s.Run("runs on deploy unless --no-execute is passed", func() {
+		config := testConfig(ResourceTypeJob, "us-central1")
+		config.RunOnDeploy = true
+		execution := &fakeExecution{statuses: []string{succeeded}}
+		executor := &fakeExecutor{respond: execution.respond}
+		_, err := s.execute(config, executor)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), 1, execution.describe)
+
+		executor = &fakeExecutor{}
+		_, err = s.execute(config, executor, "--no-execute")
+		require.NoError(s.T(), err)
+		require.NotContains(s.T(), strings.Join(executor.commandLines(), "\n"), "execute")
+	})
+
+	s.Run("escapes list overrides that contain commas", func() {
+		command := (&Deployer{config: testConfig(ResourceTypeJob)}).executeCommand("gcloud", executionOverrides{args: []string{"a,b", "c"}})
+		require.Equal(s.T(), "--args=^|^a,b|c", command.Args[4])
+	})
+
+	s.Run("rejects invalid execution flags", func() {
+		for _, tc := range []struct {
+			config  Config
+			args    []string
+			message string
+		}{
+			{testConfig(ResourceTypeService, "us-central1"), []string{"--wait"}, "--execute and --wait are only supported for jobs, not services"},
+			{testConfig(ResourceTypeJob, "us-central1"), []string{"--exec-tasks=2"}, "need --execute or --wait"},
+			{testConfig(ResourceTypeJob, "us-central1"), []string{"--wait", "--exec-tasks=0"}, "--exec-tasks must be a positive integer"},
+			{testConfig(ResourceTypeJob, "us-central1"), []string{"--wait", "--exec-env=MODE"}, "--exec-env must be KEY=VALUE"},
+			{testConfig(ResourceTypeJob, "us-central1"), []string{"--wait", "--no-execute"}, "--no-execute cannot be combined"},
+			{testConfig(ResourceTypeJob, "us-central1"), []string{"--execute", "--exec-arg"}, "--exec-arg requires a value"},
+		} {
+			executor := &fakeExecutor{}
+			_, err := s.execute(tc.config, executor, tc.args...)
+			require.ErrorContains(s.T(), err, tc.message)
+			require.Empty(s.T(), executor.commandLines())
+		}
+	})
+
+	s.Run("prints the execution in a dry run", func() {
+		config := testConfig(ResourceTypeJob, "us-central1")
+		config.RunOnDeploy = true
+		output, err := s.execute(config, &fakeExecutor{}, "--dry-run", "--exec-tasks=3")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "Would execute the job and wait for it to finish:\n  gcloud run jobs execute myapp --tasks=3"+location+" --async --format=json\n")
+	})
+}
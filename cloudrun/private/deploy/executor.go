@@ -0,0 +1,42 @@
+package deploy
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Command is a process started by the Deployer.
+type Command struct {
+	Path string
+	Args []string
+	// Env is added to the environment of the current process.
+	Env []string
+	// Dir is the working directory; empty means the current one.
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Executor runs commands. A command that exits non-zero returns an error
+// with an ExitCode() int method, such as *exec.ExitError.
+type Executor interface {
+	Run(ctx context.Context, command Command) error
+}
+
+// OSExecutor runs commands as child processes.
+type OSExecutor struct{}
+
+func (OSExecutor) Run(ctx context.Context, command Command) error {
+	cmd := exec.CommandContext(ctx, command.Path, command.Args...)
+	if len(command.Env) > 0 {
+		cmd.Env = append(os.Environ(), command.Env...)
+	}
+	cmd.Dir = command.Dir
+	cmd.Stdin = command.Stdin
+	cmd.Stdout = command.Stdout
+	cmd.Stderr = command.Stderr
+	return cmd.Run()
+}
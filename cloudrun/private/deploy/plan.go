@@ -0,0 +1,340 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// errAborted is returned when the plan is not confirmed.
+var errAborted = errors.New("deploy aborted; pass --yes to deploy without confirmation")
+
+// serverMetadataFields are the metadata fields Cloud Run sets on deployed
+// resources. They are dropped from the live manifest before diffing.
+var serverMetadataFields = []string{
+	"creationTimestamp",
+	"generation",
+	"namespace",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// serverLabels are the labels Cloud Run adds to deployed resources and
+// their templates.
+var serverLabels = []string{
+	"cloud.googleapis.com/location",
+	"run.googleapis.com/startupProbeType",
+}
+
+// serverAnnotations are the annotations Cloud Run and gcloud add to
+// deployed resources and their templates.
+var serverAnnotations = []string{
+	"client.knative.dev/user-image",
+	"run.googleapis.com/client-name",
+	"run.googleapis.com/client-version",
+	"run.googleapis.com/ingress-status",
+	"run.googleapis.com/operation-id",
+	"run.googleapis.com/urls",
+	"serving.knative.dev/creator",
+	"serving.knative.dev/lastModifier",
+}
+
+// Values Cloud Run fills in when a manifest leaves them out. They are
+// dropped from both manifests before diffing, so that restating a default
+// is not a change.
+var (
+	defaultTraffic      = mustParseYAML("- latestRevision: true\n  percent: 100\n")
+	defaultPorts        = mustParseYAML("- containerPort: 8080\n  name: http1\n")
+	defaultStartupProbe = mustParseYAML("failureThreshold: 1\nperiodSeconds: 240\ntcpSocket:\n  port: 8080\ntimeoutSeconds: 240\n")
+)
+
+// defaultContainerConcurrency is the containerConcurrency of a service
+// revision that does not set one.
+const defaultContainerConcurrency = 80
+
+// plan prints the diff between the deployed resource and manifestContent
+// and reports whether there is anything to confirm. A resource that cannot
+// be described is treated as not deployed yet.
+func (d *Deployer) plan(ctx context.Context, gcloud, manifestContent string) (bool, error) {
+	var live bytes.Buffer
+	if err := d.executor.Run(ctx, Command{
+		Path:   gcloud,
+		Args:   d.describeArgs(),
+		Stdout: &live,
+		Stderr: io.Discard,
+	}); err != nil {
+		live.Reset()
+	}
+
+	if live.Len() == 0 {
+		_, _ = fmt.Fprintf(d.stdout, "Plan: %s %s is not deployed yet and will be created.\n\n", d.config.ResourceType, d.config.Name)
+		return true, nil
+	}
+	diff, err := manifestDiff(live.String(), manifestContent)
+	if err != nil {
+		return false, err
+	}
+	if diff == "" {
+		_, _ = fmt.Fprintf(d.stdout, "Plan: the manifest of %s %s is unchanged.\n\n", d.config.ResourceType, d.config.Name)
+		return false, nil
+	}
+	_, _ = fmt.Fprintf(d.stdout, "Plan: changes to %s %s:\n\n%s\n", d.config.ResourceType, d.config.Name, diff)
+	return true, nil
+}
+
+// printDryRun prints the resolved location, the replace command line, the
+// follow-up verification, rollout, domain, IAM, and execution steps, and the
+// final manifest. Nothing is pushed or deployed.
+func (d *Deployer) printDryRun(ctx context.Context, gcloud, manifestContent string, options runtimeOptions) {
+	_, _ = fmt.Fprintf(d.stdout, "Dry run of Cloud Run %s %s; nothing is pushed or deployed.\n", d.config.ResourceType, d.config.Name)
+	project := d.config.Project
+	if project == "" {
+		project = d.gcloudConfigValue(ctx, gcloud, "project") + " (from gcloud config)"
+	}
+	region := strings.Join(d.config.Regions, ",")
+	if region == "" {
+		region = d.gcloudConfigValue(ctx, gcloud, "run/region") + " (from gcloud config)"
+	}
+	_, _ = fmt.Fprintf(d.stdout, "  Project: %s\n", project)
+	_, _ = fmt.Fprintf(d.stdout, "  Region:  %s\n", region)
+	d.printImageOverrides(options.images)
+
+	_, _ = fmt.Fprintf(d.stdout, "\nWould run, with the manifest below:\n  %s\n", commandLine(d.replaceCommand(gcloud, "manifest.yaml", options.gcloudArgs)))
+	if verify := d.config.Verify; verify != nil {
+		_, _ = fmt.Fprint(d.stdout, "Would verify the new revision")
+		if verify.Path != "" {
+			_, _ = fmt.Fprintf(d.stdout, " and smoke test %s on its %s tag", verify.Path, verify.Tag)
+		}
+		_, _ = fmt.Fprintln(d.stdout)
+	}
+	if d.config.Rollout != nil {
+		_, _ = fmt.Fprintf(d.stdout, "Would deploy the new revision without traffic on its %s tag and roll out %s\n", d.config.Rollout.Tag, describeSteps(d.config.Rollout))
+	}
+	for _, domain := range d.config.Domains {
+		_, _ = fmt.Fprintf(d.stdout, "Would map domain %s to %s\n", domain, d.config.Name)
+	}
+	for _, binding := range d.config.IAMBindings {
+		_, _ = fmt.Fprintf(d.stdout, "Would grant %s to %s\n", binding.Role, binding.Member)
+	}
+	switch options.executionMode(d.config.RunOnDeploy) {
+	case executeAsync:
+		_, _ = fmt.Fprintf(d.stdout, "Would execute the job:\n  %s\n", commandLine(d.executeCommand(gcloud, options.execution)))
+	case executeWait:
+		_, _ = fmt.Fprintf(d.stdout, "Would execute the job and wait for it to finish:\n  %s\n", commandLine(d.executeCommand(gcloud, options.execution)))
+	}
+
+	_, _ = fmt.Fprintf(d.stdout, "\nManifest:\n%s", manifestContent)
+	if !strings.HasSuffix(manifestContent, "\n") {
+		_, _ = fmt.Fprintln(d.stdout)
+	}
+}
+
+// gcloudConfigValue returns a gcloud config property, or "unset".
+func (d *Deployer) gcloudConfigValue(ctx context.Context, gcloud, property string) string {
+	var value bytes.Buffer
+	if err := d.executor.Run(ctx, Command{
+		Path:   gcloud,
+		Args:   []string{"config", "get-value", property},
+		Stdout: &value,
+		Stderr: io.Discard,
+	}); err != nil || strings.TrimSpace(value.String()) == "" {
+		return "unset"
+	}
+	return strings.TrimSpace(value.String())
+}
+
+// commandLine formats a command as a shell command line.
+func commandLine(command Command) string {
+	words := append([]string{}, command.Env...)
+	words = append(words, command.Path)
+	for _, arg := range command.Args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote single-quotes arg unless it only holds characters the shell
+// takes literally.
+func shellQuote(arg string) string {
+	const literal = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-"
+	if arg != "" && strings.Trim(arg, literal) == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// describeArgs exports the deployed resource. Multi-region services are
+// described through their first region.
+func (d *Deployer) describeArgs() []string {
+	var args []string
+	if d.config.GcloudTrack != "" {
+		args = append(args, d.config.GcloudTrack)
+	}
+	if d.multiRegion() {
+		args = append(args, "run", "services", "describe", d.config.Name, "--region="+d.config.Regions[0])
+		if d.config.Project != "" {
+			args = append(args, "--project="+d.config.Project)
+		}
+	} else {
+		args = append(args, "run", d.gcloudGroup(), "describe", d.config.Name)
+		args = append(args, d.locationArgs()...)
+	}
+	return append(args, "--format=export")
+}
+
+// confirm asks whether to go ahead with the deploy. Anything but y or yes,
+// including a closed stdin, declines.
+func (d *Deployer) confirm() bool {
+	_, _ = fmt.Fprint(d.stdout, "Deploy these changes? [y/N]: ")
+	answer, _ := bufio.NewReader(d.stdin).ReadString('\n')
+	_, _ = fmt.Fprintln(d.stdout)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// manifestDiff returns a unified diff from the live manifest to the
+// desired one, or "" when they match. Both are normalized first so that
+// key order and server-set fields do not show up as changes.
+func manifestDiff(live, desired string) (string, error) {
+	normalizedLive, err := normalizeManifest(live, true)
+	if err != nil {
+		return "", fmt.Errorf("parse deployed manifest: %w", err)
+	}
+	normalizedDesired, err := normalizeManifest(desired, false)
+	if err != nil {
+		return "", fmt.Errorf("parse manifest: %w", err)
+	}
+	if normalizedLive == normalizedDesired {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(normalizedLive),
+		B:        difflib.SplitLines(normalizedDesired),
+		FromFile: "deployed",
+		ToFile:   "local",
+		Context:  3,
+	})
+}
+
+// normalizeManifest re-marshals a manifest with sorted keys, without the
+// values that only restate server defaults and with resource quantities in
+// canonical form. For the live manifest it also drops status and the fields
+// the server sets.
+func normalizeManifest(content string, live bool) (string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &raw); err != nil {
+		return "", err
+	}
+	if live {
+		delete(raw, "status")
+		if metadata, ok := raw["metadata"].(map[string]interface{}); ok {
+			for _, field := range serverMetadataFields {
+				delete(metadata, field)
+			}
+			dropServerMetadata(metadata)
+		}
+		if spec, ok := raw["spec"].(map[string]interface{}); ok {
+			if template, ok := spec["template"].(map[string]interface{}); ok {
+				if metadata, ok := template["metadata"].(map[string]interface{}); ok {
+					dropServerMetadata(metadata)
+					if len(metadata) == 0 {
+						delete(template, "metadata")
+					}
+				}
+			}
+		}
+	}
+	if err := dropServerDefaults(raw); err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// dropServerMetadata removes the server-set annotations and labels.
+func dropServerMetadata(metadata map[string]interface{}) {
+	for key, names := range map[string][]string{
+		"annotations": serverAnnotations,
+		"labels":      serverLabels,
+	} {
+		values, ok := metadata[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, name := range names {
+			delete(values, name)
+		}
+		if len(values) == 0 {
+			delete(metadata, key)
+		}
+	}
+}
+
+// dropServerDefaults walks a manifest, removing the default traffic,
+// concurrency, ports, and startup probe, and rewriting resource limits in
+// canonical form (1000m becomes 1).
+func dropServerDefaults(node interface{}) error {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if reflect.DeepEqual(value["traffic"], defaultTraffic) {
+			delete(value, "traffic")
+		}
+		if concurrency, ok := value["containerConcurrency"].(float64); ok && concurrency == defaultContainerConcurrency {
+			delete(value, "containerConcurrency")
+		}
+		if reflect.DeepEqual(value["ports"], defaultPorts) {
+			delete(value, "ports")
+		}
+		if reflect.DeepEqual(value["startupProbe"], defaultStartupProbe) {
+			delete(value, "startupProbe")
+		}
+		if resources, ok := value["resources"].(map[string]interface{}); ok {
+			if limits, ok := resources["limits"].(map[string]interface{}); ok {
+				for name, limit := range limits {
+					quantity, err := apiresource.ParseQuantity(fmt.Sprint(limit))
+					if err != nil {
+						return fmt.Errorf("resources.limits.%s: %w", name, err)
+					}
+					limits[name] = quantity.String()
+				}
+			}
+		}
+		for _, child := range value {
+			if err := dropServerDefaults(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if err := dropServerDefaults(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func mustParseYAML(content string) interface{} {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(content), &value); err != nil {
+		panic(err)
+	}
+	return value
+}
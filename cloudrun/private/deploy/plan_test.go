@@ -0,0 +1,261 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// liveManifest is `gcloud run services describe --format=export` of myapp
+// as rendered by renderedManifest, with an older image.
+const liveManifest = `apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  annotations:
+    run.googleapis.com/client-name: gcloud
+    run.googleapis.com/client-version: 520.0.0
+    run.googleapis.com/ingress: all
+    run.googleapis.com/ingress-status: all
+    run.googleapis.com/maxScale: '3'
+    run.googleapis.com/operation-id: 5b1c7e0a-4f2d-4c8e-9a57-0d3f6e2b8c41
+    run.googleapis.com/urls: '["https://myapp-123456789.us-central1.run.app","https://myapp-x7k2pq3aba-uc.a.run.app"]'
+    serving.knative.dev/creator: dev@example.com
+    serving.knative.dev/lastModifier: dev@example.com
+  labels:
+    cloud.googleapis.com/location: us-central1
+  name: myapp
+  namespace: '123456789'
+spec:
+  template:
+    metadata:
+      annotations:
+        run.googleapis.com/client-name: gcloud
+        run.googleapis.com/client-version: 520.0.0
+        run.googleapis.com/execution-environment: gen2
+      labels:
+        run.googleapis.com/startupProbeType: Default
+    spec:
+      containerConcurrency: 80
+      containers:
+      - env:
+        - name: LOG_LEVEL
+          value: info
+        image: example.com/myapp@sha256:` + "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" + `
+        ports:
+        - containerPort: 8080
+          name: http1
+        resources:
+          limits:
+            cpu: 1000m
+            memory: 512Mi
+        startupProbe:
+          failureThreshold: 1
+          periodSeconds: 240
+          tcpSocket:
+            port: 8080
+          timeoutSeconds: 240
+      serviceAccountName: app@my-project.iam.gserviceaccount.com
+      timeoutSeconds: 300
+  traffic:
+  - latestRevision: true
+    percent: 100
+`
+
+// renderedManifest renders myapp with image, as the .render target does.
+func (s *deploySuite) renderedManifest(image string) string {
+	config, err := manifest.ParseConfig([]byte(`
+runConfig:
+  maxInstances: 3
+serviceAccount: app@my-project.iam.gserviceaccount.com
+env:
+  - variable: LOG_LEVEL
+    value: info
+`))
+	require.NoError(s.T(), err)
+	service, err := manifest.BuildService(config, manifest.Options{Name: "myapp", Image: image})
+	require.NoError(s.T(), err)
+	content, err := manifest.Marshal(service)
+	require.NoError(s.T(), err)
+	return string(content)
+}
+
+func (s *deploySuite) TestDryRun() {
+	override := "example.com/other@" + testDigest
+
+	s.Run("prints the command line and the final manifest", func() {
+		config := testConfig(ResourceTypeService, "us-central1")
+		config.GcloudRunfile = ""
+		config.Domains = []string{"api.example.com"}
+		config.IAMBindings = []IAMBinding{{Role: "roles/run.invoker", Member: "allUsers"}}
+		executor := &fakeExecutor{}
+		output, err := s.deploy(config, executor, "--dry-run", "--image="+override, "--labels=team=a b")
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), executor.commands)
+		require.Contains(s.T(), output, "  Project: my-project\n  Region:  us-central1\n  Image:   "+override+" (runtime override)\n")
+		require.Contains(s.T(), output, "  gcloud beta run services replace manifest.yaml --region=us-central1 --project=my-project --quiet '--labels=team=a b'\n")
+		require.Contains(s.T(), output, "Would map domain api.example.com to myapp\n")
+		require.Contains(s.T(), output, "Would grant roles/run.invoker to allUsers\n")
+		require.True(s.T(), strings.HasSuffix(output, "Manifest:\nspec:\n  containers:\n  - image: "+override+"\n"), output)
+	})
+
+	s.Run("resolves the project and region from gcloud config", func() {
+		config := testConfig(ResourceTypeWorker)
+		config.Project = ""
+		executor := &fakeExecutor{respond: func(command Command) (string, error) {
+			if strings.Join(command.Args, " ") == "config get-value project" {
+				return "config-project\n", nil
+			}
+			return "", nil
+		}}
+		output, err := s.deploy(config, executor, "--dry-run")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{"config get-value project", "config get-value run/region"}, executor.commandLines())
+		require.Contains(s.T(), output, "  Project: config-project (from gcloud config)\n  Region:  unset (from gcloud config)\n")
+	})
+
+	s.Run("prints the plan without asking", func() {
+		executor := &fakeExecutor{respond: func(Command) (string, error) { return liveManifest, nil }}
+		output, err := s.run(testConfig(ResourceTypeService, "us-central1"), executor, "y\n", "--dry-run", "--plan")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "Plan: changes to service myapp")
+		require.NotContains(s.T(), output, "[y/N]")
+		require.Equal(s.T(), []string{
+			"beta run services describe myapp --region=us-central1 --project=my-project --format=export",
+		}, executor.commandLines())
+	})
+}
+
+func (s *deploySuite) TestPlan() {
+	config := testConfig(ResourceTypeService, "us-central1")
+	config.Manifest = s.renderedManifest("example.com/myapp@" + testDigest)
+	describe := "beta run services describe myapp --region=us-central1 --project=my-project --format=export"
+	replace := "beta run services replace <manifest> --region=us-central1 --project=my-project --quiet"
+	deployed := func(command Command) (string, error) {
+		if strings.Contains(strings.Join(command.Args, " "), "describe") {
+			return liveManifest, nil
+		}
+		return "", nil
+	}
+
+	s.Run("deploys after confirmation", func() {
+		executor := &fakeExecutor{respond: deployed}
+		output, err := s.run(config, executor, "y\n", "--plan")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "-        image: example.com/myapp@sha256:aaaa")
+		require.Contains(s.T(), output, "+        image: example.com/myapp@"+testDigest)
+		require.NotContains(s.T(), output, "operation-id")
+		require.NotContains(s.T(), output, "namespace")
+		require.NotContains(s.T(), output, "traffic")
+		require.Contains(s.T(), output, "Deploy these changes? [y/N]")
+
+		lines := executor.commandLines()
+		lines[1] = strings.Replace(lines[1], executor.commands[2].Args[4], "<manifest>", 1)
+		require.Equal(s.T(), []string{describe, replace}, lines)
+	})
+
+	s.Run("aborts without confirmation", func() {
+		executor := &fakeExecutor{respond: deployed}
+		_, err := s.run(config, executor, "", "--plan")
+		require.ErrorIs(s.T(), err, errAborted)
+		require.Equal(s.T(), []string{describe}, executor.commandLines())
+
+		executor = &fakeExecutor{respond: deployed}
+		_, err = s.run(config, executor, "no\n", "--plan")
+		require.ErrorIs(s.T(), err, errAborted)
+	})
+
+	s.Run("skips the prompt with --yes", func() {
+		executor := &fakeExecutor{respond: deployed}
+		output, err := s.run(config, executor, "", "--plan", "--yes")
+		require.NoError(s.T(), err)
+		require.NotContains(s.T(), output, "[y/N]")
+		require.Len(s.T(), executor.commandLines(), 2)
+	})
+
+	s.Run("reports a resource that is not deployed yet", func() {
+		executor := &fakeExecutor{respond: func(command Command) (string, error) {
+			if strings.Contains(strings.Join(command.Args, " "), "describe") {
+				return "", exitError(1)
+			}
+			return "", nil
+		}}
+		output, err := s.run(config, executor, "", "--plan", "--yes")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "Plan: service myapp is not deployed yet and will be created.")
+	})
+
+	s.Run("describes multi-region services through the first region", func() {
+		executor := &fakeExecutor{respond: deployed}
+		_, err := s.run(testConfig(ResourceTypeService, "us-central1", "europe-west1"), executor, "", "--plan", "--dry-run")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{describe}, executor.commandLines())
+	})
+
+	s.Run("runs against a stub gcloud", func() {
+		runfiles := s.T().TempDir()
+		log := filepath.Join(runfiles, "gcloud.log")
+		live := filepath.Join(runfiles, "live.yaml")
+		require.NoError(s.T(), os.WriteFile(live, []byte(strings.Replace(liveManifest, "sha256:"+strings.Repeat("a", 64), testDigest, 1)), 0o644))
+		gcloud := "#!/bin/sh\n" +
+			"echo \"$*\" >> " + log + "\n" +
+			"case \"$*\" in *describe*) cat " + live + ";; esac\n"
+		require.NoError(s.T(), os.MkdirAll(filepath.Join(runfiles, "sdk"), 0o755))
+		require.NoError(s.T(), os.WriteFile(filepath.Join(runfiles, "sdk", "gcloud"), []byte(gcloud), 0o755))
+
+		stubConfig := config
+		stubConfig.GcloudRunfile = "sdk/gcloud"
+		var stdout bytes.Buffer
+		deployer := NewDeployer(stubConfig, nil, runfiles)
+		deployer.stdin = strings.NewReader("")
+		deployer.stdout = &stdout
+		deployer.getenv = func(string) string { return "" }
+		require.NoError(s.T(), deployer.Run(context.Background(), []string{"--plan"}))
+		require.Contains(s.T(), stdout.String(), "Plan: the manifest of service myapp is unchanged.")
+		require.NotContains(s.T(), stdout.String(), "[y/N]")
+
+		calls, err := os.ReadFile(log)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(calls), describe+"\n")
+		require.Contains(s.T(), string(calls), "beta run services replace ")
+	})
+}
+
+func (s *deploySuite) TestManifestDiff() {
+	s.Run("finds no changes in a freshly rendered manifest", func() {
+		diff, err := manifestDiff(liveManifest, s.renderedManifest("example.com/myapp@sha256:"+strings.Repeat("a", 64)))
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), diff)
+	})
+
+	s.Run("shows the changed values only", func() {
+		diff, err := manifestDiff(liveManifest, s.renderedManifest("example.com/myapp@"+testDigest))
+		require.NoError(s.T(), err)
+		var changes []string
+		for _, line := range strings.Split(diff, "\n") {
+			if (strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")) && !strings.HasPrefix(line, "---") && !strings.HasPrefix(line, "+++") {
+				changes = append(changes, line)
+			}
+		}
+		require.Equal(s.T(), []string{
+			"-        image: example.com/myapp@sha256:" + strings.Repeat("a", 64),
+			"+        image: example.com/myapp@" + testDigest,
+		}, changes)
+	})
+
+	s.Run("keeps values that differ from the defaults", func() {
+		desired := strings.Replace(s.renderedManifest("example.com/myapp@sha256:"+strings.Repeat("a", 64)), "      containers:", "      containerConcurrency: 40\n      containers:", 1)
+		diff, err := manifestDiff(liveManifest, desired)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), diff, "+      containerConcurrency: 40")
+	})
+
+	s.Run("rejects malformed manifests", func() {
+		_, err := manifestDiff("kind: [", "kind: Service\n")
+		require.ErrorContains(s.T(), err, "parse deployed manifest")
+	})
+}
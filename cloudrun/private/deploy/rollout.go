@@ -0,0 +1,433 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	"sigs.k8s.io/yaml"
+)
+
+// Statuses of a rollout state file.
+const (
+	rolloutInProgress = "in-progress"
+	rolloutCompleted  = "completed"
+	rolloutRolledBack = "rolled-back"
+	rolloutAborted    = "aborted"
+)
+
+// rolloutState is logged to a state file after every transition so that an
+// interrupted rollout can be resumed or aborted by a later run.
+type rolloutState struct {
+	Service  string `json:"service"`
+	Project  string `json:"project,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Revision string `json:"revision"`
+	Tag      string `json:"tag"`
+	// Previous is the traffic split before the deploy, restored on
+	// rollback.
+	Previous []trafficTarget `json:"previous"`
+	// Step is the index of the step being applied or baked.
+	Step      int       `json:"step"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// HealthGate decides after a rollout step whether the rollout may
+// continue; an error rolls it back.
+type HealthGate interface {
+	Check(ctx context.Context, target GateTarget) error
+}
+
+// GateTarget is the revision a health gate checks.
+type GateTarget struct {
+	Service  string
+	Revision string
+	// URL is the tagged URL that reaches only the new revision.
+	URL     string
+	Percent int
+}
+
+// httpGate requests a path on the tagged URL.
+type httpGate struct {
+	deployer *Deployer
+	path     string
+	token    string
+}
+
+func (g httpGate) Check(ctx context.Context, target GateTarget) error {
+	url := strings.TrimSuffix(target.URL, "/") + g.path
+	if err := g.deployer.probe(ctx, url, g.token); err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	return nil
+}
+
+// commandGate runs a user command from the workspace root with the target
+// in its environment.
+type commandGate struct {
+	executor Executor
+	args     []string
+	dir      string
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+func (g commandGate) Check(ctx context.Context, target GateTarget) error {
+	err := g.executor.Run(ctx, Command{
+		Path: g.args[0],
+		Args: g.args[1:],
+		Env: []string{
+			"CLOUDRUN_SERVICE=" + target.Service,
+			"CLOUDRUN_REVISION=" + target.Revision,
+			"CLOUDRUN_URL=" + target.URL,
+			"CLOUDRUN_PERCENT=" + strconv.Itoa(target.Percent),
+		},
+		Dir:    g.dir,
+		Stdout: g.stdout,
+		Stderr: g.stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(g.args, " "), err)
+	}
+	return nil
+}
+
+// healthGate builds the gate configured under rollout.gate, or nil.
+func (d *Deployer) healthGate(ctx context.Context, gcloud string) HealthGate {
+	gate := d.config.Rollout.Gate
+	switch {
+	case gate == nil:
+		return nil
+	case gate.HTTP != nil:
+		return httpGate{deployer: d, path: gate.HTTP.Path, token: d.identityToken(ctx, gcloud)}
+	case len(gate.Command) > 0:
+		return commandGate{
+			executor: d.executor,
+			args:     gate.Command,
+			dir:      d.getenv("BUILD_WORKSPACE_DIRECTORY"),
+			stdout:   d.stdout,
+			stderr:   d.stderr,
+		}
+	}
+	return nil
+}
+
+// canaryManifest pins the traffic of manifestContent to the revisions in
+// previous and tags the latest revision without traffic, the replace
+// equivalent of deploying with --no-traffic --tag.
+func canaryManifest(manifestContent string, previous []trafficTarget, tag string) (string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal([]byte(manifestContent), &raw); err != nil {
+		return "", fmt.Errorf("parse manifest: %w", err)
+	}
+	spec, ok := raw["spec"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("manifest has no spec")
+	}
+	traffic := make([]interface{}, 0, len(previous)+1)
+	for _, target := range previous {
+		traffic = append(traffic, map[string]interface{}{"revisionName": target.RevisionName, "percent": target.Percent})
+	}
+	traffic = append(traffic, map[string]interface{}{"latestRevision": true, "percent": 0, "tag": tag})
+	spec["traffic"] = traffic
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return "", fmt.Errorf("marshal manifest: %w", err)
+	}
+	return string(data), nil
+}
+
+// splitTraffic sends percent of the traffic to revision and splits the
+// rest across the previous revisions in proportion to their old share.
+func splitTraffic(revision string, percent int, previous []trafficTarget) string {
+	split := []string{fmt.Sprintf("%s=%d", revision, percent)}
+	total := 0
+	for _, target := range previous {
+		total += target.Percent
+	}
+	remaining := 100 - percent
+	if total == 0 || remaining == 0 {
+		return split[0]
+	}
+	shares := make([]int, len(previous))
+	assigned := 0
+	for i, target := range previous {
+		shares[i] = remaining * target.Percent / total
+		assigned += shares[i]
+	}
+	// Rounding leftovers go to the revision that served the most traffic,
+	// which gcloud lists first.
+	shares[0] += remaining - assigned
+	for i, target := range previous {
+		if shares[i] > 0 {
+			split = append(split, fmt.Sprintf("%s=%d", target.RevisionName, shares[i]))
+		}
+	}
+	return strings.Join(split, ",")
+}
+
+// rolloutStatePath is the state file of this service's rollouts, under
+// $CLOUDRUN_ROLLOUT_STATE_DIR or the user cache directory.
+func (d *Deployer) rolloutStatePath() (string, error) {
+	dir := d.getenv("CLOUDRUN_ROLLOUT_STATE_DIR")
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("locate rollout state directory: %w", err)
+		}
+		dir = filepath.Join(cache, "rules_cloudrun", "rollouts")
+	}
+	project := d.config.Project
+	if project == "" {
+		project = "default"
+	}
+	region := "default"
+	if len(d.config.Regions) > 0 {
+		region = d.config.Regions[0]
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%s.json", project, region, d.config.Name)), nil
+}
+
+// loadRolloutState returns the logged state, or nil when no rollout of
+// this service was logged.
+func (d *Deployer) loadRolloutState() (*rolloutState, error) {
+	path, err := d.rolloutStatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read rollout state: %w", err)
+	}
+	var state rolloutState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse rollout state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+func (d *Deployer) saveRolloutState(state *rolloutState) error {
+	path, err := d.rolloutStatePath()
+	if err != nil {
+		return err
+	}
+	state.UpdatedAt = d.now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal rollout state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create rollout state directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write rollout state: %w", err)
+	}
+	return nil
+}
+
+// checkNoRolloutInProgress refuses to start a deploy while a logged
+// rollout is unfinished.
+func (d *Deployer) checkNoRolloutInProgress() error {
+	state, err := d.loadRolloutState()
+	if err != nil || state == nil || state.Status != rolloutInProgress {
+		return err
+	}
+	return fmt.Errorf("the rollout of revision %s is in progress at step %d of %d; pass --resume to continue it or --abort to roll it back",
+		state.Revision, state.Step+1, len(d.config.Rollout.Steps))
+}
+
+// startRollout logs the rollout of the revision created by replace and
+// runs its steps.
+func (d *Deployer) startRollout(ctx context.Context, gcloud string, previous []trafficTarget) error {
+	var service serviceDescription
+	if err := d.describeJSON(ctx, gcloud, []string{"run", "services", "describe", d.config.Name}, &service); err != nil {
+		return fmt.Errorf("rollout: describe service %s: %w", d.config.Name, err)
+	}
+	revision := service.Status.LatestCreatedRevisionName
+	if revision == "" {
+		return fmt.Errorf("rollout: service %s has no latest created revision", d.config.Name)
+	}
+	state := &rolloutState{
+		Service:  d.config.Name,
+		Project:  d.config.Project,
+		Revision: revision,
+		Tag:      d.config.Rollout.Tag,
+		Previous: previous,
+		Status:   rolloutInProgress,
+	}
+	if len(d.config.Regions) > 0 {
+		state.Region = d.config.Regions[0]
+	}
+	if err := d.saveRolloutState(state); err != nil {
+		return err
+	}
+	return d.runRollout(ctx, gcloud, state)
+}
+
+// resumeRollout continues the logged rollout from its current step.
+func (d *Deployer) resumeRollout(ctx context.Context, gcloud string) error {
+	state, err := d.loggedRollout()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(d.stdout, "Resuming the rollout of revision %s at step %d of %d\n", state.Revision, state.Step+1, len(d.config.Rollout.Steps))
+	return d.runRollout(ctx, gcloud, state)
+}
+
+// abortRollout restores the traffic split logged before the rollout.
+func (d *Deployer) abortRollout(ctx context.Context, gcloud string) error {
+	state, err := d.loggedRollout()
+	if err != nil {
+		return err
+	}
+	split := splitOf(state.Previous)
+	_, _ = fmt.Fprintf(d.stdout, "Aborting the rollout of revision %s; restoring traffic to %s\n", state.Revision, split)
+	if err := d.updateTraffic(ctx, gcloud, "--to-revisions="+split); err != nil {
+		return fmt.Errorf("abort rollout: %w", err)
+	}
+	d.removeTag(ctx, gcloud, state.Tag)
+	state.Status = rolloutAborted
+	return d.saveRolloutState(state)
+}
+
+func (d *Deployer) loggedRollout() (*rolloutState, error) {
+	if d.config.Rollout == nil {
+		return nil, errors.New("--resume and --abort need a rollout section in the config")
+	}
+	state, err := d.loadRolloutState()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Status != rolloutInProgress {
+		return nil, fmt.Errorf("no rollout of %s is in progress", d.config.Name)
+	}
+	if state.Step >= len(d.config.Rollout.Steps) {
+		return nil, fmt.Errorf("the logged rollout is at step %d, but the config has %d steps", state.Step+1, len(d.config.Rollout.Steps))
+	}
+	return state, nil
+}
+
+// runRollout applies the steps from state.Step on. After each step but the
+// last, it bakes and runs the health gate; a failing gate rolls back.
+func (d *Deployer) runRollout(ctx context.Context, gcloud string, state *rolloutState) error {
+	steps := d.config.Rollout.Steps
+	gate := d.healthGate(ctx, gcloud)
+	var url string
+	if gate != nil {
+		var err error
+		if url, err = d.taggedURL(ctx, gcloud, state.Tag); err != nil {
+			return fmt.Errorf("rollout: %w", err)
+		}
+	}
+
+	for ; state.Step < len(steps); state.Step++ {
+		step := steps[state.Step]
+		if err := d.saveRolloutState(state); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(d.stdout, "Rollout step %d/%d: sending %d%% of the traffic to %s\n", state.Step+1, len(steps), step.Percent, state.Revision)
+		flag := "--to-revisions=" + splitTraffic(state.Revision, step.Percent, state.Previous)
+		if step.Percent == 100 {
+			flag = "--to-latest"
+		}
+		if err := d.updateTraffic(ctx, gcloud, flag); err != nil {
+			return d.interrupted(state, fmt.Errorf("shift traffic: %w", err))
+		}
+		if state.Step == len(steps)-1 {
+			break
+		}
+		if step.BakeSeconds > 0 {
+			_, _ = fmt.Fprintf(d.stdout, "Baking for %ds\n", step.BakeSeconds)
+			if err := d.sleep(ctx, time.Duration(step.BakeSeconds)*time.Second); err != nil {
+				return d.interrupted(state, err)
+			}
+		}
+		if gate == nil {
+			continue
+		}
+		if err := gate.Check(ctx, GateTarget{Service: d.config.Name, Revision: state.Revision, URL: url, Percent: step.Percent}); err != nil {
+			cause := fmt.Errorf("rollout of revision %s failed the health gate at %d%%: %w", state.Revision, step.Percent, err)
+			return d.rollbackRollout(ctx, gcloud, state, rolloutRolledBack, cause)
+		}
+	}
+
+	d.removeTag(ctx, gcloud, state.Tag)
+	state.Status = rolloutCompleted
+	if err := d.saveRolloutState(state); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(d.stdout, "Rollout of revision %s completed\n", state.Revision)
+	return nil
+}
+
+// interrupted reports a rollout stopped mid-step. The state stays in
+// progress so the rollout can be resumed or aborted.
+func (d *Deployer) interrupted(state *rolloutState, err error) error {
+	return fmt.Errorf("rollout of revision %s interrupted at step %d: %w; pass --resume to continue it or --abort to roll it back",
+		state.Revision, state.Step+1, err)
+}
+
+// rollbackRollout restores the previous traffic split, removes the tag, and
+// logs status.
+func (d *Deployer) rollbackRollout(ctx context.Context, gcloud string, state *rolloutState, status string, cause error) error {
+	err := d.rollback(ctx, gcloud, state.Previous, cause)
+	d.removeTag(ctx, gcloud, state.Tag)
+	state.Status = status
+	if saveErr := d.saveRolloutState(state); saveErr != nil {
+		return fmt.Errorf("%w; %w", err, saveErr)
+	}
+	return err
+}
+
+// taggedURL returns the URL of a traffic tag.
+func (d *Deployer) taggedURL(ctx context.Context, gcloud, tag string) (string, error) {
+	var service serviceDescription
+	if err := d.describeJSON(ctx, gcloud, []string{"run", "services", "describe", d.config.Name}, &service); err != nil {
+		return "", fmt.Errorf("describe service %s: %w", d.config.Name, err)
+	}
+	for _, target := range service.Status.Traffic {
+		if target.Tag == tag && target.URL != "" {
+			return target.URL, nil
+		}
+	}
+	return "", fmt.Errorf("service %s has no URL for tag %s", d.config.Name, tag)
+}
+
+// removeTag removes a traffic tag, warning when that fails.
+func (d *Deployer) removeTag(ctx context.Context, gcloud, tag string) {
+	if err := d.updateTraffic(ctx, gcloud, "--remove-tags="+tag); err != nil {
+		_, _ = fmt.Fprintf(d.stderr, "WARNING: could not remove traffic tag %s: %v\n", tag, err)
+	}
+}
+
+// describeSteps summarizes the configured rollout for --dry-run.
+func describeSteps(rollout *manifest.Rollout) string {
+	steps := make([]string, 0, len(rollout.Steps))
+	for _, step := range rollout.Steps {
+		if step.BakeSeconds > 0 {
+			steps = append(steps, fmt.Sprintf("%d%% (bake %ds)", step.Percent, step.BakeSeconds))
+		} else {
+			steps = append(steps, fmt.Sprintf("%d%%", step.Percent))
+		}
+	}
+	summary := strings.Join(steps, " -> ")
+	switch gate := rollout.Gate; {
+	case gate == nil:
+	case gate.HTTP != nil:
+		summary += fmt.Sprintf(", gated by GET %s on the %s tag", gate.HTTP.Path, rollout.Tag)
+	case len(gate.Command) > 0:
+		summary += ", gated by " + strings.Join(gate.Command, " ")
+	}
+	return summary
+}
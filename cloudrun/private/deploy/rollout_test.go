@@ -0,0 +1,250 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRollout answers the gcloud commands of a rolled out deploy. The
+// service serves myapp-00001 and myapp-00000 before the deploy, and the
+// new revision myapp-00002 has the canary tag after it.
+type fakeRollout struct {
+	url      string
+	replaced bool
+	// gate is the result of the gate command.
+	gate error
+}
+
+func (f *fakeRollout) respond(command Command) (string, error) {
+	line := strings.Join(command.Args, " ")
+	switch {
+	case command.Path == "./check.sh":
+		return "", f.gate
+	case strings.Contains(line, " replace "):
+		f.replaced = true
+	case strings.HasPrefix(line, "run services describe"):
+		if !f.replaced {
+			return `{"status": {"latestCreatedRevisionName": "myapp-00001", "traffic": [
+				{"revisionName": "myapp-00001", "percent": 90},
+				{"revisionName": "myapp-00000", "percent": 10}]}}`, nil
+		}
+		return fmt.Sprintf(`{"status": {"latestCreatedRevisionName": "myapp-00002", "traffic": [
+			{"revisionName": "myapp-00001", "percent": 90},
+			{"revisionName": "myapp-00000", "percent": 10},
+			{"revisionName": "myapp-00002", "percent": 0, "tag": "canary", "url": %q}]}}`, f.url), nil
+	case line == "auth print-identity-token":
+		return "token\n", nil
+	}
+	return "", nil
+}
+
+func rolloutSettings(gate *manifest.RolloutGate) *manifest.Rollout {
+	return &manifest.Rollout{
+		Tag:   manifest.DefaultRolloutTag,
+		Steps: []manifest.RolloutStep{{Percent: 5, BakeSeconds: 600}, {Percent: 25, BakeSeconds: 600}, {Percent: 100}},
+		Gate:  gate,
+	}
+}
+
+// rollout deploys a single-region service with rollout settings, logging
+// state to stateDir. sleep, when set, replaces the fake clock's sleep.
+func (s *deploySuite) rollout(rollout *manifest.Rollout, executor *fakeExecutor, stateDir string, sleep func(context.Context, time.Duration) error, args ...string) (string, error) {
+	config := testConfig(ResourceTypeService, "us-central1")
+	config.Rollout = rollout
+
+	var stdout bytes.Buffer
+	clock := time.Unix(0, 0)
+	deployer := NewDeployer(config, executor, s.T().TempDir())
+	deployer.stdout = &stdout
+	deployer.stderr = io.Discard
+	deployer.getenv = func(key string) string {
+		switch key {
+		case "CLOUDRUN_ROLLOUT_STATE_DIR":
+			return stateDir
+		case "BUILD_WORKSPACE_DIRECTORY":
+			return "/workspace"
+		}
+		return ""
+	}
+	deployer.now = func() time.Time { return clock }
+	deployer.sleep = func(ctx context.Context, duration time.Duration) error {
+		clock = clock.Add(duration)
+		if sleep != nil {
+			return sleep(ctx, duration)
+		}
+		return nil
+	}
+	err := deployer.Run(context.Background(), args)
+	return stdout.String(), err
+}
+
+// updateTrafficLines returns the traffic flags of every update-traffic
+// command.
+func updateTrafficLines(executor *fakeExecutor) []string {
+	var flags []string
+	for _, command := range executor.commands {
+		if len(command.Args) > 4 && command.Args[2] == "update-traffic" {
+			flags = append(flags, command.Args[4])
+		}
+	}
+	return flags
+}
+
+func (s *deploySuite) TestRollout() {
+	steps := []string{
+		"--to-revisions=myapp-00002=5,myapp-00001=86,myapp-00000=9",
+		"--to-revisions=myapp-00002=25,myapp-00001=68,myapp-00000=7",
+		"--to-latest",
+		"--remove-tags=canary",
+	}
+
+	s.Run("deploys without traffic and shifts it in gated steps", func() {
+		var requests []string
+		server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path+" "+r.Header.Get("Authorization"))
+		}))
+		defer server.Close()
+
+		stateDir := s.T().TempDir()
+		cloudRun := &fakeRollout{url: server.URL}
+		executor := &fakeExecutor{respond: cloudRun.respond}
+		output, err := s.rollout(rolloutSettings(&manifest.RolloutGate{HTTP: &manifest.HTTPGate{Path: "/healthz"}}), executor, stateDir, nil)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), steps, updateTrafficLines(executor))
+		require.Equal(s.T(), []string{"/healthz Bearer token", "/healthz Bearer token"}, requests)
+		require.Contains(s.T(), output, "Rollout step 1/3: sending 5% of the traffic to myapp-00002\nBaking for 600s\n")
+		require.Contains(s.T(), output, "Rollout of revision myapp-00002 completed\n")
+
+		var replaced string
+		for _, command := range executor.commands {
+			if strings.Contains(strings.Join(command.Args, " "), " replace ") {
+				replaced = command.manifest
+			}
+		}
+		require.Contains(s.T(), replaced, `  traffic:
+  - percent: 90
+    revisionName: myapp-00001
+  - percent: 10
+    revisionName: myapp-00000
+  - latestRevision: true
+    percent: 0
+    tag: canary
+`)
+
+		deployer := NewDeployer(testConfig(ResourceTypeService, "us-central1"), executor, "")
+		deployer.getenv = func(string) string { return stateDir }
+		state, err := deployer.loadRolloutState()
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), rolloutCompleted, state.Status)
+		require.Equal(s.T(), "myapp-00002", state.Revision)
+	})
+
+	s.Run("rolls back when the gate command fails", func() {
+		stateDir := s.T().TempDir()
+		cloudRun := &fakeRollout{url: "https://canary---myapp.a.run.app", gate: exitError(1)}
+		executor := &fakeExecutor{respond: cloudRun.respond}
+		_, err := s.rollout(rolloutSettings(&manifest.RolloutGate{Command: []string{"./check.sh", "--strict"}}), executor, stateDir, nil)
+		require.ErrorContains(s.T(), err, "rollout of revision myapp-00002 failed the health gate at 5%: ./check.sh --strict: exit status 1; traffic restored to myapp-00001=90,myapp-00000=10")
+		require.Equal(s.T(), []string{steps[0], "--to-revisions=myapp-00001=90,myapp-00000=10", "--remove-tags=canary"}, updateTrafficLines(executor))
+
+		var gate Command
+		for _, command := range executor.commands {
+			if command.Path == "./check.sh" {
+				gate = command.Command
+			}
+		}
+		require.Equal(s.T(), "/workspace", gate.Dir)
+		require.Equal(s.T(), []string{
+			"CLOUDRUN_SERVICE=myapp",
+			"CLOUDRUN_REVISION=myapp-00002",
+			"CLOUDRUN_URL=https://canary---myapp.a.run.app",
+			"CLOUDRUN_PERCENT=5",
+		}, gate.Env)
+
+		// A rolled back rollout does not block the next deploy.
+		cloudRun = &fakeRollout{url: "https://canary---myapp.a.run.app"}
+		_, err = s.rollout(rolloutSettings(nil), &fakeExecutor{respond: cloudRun.respond}, stateDir, nil)
+		require.NoError(s.T(), err)
+	})
+
+	s.Run("resumes or aborts an interrupted rollout", func() {
+		stateDir := s.T().TempDir()
+		interrupt := func(context.Context, time.Duration) error { return context.Canceled }
+		cloudRun := &fakeRollout{}
+		_, err := s.rollout(rolloutSettings(nil), &fakeExecutor{respond: cloudRun.respond}, stateDir, interrupt)
+		require.ErrorContains(s.T(), err, "rollout of revision myapp-00002 interrupted at step 1: context canceled; pass --resume to continue it or --abort to roll it back")
+
+		executor := &fakeExecutor{respond: (&fakeRollout{}).respond}
+		_, err = s.rollout(rolloutSettings(nil), executor, stateDir, nil)
+		require.ErrorContains(s.T(), err, "the rollout of revision myapp-00002 is in progress at step 1 of 3")
+		require.Empty(s.T(), executor.commandLines())
+
+		executor = &fakeExecutor{respond: (&fakeRollout{replaced: true}).respond}
+		output, err := s.rollout(rolloutSettings(nil), executor, stateDir, nil, "--resume")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "Resuming the rollout of revision myapp-00002 at step 1 of 3\n")
+		require.Equal(s.T(), steps, updateTrafficLines(executor))
+
+		_, err = s.rollout(rolloutSettings(nil), &fakeExecutor{}, stateDir, nil, "--resume")
+		require.ErrorContains(s.T(), err, "no rollout of myapp is in progress")
+
+		cloudRun = &fakeRollout{}
+		calls := 0
+		interruptSecond := func(context.Context, time.Duration) error {
+			if calls++; calls == 2 {
+				return context.Canceled
+			}
+			return nil
+		}
+		_, err = s.rollout(rolloutSettings(nil), &fakeExecutor{respond: cloudRun.respond}, stateDir, interruptSecond)
+		require.ErrorContains(s.T(), err, "interrupted at step 2")
+
+		executor = &fakeExecutor{}
+		output, err = s.rollout(rolloutSettings(nil), executor, stateDir, nil, "--abort")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "Aborting the rollout of revision myapp-00002; restoring traffic to myapp-00001=90,myapp-00000=10\n")
+		require.Equal(s.T(), []string{"--to-revisions=myapp-00001=90,myapp-00000=10", "--remove-tags=canary"}, updateTrafficLines(executor))
+	})
+
+	s.Run("deploys the first revision without a rollout", func() {
+		executor := &fakeExecutor{respond: func(command Command) (string, error) {
+			if strings.HasPrefix(strings.Join(command.Args, " "), "run services describe") {
+				return "", exitError(1)
+			}
+			return "", nil
+		}}
+		output, err := s.rollout(rolloutSettings(nil), executor, s.T().TempDir(), nil)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "No revision serves traffic yet; deploying without a rollout\n")
+		require.Empty(s.T(), updateTrafficLines(executor))
+	})
+
+	s.Run("rejects --resume together with --abort", func() {
+		_, err := s.rollout(rolloutSettings(nil), &fakeExecutor{}, s.T().TempDir(), nil, "--resume", "--abort")
+		require.ErrorContains(s.T(), err, "--resume and --abort are mutually exclusive")
+	})
+
+	s.Run("prints the steps in a dry run", func() {
+		output, err := s.rollout(rolloutSettings(&manifest.RolloutGate{HTTP: &manifest.HTTPGate{Path: "/healthz"}}), &fakeExecutor{}, s.T().TempDir(), nil, "--dry-run")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "Would deploy the new revision without traffic on its canary tag and roll out 5% (bake 600s) -> 25% (bake 600s) -> 100%, gated by GET /healthz on the canary tag\n")
+	})
+}
+
+func (s *deploySuite) TestSplitTraffic() {
+	previous := []trafficTarget{{RevisionName: "a", Percent: 70}, {RevisionName: "b", Percent: 30}}
+	require.Equal(s.T(), "new=5,a=67,b=28", splitTraffic("new", 5, previous))
+	require.Equal(s.T(), "new=50,a=35,b=15", splitTraffic("new", 50, previous))
+	require.Equal(s.T(), "new=99,a=1", splitTraffic("new", 99, previous))
+	require.Equal(s.T(), "new=100", splitTraffic("new", 100, previous))
+	require.Equal(s.T(), "new=10,a=90", splitTraffic("new", 10, []trafficTarget{{RevisionName: "a", Percent: 100}}))
+}
@@ -0,0 +1,264 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+)
+
+// verifyPollInterval is the pause between readiness polls and smoke test
+// attempts.
+const verifyPollInterval = 5 * time.Second
+
+// trafficTarget is an entry of a service's status.traffic.
+type trafficTarget struct {
+	RevisionName   string `json:"revisionName"`
+	Percent        int    `json:"percent"`
+	Tag            string `json:"tag"`
+	URL            string `json:"url"`
+	LatestRevision bool   `json:"latestRevision"`
+}
+
+// serviceDescription is the part of `gcloud run services describe
+// --format=json` the verification reads.
+type serviceDescription struct {
+	Status struct {
+		LatestCreatedRevisionName string          `json:"latestCreatedRevisionName"`
+		Traffic                   []trafficTarget `json:"traffic"`
+	} `json:"status"`
+}
+
+// revisionDescription is the part of `gcloud run revisions describe
+// --format=json` the verification reads.
+type revisionDescription struct {
+	Status struct {
+		Conditions []struct {
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// servingTraffic returns the revisions that serve traffic, as recorded
+// before the deploy. A service that does not exist yet has none.
+func (d *Deployer) servingTraffic(ctx context.Context, gcloud string) []trafficTarget {
+	var service serviceDescription
+	if err := d.describeJSON(ctx, gcloud, []string{"run", "services", "describe", d.config.Name}, &service); err != nil {
+		return nil
+	}
+	var serving []trafficTarget
+	for _, target := range service.Status.Traffic {
+		if target.Percent > 0 && target.RevisionName != "" {
+			serving = append(serving, target)
+		}
+	}
+	return serving
+}
+
+// verify waits for the revision created by replace to become ready and
+// runs the smoke test. When either fails, the traffic split recorded in
+// previous is restored unless rollback is disabled.
+func (d *Deployer) verify(ctx context.Context, gcloud string, previous []trafficTarget) error {
+	verify := d.config.Verify
+	timeout := manifest.DefaultVerifyTimeoutSeconds
+	if verify.TimeoutSeconds != nil {
+		timeout = *verify.TimeoutSeconds
+	}
+	deadline := d.now().Add(time.Duration(timeout) * time.Second)
+
+	var service serviceDescription
+	if err := d.describeJSON(ctx, gcloud, []string{"run", "services", "describe", d.config.Name}, &service); err != nil {
+		return fmt.Errorf("verify: describe service %s: %w", d.config.Name, err)
+	}
+	revision := service.Status.LatestCreatedRevisionName
+	if revision == "" {
+		return fmt.Errorf("verify: service %s has no latest created revision", d.config.Name)
+	}
+
+	_, _ = fmt.Fprintf(d.stdout, "Verifying revision %s (timeout %ds)\n", revision, timeout)
+	err := d.waitReady(ctx, gcloud, revision, deadline)
+	if err == nil && verify.Path != "" {
+		err = d.smokeTest(ctx, gcloud, revision, deadline)
+	}
+	if err == nil {
+		_, _ = fmt.Fprintf(d.stdout, "Revision %s verified\n", revision)
+		return nil
+	}
+
+	err = fmt.Errorf("verification of revision %s failed: %w", revision, err)
+	if verify.Rollback != nil && !*verify.Rollback {
+		return err
+	}
+	return d.rollback(ctx, gcloud, previous, err)
+}
+
+// waitReady polls the revision until its Ready condition is True or False,
+// or the deadline passes.
+func (d *Deployer) waitReady(ctx context.Context, gcloud, revision string, deadline time.Time) error {
+	for {
+		var description revisionDescription
+		err := d.describeJSON(ctx, gcloud, []string{"run", "revisions", "describe", revision}, &description)
+		if err == nil {
+			for _, condition := range description.Status.Conditions {
+				if condition.Type != "Ready" {
+					continue
+				}
+				switch condition.Status {
+				case "True":
+					_, _ = fmt.Fprintf(d.stdout, "Revision %s is ready\n", revision)
+					return nil
+				case "False":
+					return fmt.Errorf("revision is not ready: %s", condition.Message)
+				}
+			}
+			err = errors.New("revision is not ready yet")
+		}
+		if !d.now().Before(deadline) {
+			return fmt.Errorf("timed out waiting for readiness: %w", err)
+		}
+		if err := d.sleep(ctx, verifyPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// smokeTest tags the revision, requests the configured path on its tagged
+// URL until it answers with a 2xx status or the deadline passes, and
+// removes the tag again.
+func (d *Deployer) smokeTest(ctx context.Context, gcloud, revision string, deadline time.Time) error {
+	tag := d.config.Verify.Tag
+	if tag == "" {
+		tag = manifest.DefaultVerifyTag
+	}
+	if err := d.updateTraffic(ctx, gcloud, "--update-tags="+tag+"="+revision); err != nil {
+		return fmt.Errorf("tag revision: %w", err)
+	}
+	defer d.removeTag(ctx, gcloud, tag)
+
+	var service serviceDescription
+	if err := d.describeJSON(ctx, gcloud, []string{"run", "services", "describe", d.config.Name}, &service); err != nil {
+		return fmt.Errorf("describe tagged service: %w", err)
+	}
+	var url string
+	for _, target := range service.Status.Traffic {
+		if target.Tag == tag {
+			url = target.URL
+		}
+	}
+	if url == "" {
+		return fmt.Errorf("service %s has no URL for tag %s", d.config.Name, tag)
+	}
+	url = strings.TrimSuffix(url, "/") + d.config.Verify.Path
+
+	token := d.identityToken(ctx, gcloud)
+	_, _ = fmt.Fprintf(d.stdout, "Smoke testing %s\n", url)
+	for {
+		err := d.probe(ctx, url, token)
+		if err == nil {
+			return nil
+		}
+		if !d.now().Before(deadline) {
+			return fmt.Errorf("smoke test of %s: %w", url, err)
+		}
+		if err := d.sleep(ctx, verifyPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *Deployer) probe(ctx context.Context, url, token string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := d.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("got status %s", response.Status)
+	}
+	return nil
+}
+
+// identityToken returns an identity token of the active gcloud account so
+// that the smoke test also reaches services that require authentication.
+// Without one, the request is sent unauthenticated.
+func (d *Deployer) identityToken(ctx context.Context, gcloud string) string {
+	var token bytes.Buffer
+	if err := d.executor.Run(ctx, Command{
+		Path:   gcloud,
+		Args:   []string{"auth", "print-identity-token"},
+		Stdout: &token,
+		Stderr: io.Discard,
+	}); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(token.String())
+}
+
+// rollback restores the traffic split recorded before the deploy.
+func (d *Deployer) rollback(ctx context.Context, gcloud string, previous []trafficTarget, cause error) error {
+	if len(previous) == 0 {
+		return fmt.Errorf("%w; no previous revision to roll back to", cause)
+	}
+	split := splitOf(previous)
+	_, _ = fmt.Fprintf(d.stdout, "Rolling back traffic to %s\n", split)
+	if err := d.updateTraffic(ctx, gcloud, "--to-revisions="+split); err != nil {
+		return fmt.Errorf("%w; rollback failed: %w", cause, err)
+	}
+	return fmt.Errorf("%w; traffic restored to %s", cause, split)
+}
+
+// splitOf formats a traffic split as gcloud --to-revisions expects it.
+func splitOf(targets []trafficTarget) string {
+	split := make([]string, 0, len(targets))
+	for _, target := range targets {
+		split = append(split, fmt.Sprintf("%s=%d", target.RevisionName, target.Percent))
+	}
+	return strings.Join(split, ",")
+}
+
+func (d *Deployer) updateTraffic(ctx context.Context, gcloud, flag string) error {
+	args := append([]string{"run", "services", "update-traffic", d.config.Name, flag}, d.locationArgs()...)
+	return d.executor.Run(ctx, Command{
+		Path:   gcloud,
+		Args:   append(args, "--quiet"),
+		Stdout: io.Discard,
+		Stderr: d.stderr,
+	})
+}
+
+// describeJSON runs a gcloud describe command in the resource's location
+// and decodes its JSON output into v.
+func (d *Deployer) describeJSON(ctx context.Context, gcloud string, args []string, v interface{}) error {
+	args = append(append(args, d.locationArgs()...), "--format=json")
+	return d.runJSON(ctx, Command{Path: gcloud, Args: args, Stderr: io.Discard}, v)
+}
+
+// runJSON runs a gcloud command and decodes its JSON output into v.
+func (d *Deployer) runJSON(ctx context.Context, command Command, v interface{}) error {
+	var output bytes.Buffer
+	command.Stdout = &output
+	if err := d.executor.Run(ctx, command); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(output.Bytes(), v); err != nil {
+		return fmt.Errorf("parse %s output: %w", strings.Join(command.Args[:min(3, len(command.Args))], " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloudRun answers the gcloud commands of a verified deploy. The
+// service serves myapp-00001 before the deploy and myapp-00002 after it.
+type fakeCloudRun struct {
+	// conditions are the Ready condition statuses returned by successive
+	// revision describes; the last one repeats.
+	conditions []string
+	// url is the tagged URL of the new revision.
+	url      string
+	replaced bool
+	describe int
+	exists   bool
+}
+
+func (f *fakeCloudRun) respond(command Command) (string, error) {
+	line := strings.Join(command.Args, " ")
+	switch {
+	case strings.Contains(line, " replace "):
+		f.replaced = true
+	case strings.HasPrefix(line, "run services describe"):
+		if !f.exists && !f.replaced {
+			return "", exitError(1)
+		}
+		if !f.replaced {
+			return `{"status": {"latestCreatedRevisionName": "myapp-00001", "traffic": [
+				{"revisionName": "myapp-00001", "percent": 90},
+				{"revisionName": "myapp-00000", "percent": 10},
+				{"revisionName": "myapp-00000", "percent": 0, "tag": "old"}]}}`, nil
+		}
+		return fmt.Sprintf(`{"status": {"latestCreatedRevisionName": "myapp-00002", "traffic": [
+			{"revisionName": "myapp-00002", "percent": 100, "latestRevision": true},
+			{"revisionName": "myapp-00002", "percent": 0, "tag": "verify", "url": %q}]}}`, f.url), nil
+	case strings.HasPrefix(line, "run revisions describe myapp-00002"):
+		status := f.conditions[min(f.describe, len(f.conditions)-1)]
+		f.describe++
+		return fmt.Sprintf(`{"status": {"conditions": [
+			{"type": "ConfigurationsReady", "status": "True"},
+			{"type": "Ready", "status": %q, "message": "container failed to start"}]}}`, status), nil
+	case line == "auth print-identity-token":
+		return "token\n", nil
+	}
+	return "", nil
+}
+
+// verify deploys a single-region service with verification settings
+// against cloudRun. The clock advances by every sleep.
+func (s *deploySuite) verify(verify *manifest.Verify, cloudRun *fakeCloudRun) (*fakeExecutor, string, error) {
+	config := testConfig(ResourceTypeService, "us-central1")
+	config.Verify = verify
+	executor := &fakeExecutor{respond: cloudRun.respond}
+
+	var stdout bytes.Buffer
+	clock := time.Unix(0, 0)
+	deployer := NewDeployer(config, executor, s.T().TempDir())
+	deployer.stdout = &stdout
+	deployer.stderr = io.Discard
+	deployer.getenv = func(string) string { return "" }
+	deployer.now = func() time.Time { return clock }
+	deployer.sleep = func(_ context.Context, duration time.Duration) error {
+		clock = clock.Add(duration)
+		return nil
+	}
+	err := deployer.Run(context.Background(), nil)
+	return executor, stdout.String(), err
+}
+
+func verifySettings(path string, timeoutSeconds int, rollback bool) *manifest.Verify {
+	verify := &manifest.Verify{TimeoutSeconds: &timeoutSeconds, Rollback: &rollback, Path: path}
+	if path != "" {
+		verify.Tag = manifest.DefaultVerifyTag
+	}
+	return verify
+}
+
+func (s *deploySuite) TestVerify() {
+	location := " --region=us-central1 --project=my-project"
+	rollback := "run services update-traffic myapp --to-revisions=myapp-00001=90,myapp-00000=10" + location + " --quiet"
+
+	s.Run("waits for readiness and smoke tests the tagged URL", func() {
+		var requests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path+" "+r.Header.Get("Authorization"))
+			if len(requests) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer server.Close()
+
+		cloudRun := &fakeCloudRun{conditions: []string{"Unknown", "True"}, url: server.URL, exists: true}
+		executor, output, err := s.verify(verifySettings("/healthz", 60, true), cloudRun)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{"/healthz Bearer token", "/healthz Bearer token"}, requests)
+		require.Contains(s.T(), output, "Revision myapp-00002 is ready\n")
+		require.Contains(s.T(), output, "Smoke testing "+server.URL+"/healthz\n")
+		require.Contains(s.T(), output, "Revision myapp-00002 verified\n")
+
+		lines := executor.commandLines()
+		require.Equal(s.T(), "run services describe myapp"+location+" --format=json", lines[0])
+		require.Contains(s.T(), lines[1], "beta run services replace ")
+		require.Equal(s.T(), []string{
+			"run services describe myapp" + location + " --format=json",
+			"run revisions describe myapp-00002" + location + " --format=json",
+			"run revisions describe myapp-00002" + location + " --format=json",
+			"run services update-traffic myapp --update-tags=verify=myapp-00002" + location + " --quiet",
+			"run services describe myapp" + location + " --format=json",
+			"auth print-identity-token",
+			"run services update-traffic myapp --remove-tags=verify" + location + " --quiet",
+		}, lines[2:])
+	})
+
+	s.Run("rolls back a revision that fails to become ready", func() {
+		cloudRun := &fakeCloudRun{conditions: []string{"False"}, exists: true}
+		executor, output, err := s.verify(verifySettings("", 60, true), cloudRun)
+		require.ErrorContains(s.T(), err, "verification of revision myapp-00002 failed: revision is not ready: container failed to start; traffic restored to myapp-00001=90,myapp-00000=10")
+		require.Contains(s.T(), output, "Rolling back traffic to myapp-00001=90,myapp-00000=10\n")
+		lines := executor.commandLines()
+		require.Equal(s.T(), rollback, lines[len(lines)-1])
+	})
+
+	s.Run("rolls back a failing smoke test after the timeout", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		cloudRun := &fakeCloudRun{conditions: []string{"True"}, url: server.URL, exists: true}
+		executor, _, err := s.verify(verifySettings("/", 20, true), cloudRun)
+		require.ErrorContains(s.T(), err, "smoke test of "+server.URL+"/: got status 500 Internal Server Error")
+		require.ErrorContains(s.T(), err, "traffic restored")
+
+		lines := executor.commandLines()
+		require.Contains(s.T(), lines, "run services update-traffic myapp --remove-tags=verify"+location+" --quiet")
+		require.Equal(s.T(), rollback, lines[len(lines)-1])
+	})
+
+	s.Run("times out waiting for readiness", func() {
+		cloudRun := &fakeCloudRun{conditions: []string{"Unknown"}, exists: true}
+		executor, _, err := s.verify(verifySettings("", 12, true), cloudRun)
+		require.ErrorContains(s.T(), err, "timed out waiting for readiness: revision is not ready yet")
+		require.Equal(s.T(), 4, cloudRun.describe)
+		lines := executor.commandLines()
+		require.Equal(s.T(), rollback, lines[len(lines)-1])
+	})
+
+	s.Run("keeps the new revision when rollback is disabled", func() {
+		cloudRun := &fakeCloudRun{conditions: []string{"False"}, exists: true}
+		executor, _, err := s.verify(verifySettings("", 60, false), cloudRun)
+		require.ErrorContains(s.T(), err, "revision is not ready")
+		require.NotContains(s.T(), err.Error(), "traffic restored")
+		require.NotContains(s.T(), strings.Join(executor.commandLines(), "\n"), "update-traffic")
+	})
+
+	s.Run("reports a first deploy that cannot be rolled back", func() {
+		cloudRun := &fakeCloudRun{conditions: []string{"False"}}
+		_, _, err := s.verify(verifySettings("", 60, true), cloudRun)
+		require.ErrorContains(s.T(), err, "no previous revision to roll back to")
+	})
+
+	s.Run("skips domains and IAM after a failed verification", func() {
+		config := testConfig(ResourceTypeService, "us-central1")
+		config.Verify = verifySettings("", 60, true)
+		config.IAMBindings = []IAMBinding{{Role: "roles/run.invoker", Member: "allUsers"}}
+		cloudRun := &fakeCloudRun{conditions: []string{"False"}, exists: true}
+		executor := &fakeExecutor{respond: cloudRun.respond}
+		_, err := s.deploy(config, executor)
+		require.Error(s.T(), err)
+		require.NotContains(s.T(), strings.Join(executor.commandLines(), "\n"), "add-iam-policy-binding")
+	})
+}
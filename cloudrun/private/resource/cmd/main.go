@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/justinswe/rules_cloudrun/cloudrun/private/deploy"
 	"github.com/justinswe/rules_cloudrun/cloudrun/private/resource"
 	"github.com/spf13/cobra"
 )
 
 func main() {
-	if err := newRootCommand().Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := newRootCommand().ExecuteContext(ctx); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
+		// Deploys exit with the status of the gcloud command that failed.
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			os.Exit(exitErr.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
@@ -33,18 +45,146 @@ func newRootCommand() *cobra.Command {
 	flags.IntVar(&options.TimeoutSeconds, "timeout", 300, "Request timeout in seconds")
 	flags.StringVar(&options.ResourceType, "resource-type", "service", "Cloud Run resource type")
 	flags.StringVar(&options.OutputPath, "output", "", "Output manifest path")
+	flags.StringVar(&options.Environment, "environment", "", "Deployment environment name (e.g. dev, prd)")
+	flags.BoolVar(&options.RequireDigest, "require-digest", false, "Fail unless the image is pinned by digest")
+	flags.StringVar(&options.PolicyPath, "policy", "", "Optional policy rules file evaluated against the rendered manifest")
+	flags.StringSliceVar(&options.Regions, "regions", nil, "Every region the resource is deployed to (defaults to --region)")
+	flags.StringVar(&options.DomainMappingsPath, "domain-mappings-output", "", "Optional path for the DomainMapping manifests of the config's domains")
+	flags.StringVar(&options.LoadBalancerPath, "load-balancer-output", "", "Optional path for the Terraform JSON load balancer in front of the service")
+	flags.StringVar(&options.IAMPolicyPath, "iam-policy-output", "", "Optional path for the IAM policy granting the config's iam section")
+	flags.StringVar(&options.DeploySettingsPath, "deploy-settings-output", "", "Optional path for the config's deploy section, used by the .deploy target")
+	flags.StringVar(&options.ProjectNumber, "project-number", "", "Deploy project number, for dependency URLs and cross-project secret aliases")
+	flags.StringVar(&options.ConfigDir, "config-dir", "", "Directory envFrom paths are resolved against (defaults to the config directory)")
+	flags.BoolVar(&options.WarningsAsErrors, "warnings-as-errors", false, "Fail when rendering produces any warning")
+	flags.StringVar(&options.Format, "format", "knative-yaml", "Output format: knative-yaml, knative-json, v2-json, or terraform-json")
 	_ = command.MarkFlagRequired("config")
 	_ = command.MarkFlagRequired("service-name")
 	_ = command.MarkFlagRequired("region")
 	_ = command.MarkFlagRequired("image")
 	_ = command.MarkFlagRequired("output")
 
+	command.AddCommand(newLintCommand(), newPatchCommand(), newDeployCommand(), newDeployConfigCommand())
+
+	return command
+}
+
+func newLintCommand() *cobra.Command {
+	options := &resource.LintOptions{}
+	command := &cobra.Command{
+		Use:   "lint",
+		Short: "Check a rendered manifest against policy guardrails",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return resource.NewRenderer(nil).Lint(*options)
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := command.Flags()
+	flags.StringVar(&options.ManifestPath, "manifest", "", "Rendered Knative manifest path (YAML or JSON)")
+	flags.StringVar(&options.PolicyPath, "policy", "", "Policy rules file; the built-in policies run when omitted")
+	flags.StringVar(&options.Environment, "environment", "", "Deployment environment name (e.g. dev, prd)")
+	_ = command.MarkFlagRequired("manifest")
+
+	return command
+}
+
+func newPatchCommand() *cobra.Command {
+	options := &resource.PatchOptions{}
+	var container string
+	var images []string
+	command := &cobra.Command{
+		Use:   "patch --manifest <path> --output <path> [--container <name>] --image [<container>=]<ref>...",
+		Short: "Override container images in a rendered manifest",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if container != "" && len(images) != 1 {
+				return errors.New("--container takes exactly one --image")
+			}
+			for _, value := range images {
+				if container != "" {
+					value = container + "=" + value
+				}
+				patch, err := resource.ParseImagePatch(value)
+				if err != nil {
+					return err
+				}
+				options.Images = append(options.Images, patch)
+			}
+			return resource.NewRenderer(nil).Patch(*options)
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := command.Flags()
+	flags.StringVar(&options.ManifestPath, "manifest", "", "Rendered Knative manifest path (YAML or JSON)")
+	flags.StringVar(&options.OutputPath, "output", "", "Output manifest path")
+	flags.StringVar(&container, "container", "", "Container whose image --image overrides (defaults to the main container)")
+	flags.StringArrayVar(&images, "image", nil, "Digest-pinned image, optionally prefixed with <container>=; repeatable")
+	_ = command.MarkFlagRequired("manifest")
+	_ = command.MarkFlagRequired("output")
+	_ = command.MarkFlagRequired("image")
+
+	return command
+}
+
+func newDeployCommand() *cobra.Command {
+	var configPath, runfiles string
+	command := &cobra.Command{
+		Use:   "deploy --config <path> [--runfiles <dir>] -- [--image=[<container>=]<ref>]... [--dry-run] [--plan [--yes]] [--resume | --abort] [--execute | --wait | --no-execute] [--exec-<override>=<value>]... [gcloud flags]",
+		Short: "Deploy a rendered resource with gcloud",
+		RunE: func(command *cobra.Command, args []string) error {
+			config, err := deploy.LoadConfig(configPath)
+			if err != nil {
+				return err
+			}
+			return deploy.NewDeployer(config, nil, runfiles).Run(command.Context(), args)
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := command.Flags()
+	flags.StringVar(&configPath, "config", "", "Deploy config written by deploy-config")
+	flags.StringVar(&runfiles, "runfiles", "", "Runfiles directory holding the push binary and gcloud")
+	_ = command.MarkFlagRequired("config")
+
+	return command
+}
+
+func newDeployConfigCommand() *cobra.Command {
+	options := &deploy.AssembleOptions{}
+	command := &cobra.Command{
+		Use:   "deploy-config",
+		Short: "Assemble the deploy config of a .deploy target",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return deploy.Assemble(*options)
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	flags := command.Flags()
+	flags.StringVar(&options.ConstantsPath, "constants", "", "Build-time deploy constants (JSON)")
+	flags.StringVar(&options.ManifestPath, "manifest", "", "Rendered manifest path")
+	flags.StringVar(&options.DomainMappingsPath, "domain-mappings", "", "Optional rendered DomainMapping manifests")
+	flags.StringVar(&options.IAMPolicyPath, "iam-policy", "", "Optional rendered IAM policy")
+	flags.StringVar(&options.DeploySettingsPath, "deploy-settings", "", "Optional rendered deploy settings")
+	flags.StringVar(&options.OutputPath, "output", "", "Output deploy config path")
+	_ = command.MarkFlagRequired("constants")
+	_ = command.MarkFlagRequired("manifest")
+	_ = command.MarkFlagRequired("output")
+
 	return command
 }
 
 func runRenderer(options *resource.RenderOptions) func(*cobra.Command, []string) error {
-	return func(_ *cobra.Command, _ []string) error {
+	return func(command *cobra.Command, _ []string) error {
 		renderer := resource.NewRenderer(nil)
-		return renderer.RenderManifest(*options)
+		result, err := renderer.RenderManifestContext(command.Context(), *options)
+		for _, warning := range result.Warnings {
+			_, _ = fmt.Fprintf(command.ErrOrStderr(), "WARNING: %s\n", warning)
+		}
+		return err
 	}
 }
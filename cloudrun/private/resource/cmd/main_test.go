@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type mainSuite struct {
+	suite.Suite
+}
+
+func TestMainSuite(t *testing.T) {
+	suite.Run(t, new(mainSuite))
+}
+
+// render runs the root command on a config whose mutable image tag in prd
+// produces a warning.
+func (s *mainSuite) render(dir string, args ...string) (string, error) {
+	config := filepath.Join(dir, "apphosting.yaml")
+	require.NoError(s.T(), os.WriteFile(config, []byte("runConfig: {}\n"), 0o644))
+
+	var stderr bytes.Buffer
+	command := newRootCommand()
+	command.SetErr(&stderr)
+	command.SetArgs(append([]string{
+		"--config", config,
+		"--service-name", "myapp",
+		"--region", "us-central1",
+		"--image", "gcr.io/proj/app:latest",
+		"--environment", "prd",
+		"--output", filepath.Join(dir, "manifest.yaml"),
+		"--iam-policy-output", filepath.Join(dir, "iam.yaml"),
+	}, args...))
+	err := command.ExecuteContext(context.Background())
+	return stderr.String(), err
+}
+
+func (s *mainSuite) TestWarningsAsErrors() {
+	s.Run("prints warnings and writes the outputs by default", func() {
+		dir := s.T().TempDir()
+		stderr, err := s.render(dir)
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), stderr, "WARNING: ")
+		require.FileExists(s.T(), filepath.Join(dir, "manifest.yaml"))
+		require.FileExists(s.T(), filepath.Join(dir, "iam.yaml"))
+	})
+
+	s.Run("fails before writing any output", func() {
+		dir := s.T().TempDir()
+		stderr, err := s.render(dir, "--warnings-as-errors")
+		require.EqualError(s.T(), err, "1 warning(s) treated as errors")
+		require.Contains(s.T(), stderr, "WARNING: ")
+		require.NoFileExists(s.T(), filepath.Join(dir, "manifest.yaml"))
+		require.NoFileExists(s.T(), filepath.Join(dir, "iam.yaml"))
+	})
+}
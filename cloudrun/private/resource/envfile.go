@@ -0,0 +1,196 @@
+package resource
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const (
+	envFormatDotenv = "dotenv"
+	envFormatYAML   = "yaml"
+)
+
+var envVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// resolveEnvFrom merges the envFrom sources of config into its env list.
+// Sources are read in order through fileIO, relative to configDir; a later
+// source overrides a variable from an earlier one, and entries under env:
+// override every source. Imported variables come first, in the order they
+// were first defined.
+func resolveEnvFrom(fileIO FileIO, configDir string, config appHostingConfig) (appHostingConfig, error) {
+	if len(config.EnvFrom) == 0 {
+		return config, nil
+	}
+	if fileIO == nil {
+		return config, errors.New("envFrom requires file access; render with a Renderer")
+	}
+
+	var imported []envEntry
+	index := map[string]int{}
+	for i, source := range config.EnvFrom {
+		entries, err := readEnvSource(fileIO, configDir, source)
+		if err != nil {
+			return config, fmt.Errorf("envFrom[%d]: %w", i, err)
+		}
+		for _, entry := range entries {
+			if position, found := index[entry.Variable]; found {
+				imported[position] = entry
+				continue
+			}
+			index[entry.Variable] = len(imported)
+			imported = append(imported, entry)
+		}
+	}
+
+	explicit := make(map[string]bool, len(config.Env))
+	for _, entry := range config.Env {
+		explicit[entry.Variable] = true
+	}
+	merged := make([]envEntry, 0, len(imported)+len(config.Env))
+	for _, entry := range imported {
+		if !explicit[entry.Variable] {
+			merged = append(merged, entry)
+		}
+	}
+	config.Env = append(merged, config.Env...)
+	config.EnvFrom = nil
+	return config, nil
+}
+
+func readEnvSource(fileIO FileIO, configDir string, source manifest.EnvSource) ([]envEntry, error) {
+	if source.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	path := source.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(configDir, path)
+	}
+
+	format := source.Format
+	if format == "" {
+		format = envFormatDotenv
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			format = envFormatYAML
+		}
+	}
+
+	content, err := fileIO.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", source.Path, err)
+	}
+
+	var entries []envEntry
+	switch format {
+	case envFormatDotenv:
+		entries, err = parseDotenv(content)
+	case envFormatYAML:
+		entries, err = parseYAMLEnv(content)
+	default:
+		return nil, fmt.Errorf("format must be %q or %q, got %q", envFormatDotenv, envFormatYAML, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source.Path, err)
+	}
+	return entries, nil
+}
+
+// parseDotenv reads KEY=VALUE lines. Blank lines, # comments, and an
+// optional "export " prefix are allowed. Values may be single-quoted
+// (literal) or double-quoted (with \n, \t, \" and \\ escapes); unquoted
+// values end at " #".
+func parseDotenv(content []byte) ([]envEntry, error) {
+	var entries []envEntry
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, rawValue, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || !envVariablePattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+		value, err := parseDotenvValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, envEntry{Variable: key, Value: &value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func parseDotenvValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated single-quoted value")
+		}
+		return raw[1 : end+1], nil
+	case strings.HasPrefix(raw, `"`):
+		for end := 1; end < len(raw); end++ {
+			switch raw[end] {
+			case '\\':
+				end++
+			case '"':
+				value, err := strconv.Unquote(raw[:end+1])
+				if err != nil {
+					return "", fmt.Errorf("invalid double-quoted value: %w", err)
+				}
+				return value, nil
+			}
+		}
+		return "", errors.New("unterminated double-quoted value")
+	default:
+		if comment := strings.Index(raw, " #"); comment >= 0 {
+			raw = raw[:comment]
+		}
+		return strings.TrimSpace(raw), nil
+	}
+}
+
+// parseYAMLEnv reads either a bare list of env entries or a document with an
+// env: key, using the same entry shape as apphosting env.
+func parseYAMLEnv(content []byte) ([]envEntry, error) {
+	var node yamlv3.Node
+	if err := yamlv3.Unmarshal(content, &node); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+
+	var entries []envEntry
+	document := node.Content[0]
+	if document.Kind == yamlv3.SequenceNode {
+		if err := document.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("parse env list: %w", err)
+		}
+		return entries, nil
+	}
+	var wrapper struct {
+		Env []envEntry `yaml:"env"`
+	}
+	if err := document.Decode(&wrapper); err != nil {
+		return nil, fmt.Errorf("parse env list: %w", err)
+	}
+	return wrapper.Env, nil
+}
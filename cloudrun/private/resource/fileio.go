@@ -0,0 +1,115 @@
+package resource
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryFileIO is a FileIO that keeps every file in memory. It is safe for
+// concurrent use, so one instance can collect the output of many renders.
+type MemoryFileIO struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemoryFileIO creates a MemoryFileIO seeded with files, keyed by path.
+func NewMemoryFileIO(files map[string][]byte) *MemoryFileIO {
+	memory := &MemoryFileIO{files: map[string][]byte{}}
+	for name, data := range files {
+		memory.files[cleanFilePath(name)] = append([]byte{}, data...)
+	}
+	return memory
+}
+
+func (m *MemoryFileIO) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, found := m.files[cleanFilePath(name)]
+	if !found {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *MemoryFileIO) WriteFile(name string, data []byte, _ os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[cleanFilePath(name)] = append([]byte{}, data...)
+	return nil
+}
+
+// MkdirAll is a no-op: directories are implied by file paths.
+func (m *MemoryFileIO) MkdirAll(string, os.FileMode) error {
+	return nil
+}
+
+// Files returns a copy of every file currently held, keyed by cleaned path.
+func (m *MemoryFileIO) Files() map[string][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	files := make(map[string][]byte, len(m.files))
+	for name, data := range m.files {
+		files[name] = append([]byte{}, data...)
+	}
+	return files
+}
+
+// Paths returns the sorted paths of every file currently held.
+func (m *MemoryFileIO) Paths() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	paths := make([]string, 0, len(m.files))
+	for name := range m.files {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// FSFileIO reads from an fs.FS (for example an embed.FS or a zip.Reader) and
+// keeps writes in an in-memory overlay. Reads see overlay files first, so a
+// rendered manifest can be read back without touching the underlying FS.
+type FSFileIO struct {
+	base    fs.FS
+	overlay *MemoryFileIO
+}
+
+// NewFSFileIO creates an FSFileIO over fsys with an empty overlay.
+func NewFSFileIO(fsys fs.FS) *FSFileIO {
+	return &FSFileIO{base: fsys, overlay: NewMemoryFileIO(nil)}
+}
+
+func (f *FSFileIO) ReadFile(name string) ([]byte, error) {
+	if data, err := f.overlay.ReadFile(name); err == nil {
+		return data, nil
+	}
+	return fs.ReadFile(f.base, cleanFilePath(name))
+}
+
+func (f *FSFileIO) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return f.overlay.WriteFile(name, data, perm)
+}
+
+func (f *FSFileIO) MkdirAll(name string, perm os.FileMode) error {
+	return f.overlay.MkdirAll(name, perm)
+}
+
+// Overlay returns the in-memory layer holding every file written so far.
+func (f *FSFileIO) Overlay() *MemoryFileIO {
+	return f.overlay
+}
+
+// cleanFilePath converts a path into the unrooted, slash-separated form
+// fs.FS expects.
+func cleanFilePath(name string) string {
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "" {
+		return "."
+	}
+	return cleaned
+}
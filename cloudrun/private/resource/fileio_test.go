@@ -0,0 +1,131 @@
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *rendererSuite) TestMemoryFileIO() {
+	s.Run("reads back written files", func() {
+		memory := NewMemoryFileIO(map[string][]byte{"./configs/app.yaml": []byte("env: []\n")})
+
+		content, err := memory.ReadFile("configs/app.yaml")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "env: []\n", string(content))
+
+		require.NoError(s.T(), memory.WriteFile("/out/app.yaml", []byte("kind: Service\n"), 0o644))
+		require.Equal(s.T(), []string{"configs/app.yaml", "out/app.yaml"}, memory.Paths())
+		require.Equal(s.T(), "kind: Service\n", string(memory.Files()["out/app.yaml"]))
+	})
+
+	s.Run("reports missing files as not exist", func() {
+		_, err := NewMemoryFileIO(nil).ReadFile("missing.yaml")
+		require.True(s.T(), errors.Is(err, fs.ErrNotExist))
+	})
+}
+
+func (s *rendererSuite) TestFSFileIO() {
+	s.Run("renders from an fs.FS into the overlay", func() {
+		fileIO := NewFSFileIO(fstest.MapFS{
+			"services/myapp/apphosting.yaml": {Data: []byte("runConfig:\n  maxInstances: 4\n")},
+		})
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "services/myapp/apphosting.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "out/myapp.yaml",
+		})
+		require.NoError(s.T(), err)
+
+		manifest, err := fileIO.ReadFile("out/myapp.yaml")
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(manifest), `run.googleapis.com/maxScale: "4"`)
+		require.Equal(s.T(), []string{"out/myapp.yaml"}, fileIO.Overlay().Paths())
+	})
+
+	s.Run("prefers overlay files over the base FS", func() {
+		fileIO := NewFSFileIO(fstest.MapFS{"app.yaml": {Data: []byte("base")}})
+		require.NoError(s.T(), fileIO.WriteFile("app.yaml", []byte("overlay"), 0o644))
+
+		content, err := fileIO.ReadFile("app.yaml")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "overlay", string(content))
+	})
+}
+
+func (s *rendererSuite) TestRenderToBytes() {
+	options := RenderOptions{
+		ServiceName:  "myapp",
+		Region:       "us-central1",
+		Image:        "example.com/myapp@" + testDigest,
+		ResourceType: "service",
+	}
+
+	s.Run("renders without file access", func() {
+		manifest, err := RenderToBytes(context.Background(), options, []byte("runConfig:\n  cpu: 2\n"))
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(manifest), `cpu: "2"`)
+	})
+
+	s.Run("renders other formats", func() {
+		jobOptions := options
+		jobOptions.ResourceType = "job"
+		jobOptions.Format = "v2-json"
+		manifest, err := RenderToBytes(context.Background(), jobOptions, []byte("runConfig:\n  taskCount: 3\n"))
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(manifest), `"taskCount": 3`)
+	})
+
+	s.Run("honours context cancellation", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := RenderToBytes(ctx, options, []byte("runConfig: {}\n"))
+		require.ErrorIs(s.T(), err, context.Canceled)
+	})
+
+	s.Run("rejects policy paths", func() {
+		withPolicy := options
+		withPolicy.PolicyPath = "policy.yaml"
+		_, err := RenderToBytes(context.Background(), withPolicy, []byte("runConfig: {}\n"))
+		require.Error(s.T(), err)
+	})
+}
+
+func (s *rendererSuite) TestRenderToResult() {
+	options := RenderOptions{
+		ServiceName:  "myapp",
+		Region:       "us-central1",
+		Image:        "example.com/myapp:latest",
+		Environment:  "prd",
+		ResourceType: "service",
+	}
+
+	s.Run("returns the side outputs with the manifest", func() {
+		result, err := RenderToResult(context.Background(), options, []byte("iam:\n  invokers: [allUsers]\n"))
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(result.Manifest), "image: example.com/myapp:latest")
+		digest := sha256.Sum256(result.Manifest)
+		require.Equal(s.T(), "sha256:"+hex.EncodeToString(digest[:]), result.ContentHash)
+		require.Len(s.T(), result.Warnings, 1)
+		require.Equal(s.T(), WarningMutableImageTag, result.Warnings[0].Code)
+		require.Contains(s.T(), string(result.IAMPolicy), "- allUsers\n")
+		require.Nil(s.T(), result.DomainMappings)
+	})
+
+	s.Run("rejects policy paths", func() {
+		withPolicy := options
+		withPolicy.PolicyPath = "policy.yaml"
+		_, err := RenderToResult(context.Background(), withPolicy, []byte("runConfig: {}\n"))
+		require.Error(s.T(), err)
+	})
+}
@@ -0,0 +1,164 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// imageOverridePlaceholder mirrors IMAGE_OVERRIDE_PLACEHOLDER in
+// cloudrun/common.bzl. It is rendered when a rule has no image configured and
+// must be replaced with --image at deploy time.
+const imageOverridePlaceholder = "rules-cloudrun.invalid/override-required:latest"
+
+var (
+	imageDomainPattern    = regexp.MustCompile(`^(?:localhost|[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*)(?::[0-9]+)?$`)
+	imageComponentPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	imageTagPattern       = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	sha256DigestPattern   = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+	imageDigestPattern    = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+)
+
+// imageReference is a parsed container image reference of the form
+// [registry/]repository[:tag][@digest].
+type imageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageReference parses and validates an image reference using the
+// OCI distribution grammar. sha256 digests must carry 64 lowercase hex
+// characters.
+func parseImageReference(ref string) (imageReference, error) {
+	if ref == "" {
+		return imageReference{}, errors.New("image reference is empty")
+	}
+	if strings.TrimSpace(ref) != ref {
+		return imageReference{}, fmt.Errorf("image reference %q must not contain surrounding whitespace", ref)
+	}
+
+	var parsed imageReference
+	name := ref
+	if at := strings.Index(name, "@"); at >= 0 {
+		parsed.Digest = name[at+1:]
+		name = name[:at]
+		if err := validateImageDigest(parsed.Digest); err != nil {
+			return imageReference{}, fmt.Errorf("image reference %q: %w", ref, err)
+		}
+	}
+
+	lastSlash := strings.LastIndex(name, "/")
+	if colon := strings.LastIndex(name, ":"); colon > lastSlash {
+		parsed.Tag = name[colon+1:]
+		name = name[:colon]
+		if !imageTagPattern.MatchString(parsed.Tag) {
+			return imageReference{}, fmt.Errorf("image reference %q has invalid tag %q", ref, parsed.Tag)
+		}
+	}
+
+	components := strings.Split(name, "/")
+	if len(components) > 1 && isImageRegistry(components[0]) {
+		parsed.Registry = components[0]
+		components = components[1:]
+		if !imageDomainPattern.MatchString(parsed.Registry) {
+			return imageReference{}, fmt.Errorf("image reference %q has invalid registry %q", ref, parsed.Registry)
+		}
+	}
+	for _, component := range components {
+		if !imageComponentPattern.MatchString(component) {
+			return imageReference{}, fmt.Errorf("image reference %q has invalid repository component %q", ref, component)
+		}
+	}
+	parsed.Repository = strings.Join(components, "/")
+	return parsed, nil
+}
+
+// String reassembles the reference in canonical form.
+func (r imageReference) String() string {
+	var builder strings.Builder
+	if r.Registry != "" {
+		builder.WriteString(r.Registry)
+		builder.WriteByte('/')
+	}
+	builder.WriteString(r.Repository)
+	if r.Tag != "" {
+		builder.WriteByte(':')
+		builder.WriteString(r.Tag)
+	}
+	if r.Digest != "" {
+		builder.WriteByte('@')
+		builder.WriteString(r.Digest)
+	}
+	return builder.String()
+}
+
+// isPlaceholderImage reports whether ref is the deploy-time override
+// placeholder rendered by rules without an image.
+func isPlaceholderImage(ref string) bool {
+	return ref == imageOverridePlaceholder
+}
+
+func isImageRegistry(component string) bool {
+	return component == "localhost" || strings.ContainsAny(component, ".:")
+}
+
+func validateImageDigest(digest string) error {
+	if strings.HasPrefix(digest, "sha256:") {
+		if !sha256DigestPattern.MatchString(digest) {
+			return fmt.Errorf("digest %q must be sha256: followed by 64 lowercase hex characters", digest)
+		}
+		return nil
+	}
+	if !imageDigestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	return nil
+}
+
+// validateImage parses options.Image and enforces the digest requirement.
+func validateImage(options RenderOptions) error {
+	if options.RequireDigest && isPlaceholderImage(options.Image) {
+		return fmt.Errorf("image is the deploy-time placeholder %q; configure image or image_repo, or override it with --image", imageOverridePlaceholder)
+	}
+	ref, err := parseImageReference(options.Image)
+	if err != nil {
+		return err
+	}
+	if options.RequireDigest && ref.Digest == "" {
+		return fmt.Errorf("image %q must be pinned by digest (repo@sha256:...)", options.Image)
+	}
+	return nil
+}
+
+// imageWarnings returns non-fatal findings about options.Image.
+func imageWarnings(options RenderOptions) []Warning {
+	if isPlaceholderImage(options.Image) || !isProductionEnvironment(options.Environment) {
+		return nil
+	}
+	ref, err := parseImageReference(options.Image)
+	if err != nil || ref.Digest != "" {
+		return nil
+	}
+	if ref.Tag == "" || ref.Tag == "latest" {
+		return []Warning{{
+			Code: WarningMutableImageTag,
+			Path: "image",
+			Message: fmt.Sprintf(
+				"image %q resolves to the mutable :latest tag in production environment %q; pin it by digest",
+				options.Image, options.Environment,
+			),
+		}}
+	}
+	return nil
+}
+
+func isProductionEnvironment(environment string) bool {
+	switch strings.ToLower(environment) {
+	case "prd", "prod", "production":
+		return true
+	}
+	return false
+}
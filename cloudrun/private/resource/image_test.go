@@ -0,0 +1,120 @@
+package resource
+
+import (
+	"bytes"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:46e099f6d3eab8fc3246ad867aace15a5503a4cf6c7c54f2ffac5c28ea1facad"
+
+func (s *rendererSuite) TestParseImageReference() {
+	s.Run("parses registry, repository, tag and digest", func() {
+		ref, err := parseImageReference("us-central1-docker.pkg.dev/proj/repo/app:v1@" + testDigest)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "us-central1-docker.pkg.dev", ref.Registry)
+		require.Equal(s.T(), "proj/repo/app", ref.Repository)
+		require.Equal(s.T(), "v1", ref.Tag)
+		require.Equal(s.T(), testDigest, ref.Digest)
+		require.Equal(s.T(), "us-central1-docker.pkg.dev/proj/repo/app:v1@"+testDigest, ref.String())
+	})
+
+	s.Run("parses registry with port and no tag", func() {
+		ref, err := parseImageReference("localhost:5000/app")
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "localhost:5000", ref.Registry)
+		require.Equal(s.T(), "app", ref.Repository)
+		require.Empty(s.T(), ref.Tag)
+	})
+
+	s.Run("parses implicit registry", func() {
+		ref, err := parseImageReference("library/nginx:1.27")
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), ref.Registry)
+		require.Equal(s.T(), "library/nginx", ref.Repository)
+		require.Equal(s.T(), "1.27", ref.Tag)
+	})
+
+	s.Run("parses the override placeholder", func() {
+		ref, err := parseImageReference(imageOverridePlaceholder)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "rules-cloudrun.invalid", ref.Registry)
+		require.Equal(s.T(), "latest", ref.Tag)
+	})
+
+	s.Run("rejects malformed references", func() {
+		for _, ref := range []string{
+			"",
+			"gcr.io/proj/App",
+			"gcr.io/proj/app:",
+			"gcr.io/proj/app@sha256:abc",
+			"gcr.io/proj/app@" + testDigest + "0",
+			"gcr.io//app",
+			" gcr.io/proj/app",
+			"gcr.io/proj/app:bad/tag",
+		} {
+			_, err := parseImageReference(ref)
+			require.Error(s.T(), err, "expected %q to be rejected", ref)
+		}
+	})
+}
+
+func (s *rendererSuite) TestValidateImage() {
+	s.Run("requires digest when requested", func() {
+		err := validateImage(RenderOptions{Image: "gcr.io/proj/app:v1", RequireDigest: true})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "pinned by digest")
+
+		require.NoError(s.T(), validateImage(RenderOptions{Image: "gcr.io/proj/app@" + testDigest, RequireDigest: true}))
+	})
+
+	s.Run("rejects placeholder when digest is required", func() {
+		err := validateImage(RenderOptions{Image: imageOverridePlaceholder, RequireDigest: true})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "placeholder")
+	})
+
+	s.Run("allows placeholder otherwise", func() {
+		require.NoError(s.T(), validateImage(RenderOptions{Image: imageOverridePlaceholder}))
+	})
+}
+
+func (s *rendererSuite) TestImageWarnings() {
+	s.Run("warns on latest in production", func() {
+		warnings := imageWarnings(RenderOptions{Image: "gcr.io/proj/app:latest", Environment: "prd"})
+		require.Len(s.T(), warnings, 1)
+		require.Equal(s.T(), WarningMutableImageTag, warnings[0].Code)
+		require.Contains(s.T(), warnings[0].Message, ":latest")
+
+		require.Len(s.T(), imageWarnings(RenderOptions{Image: "gcr.io/proj/app", Environment: "production"}), 1)
+	})
+
+	s.Run("does not warn outside production or when pinned", func() {
+		require.Empty(s.T(), imageWarnings(RenderOptions{Image: "gcr.io/proj/app:latest", Environment: "dev"}))
+		require.Empty(s.T(), imageWarnings(RenderOptions{Image: "gcr.io/proj/app:v1", Environment: "prd"}))
+		require.Empty(s.T(), imageWarnings(RenderOptions{Image: "gcr.io/proj/app:latest@" + testDigest, Environment: "prd"}))
+		require.Empty(s.T(), imageWarnings(RenderOptions{Image: imageOverridePlaceholder, Environment: "prd"}))
+	})
+
+	s.Run("renderer writes warnings", func() {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte("runConfig: {}\n")},
+			writeFiles: map[string][]byte{},
+		}
+		var warnings bytes.Buffer
+		renderer := NewRenderer(fileIO)
+		renderer.warnings = &warnings
+
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "gcr.io/proj/app:latest",
+			ResourceType: "service",
+			OutputPath:   "manifest.yaml",
+			Environment:  "prd",
+		})
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), warnings.String(), "WARNING: image \"gcr.io/proj/app:latest\"")
+	})
+}
@@ -0,0 +1,167 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+)
+
+// Terraform resource types of the global external load balancer.
+const (
+	terraformNEGType             = "google_compute_region_network_endpoint_group"
+	terraformBackendServiceType  = "google_compute_backend_service"
+	terraformURLMapType          = "google_compute_url_map"
+	terraformCertificateType     = "google_compute_managed_ssl_certificate"
+	terraformHTTPSProxyType      = "google_compute_target_https_proxy"
+	terraformHTTPProxyType       = "google_compute_target_http_proxy"
+	terraformGlobalAddressType   = "google_compute_global_address"
+	terraformForwardingRuleType  = "google_compute_global_forwarding_rule"
+	loadBalancingSchemeManaged   = "EXTERNAL_MANAGED"
+	maxComputeResourceNameLength = 63
+)
+
+// renderLoadBalancer renders a Terraform JSON document with a global
+// external Application Load Balancer in front of the service: one serverless
+// NEG per region, a backend service, a URL map, a global address, and an
+// HTTPS (with a managed certificate) or HTTP frontend.
+func renderLoadBalancer(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	if options.ResourceType != "" && options.ResourceType != resourceTypeService {
+		return nil, fmt.Errorf("a load balancer can only front services, not %ss", options.ResourceType)
+	}
+	var domains []string
+	if config.LoadBalancer != nil {
+		domains = config.LoadBalancer.Domains
+	}
+	if err := manifest.ValidateDomains(domains); err != nil {
+		return nil, fmt.Errorf("loadBalancer: %w", err)
+	}
+
+	regions := options.Regions
+	if len(regions) == 0 {
+		regions = []string{options.Region}
+	}
+	service := options.ServiceName
+	label := terraformResourceName(service)
+
+	name := func(suffix string) (string, error) {
+		resourceName := service + "-" + suffix
+		if len(resourceName) > maxComputeResourceNameLength {
+			return "", fmt.Errorf("load balancer resource name %q is longer than %d characters", resourceName, maxComputeResourceNameLength)
+		}
+		return resourceName, nil
+	}
+	reference := func(resourceType, resourceLabel string) string {
+		return fmt.Sprintf("${%s.%s.id}", resourceType, resourceLabel)
+	}
+
+	resources := map[string]map[string]interface{}{}
+	add := func(resourceType, resourceLabel string, body map[string]interface{}) {
+		if resources[resourceType] == nil {
+			resources[resourceType] = map[string]interface{}{}
+		}
+		resources[resourceType][resourceLabel] = body
+	}
+
+	backends := make([]interface{}, 0, len(regions))
+	for _, region := range regions {
+		negName, err := name("neg-" + region)
+		if err != nil {
+			return nil, err
+		}
+		negLabel := terraformResourceName(negName)
+		add(terraformNEGType, negLabel, map[string]interface{}{
+			"name":                  negName,
+			"region":                region,
+			"network_endpoint_type": "SERVERLESS",
+			"cloud_run": map[string]interface{}{
+				"service": service,
+			},
+		})
+		backends = append(backends, map[string]interface{}{
+			"group": reference(terraformNEGType, negLabel),
+		})
+	}
+
+	backendName, err := name("backend")
+	if err != nil {
+		return nil, err
+	}
+	add(terraformBackendServiceType, label, map[string]interface{}{
+		"name":                  backendName,
+		"load_balancing_scheme": loadBalancingSchemeManaged,
+		"backend":               backends,
+	})
+
+	urlMapName, err := name("url-map")
+	if err != nil {
+		return nil, err
+	}
+	add(terraformURLMapType, label, map[string]interface{}{
+		"name":            urlMapName,
+		"default_service": reference(terraformBackendServiceType, label),
+	})
+
+	addressName, err := name("ip")
+	if err != nil {
+		return nil, err
+	}
+	add(terraformGlobalAddressType, label, map[string]interface{}{
+		"name": addressName,
+	})
+
+	proxyName, err := name("proxy")
+	if err != nil {
+		return nil, err
+	}
+	forwardingRuleName, err := name("forwarding-rule")
+	if err != nil {
+		return nil, err
+	}
+	forwardingRule := map[string]interface{}{
+		"name":                  forwardingRuleName,
+		"load_balancing_scheme": loadBalancingSchemeManaged,
+		"ip_address":            reference(terraformGlobalAddressType, label),
+	}
+	if len(domains) > 0 {
+		certificateName, err := name("cert")
+		if err != nil {
+			return nil, err
+		}
+		add(terraformCertificateType, label, map[string]interface{}{
+			"name": certificateName,
+			"managed": map[string]interface{}{
+				"domains": domains,
+			},
+		})
+		add(terraformHTTPSProxyType, label, map[string]interface{}{
+			"name":             proxyName,
+			"url_map":          reference(terraformURLMapType, label),
+			"ssl_certificates": []string{reference(terraformCertificateType, label)},
+		})
+		forwardingRule["target"] = reference(terraformHTTPSProxyType, label)
+		forwardingRule["port_range"] = "443"
+	} else {
+		add(terraformHTTPProxyType, label, map[string]interface{}{
+			"name":    proxyName,
+			"url_map": reference(terraformURLMapType, label),
+		})
+		forwardingRule["target"] = reference(terraformHTTPProxyType, label)
+		forwardingRule["port_range"] = "80"
+	}
+	add(terraformForwardingRuleType, label, forwardingRule)
+
+	document := map[string]interface{}{
+		"resource": resources,
+		"output": map[string]interface{}{
+			label + "_ip_address": map[string]interface{}{
+				"value": fmt.Sprintf("${%s.%s.address}", terraformGlobalAddressType, label),
+			},
+		},
+	}
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal load balancer: %w", err)
+	}
+	return append(content, '\n'), nil
+}
@@ -0,0 +1,92 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *rendererSuite) TestRenderLoadBalancer() {
+	render := func(config string, options RenderOptions) (*fakeFileIO, error) {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte(config)},
+			writeFiles: map[string][]byte{},
+		}
+		options.ConfigPath = "config.yaml"
+		if options.ServiceName == "" {
+			options.ServiceName = "my-app"
+		}
+		options.Region = "us-central1"
+		options.Image = "example.com/app@" + testDigest
+		options.OutputPath = "manifest.yaml"
+		options.LoadBalancerPath = "lb.tf.json"
+		return fileIO, NewRenderer(fileIO).RenderManifest(options)
+	}
+	decode := func(content []byte) map[string]map[string]map[string]interface{} {
+		var document struct {
+			Resource map[string]map[string]map[string]interface{} `json:"resource"`
+		}
+		require.NoError(s.T(), json.Unmarshal(content, &document))
+		return document.Resource
+	}
+
+	s.Run("matches golden for a multi-region HTTPS load balancer", func() {
+		fileIO, err := render("loadBalancer:\n  domains:\n    - api.example.com\n", RenderOptions{
+			Regions: []string{"us-central1", "europe-west1"},
+		})
+		require.NoError(s.T(), err)
+
+		actual := fileIO.writeFiles["lb.tf.json"]
+		goldenPath := filepath.Join("testdata", "terraform", "loadbalancer.tf.json")
+		if *updateGolden {
+			require.NoError(s.T(), os.WriteFile(goldenPath, actual, 0o644))
+		}
+		expected, err := os.ReadFile(goldenPath)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), string(expected), string(actual))
+	})
+
+	s.Run("serves HTTP without domains", func() {
+		fileIO, err := render("runConfig: {}\n", RenderOptions{})
+		require.NoError(s.T(), err)
+
+		resources := decode(fileIO.writeFiles["lb.tf.json"])
+		require.Len(s.T(), resources[terraformNEGType], 1)
+		require.Contains(s.T(), resources[terraformNEGType], "my_app_neg_us_central1")
+		require.NotContains(s.T(), resources, terraformCertificateType)
+		require.NotContains(s.T(), resources, terraformHTTPSProxyType)
+		require.Equal(s.T(), "80", resources[terraformForwardingRuleType]["my_app"]["port_range"])
+	})
+
+	s.Run("is not written without a path or loadBalancer block", func() {
+		result, err := renderConfig(context.Background(), nil, RenderOptions{
+			ServiceName: "my-app",
+			Region:      "us-central1",
+			Image:       "example.com/app@" + testDigest,
+		}, []byte("runConfig: {}\n"), nil)
+		require.NoError(s.T(), err)
+		require.Nil(s.T(), result.LoadBalancer)
+	})
+
+	s.Run("rejects jobs", func() {
+		_, err := render("loadBalancer: {}\n", RenderOptions{ResourceType: "job"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "only front services")
+	})
+
+	s.Run("rejects invalid domains", func() {
+		_, err := render("loadBalancer:\n  domains:\n    - app.run.app\n", RenderOptions{})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "loadBalancer: domains[0]")
+	})
+
+	s.Run("rejects names longer than 63 characters", func() {
+		_, err := render("runConfig: {}\n", RenderOptions{ServiceName: "a" + strings.Repeat("-b", 25)})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "longer than 63 characters")
+	})
+}
@@ -0,0 +1,206 @@
+package resource
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ImagePatch overrides the image of one container in a rendered manifest.
+type ImagePatch struct {
+	// Container is the name of the container to patch; empty targets the
+	// main container.
+	Container string
+	// Image is the new image, pinned by sha256 digest.
+	Image string
+}
+
+func (p ImagePatch) target() string {
+	if p.Container == "" {
+		return "the main container"
+	}
+	return fmt.Sprintf("container %q", p.Container)
+}
+
+// ParseImagePatch parses a [<container>=]<image> override. Image references
+// cannot contain '=', so everything before the first one names the
+// container.
+func ParseImagePatch(value string) (ImagePatch, error) {
+	var patch ImagePatch
+	patch.Image = value
+	if container, image, found := strings.Cut(value, "="); found {
+		if container == "" {
+			return ImagePatch{}, fmt.Errorf("image override %q has an empty container name", value)
+		}
+		patch.Container, patch.Image = container, image
+	}
+	if err := patch.validate(); err != nil {
+		return ImagePatch{}, err
+	}
+	return patch, nil
+}
+
+func (p ImagePatch) validate() error {
+	ref, err := parseImageReference(p.Image)
+	if err != nil {
+		return fmt.Errorf("image override for %s: %w", p.target(), err)
+	}
+	if !strings.HasPrefix(ref.Digest, "sha256:") {
+		return fmt.Errorf("image override for %s must be a digest reference (repo@sha256:<64 hex chars>), got %q", p.target(), p.Image)
+	}
+	return nil
+}
+
+// PatchOptions configures an image patch of an already rendered manifest.
+type PatchOptions struct {
+	ManifestPath string
+	// OutputPath receives the patched manifest; it may equal ManifestPath.
+	OutputPath string
+	Images     []ImagePatch
+}
+
+// Patch applies options.Images to a rendered Knative manifest. JSON
+// manifests stay JSON; YAML manifests stay YAML.
+func (r *Renderer) Patch(options PatchOptions) error {
+	if options.ManifestPath == "" {
+		return errors.New("manifest path is required")
+	}
+	if options.OutputPath == "" {
+		return errors.New("output path is required")
+	}
+	if len(options.Images) == 0 {
+		return errors.New("at least one image override is required")
+	}
+	manifestContent, err := r.fileIO.ReadFile(options.ManifestPath)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	patched, err := PatchImages(manifestContent, options.Images)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(manifestContent), []byte("{")) {
+		if patched, err = knativeYAMLToJSON(patched); err != nil {
+			return err
+		}
+	}
+	if err := r.ensureOutputDirectory(options.OutputPath); err != nil {
+		return err
+	}
+	if err := r.fileIO.WriteFile(options.OutputPath, patched, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return nil
+}
+
+// PatchImages sets container images in a rendered manifest, leaving every
+// other field untouched. The main container is the one that declares ports,
+// or the first container when none does; other containers are addressed by
+// name. Each container may be patched once.
+func PatchImages(manifestContent []byte, patches []ImagePatch) ([]byte, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(manifestContent, &raw); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	containers := findContainers(raw)
+	if len(containers) == 0 {
+		return nil, errors.New("manifest has no containers")
+	}
+
+	patched := make(map[int]bool, len(patches))
+	for _, patch := range patches {
+		if err := patch.validate(); err != nil {
+			return nil, err
+		}
+		index, err := selectContainer(containers, patch.Container)
+		if err != nil {
+			return nil, err
+		}
+		if patched[index] {
+			return nil, fmt.Errorf("the image of %s is overridden more than once", patch.target())
+		}
+		patched[index] = true
+		containers[index]["image"] = patch.Image
+	}
+	return yaml.Marshal(raw)
+}
+
+// ContainerImages returns the image of every container in a rendered
+// manifest, in the order findContainers visits them. Containers without an
+// image are skipped.
+func ContainerImages(manifestContent []byte) ([]string, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(manifestContent, &raw); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	var images []string
+	for _, container := range findContainers(raw) {
+		if image, ok := container["image"].(string); ok {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// findContainers returns the entries of every containers list in the
+// manifest. Keys are visited in sorted order so the result is stable.
+func findContainers(node interface{}) []map[string]interface{} {
+	var containers []map[string]interface{}
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if list, ok := v["containers"].([]interface{}); ok {
+			for _, item := range list {
+				if container, ok := item.(map[string]interface{}); ok {
+					containers = append(containers, container)
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			if key != "containers" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			containers = append(containers, findContainers(v[key])...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			containers = append(containers, findContainers(item)...)
+		}
+	}
+	return containers
+}
+
+// selectContainer returns the index of the container named name, or of
+// the main container when name is empty.
+func selectContainer(containers []map[string]interface{}, name string) (int, error) {
+	if name == "" {
+		for i, container := range containers {
+			if _, hasPorts := container["ports"]; hasPorts {
+				return i, nil
+			}
+		}
+		return 0, nil
+	}
+
+	names := make([]string, 0, len(containers))
+	for i, container := range containers {
+		containerName, _ := container["name"].(string)
+		if containerName == name {
+			return i, nil
+		}
+		if containerName != "" {
+			names = append(names, containerName)
+		}
+	}
+	if len(names) == 0 {
+		return 0, fmt.Errorf("manifest has no container named %q; its containers are unnamed", name)
+	}
+	return 0, fmt.Errorf("manifest has no container named %q (containers: %s)", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,142 @@
+package resource
+
+import (
+	"strings"
+
+	"github.com/stretchr/testify/require"
+)
+
+const patchTestManifest = `apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  name: myapp
+spec:
+  template:
+    spec:
+      containers:
+      - env:
+        - name: NOTE
+          value: 'image: example.com/untouched'
+        image: example.com/app@` + testDigest + `
+        name: app
+        ports:
+        - containerPort: 8080
+      - image: example.com/proxy@` + testDigest + `
+        name: proxy
+`
+
+func (s *rendererSuite) TestParseImagePatch() {
+	override := "example.com/other@sha256:" + strings.Repeat("f", 64)
+
+	s.Run("targets the main container without a name", func() {
+		patch, err := ParseImagePatch(override)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), ImagePatch{Image: override}, patch)
+	})
+
+	s.Run("parses a container name", func() {
+		patch, err := ParseImagePatch("proxy=" + override)
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), ImagePatch{Container: "proxy", Image: override}, patch)
+	})
+
+	s.Run("rejects tags and empty names", func() {
+		_, err := ParseImagePatch("example.com/other:latest")
+		require.ErrorContains(s.T(), err, "must be a digest reference")
+
+		_, err = ParseImagePatch("=" + override)
+		require.ErrorContains(s.T(), err, "empty container name")
+
+		_, err = ParseImagePatch("proxy=example.com/Other@" + testDigest)
+		require.ErrorContains(s.T(), err, `image override for container "proxy"`)
+	})
+}
+
+func (s *rendererSuite) TestPatchImages() {
+	override := "example.com/other@sha256:" + strings.Repeat("f", 64)
+
+	s.Run("patches the main and named containers", func() {
+		patched, err := PatchImages([]byte(patchTestManifest), []ImagePatch{
+			{Image: override},
+			{Container: "proxy", Image: "example.com/proxy@" + strings.Replace(testDigest, "4", "5", 1)},
+		})
+		require.NoError(s.T(), err)
+		expected := strings.Replace(patchTestManifest, "example.com/app@"+testDigest, override, 1)
+		expected = strings.Replace(expected, "example.com/proxy@"+testDigest, "example.com/proxy@"+strings.Replace(testDigest, "4", "5", 1), 1)
+		require.Equal(s.T(), expected, string(patched))
+	})
+
+	s.Run("falls back to the first container without ports", func() {
+		patched, err := PatchImages([]byte("spec:\n  containers:\n  - image: a\n  - image: b\n"), []ImagePatch{{Image: override}})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "spec:\n  containers:\n  - image: "+override+"\n  - image: b\n", string(patched))
+	})
+
+	s.Run("rejects overriding a container twice", func() {
+		_, err := PatchImages([]byte(patchTestManifest), []ImagePatch{{Image: override}, {Container: "app", Image: override}})
+		require.ErrorContains(s.T(), err, `the image of container "app" is overridden more than once`)
+	})
+
+	s.Run("rejects unknown containers", func() {
+		_, err := PatchImages([]byte(patchTestManifest), []ImagePatch{{Container: "cache", Image: override}})
+		require.ErrorContains(s.T(), err, `no container named "cache" (containers: app, proxy)`)
+
+		_, err = PatchImages([]byte("spec:\n  containers:\n  - image: a\n"), []ImagePatch{{Container: "cache", Image: override}})
+		require.ErrorContains(s.T(), err, "its containers are unnamed")
+
+		_, err = PatchImages([]byte("kind: Service\n"), []ImagePatch{{Image: override}})
+		require.ErrorContains(s.T(), err, "manifest has no containers")
+	})
+}
+
+func (s *rendererSuite) TestContainerImages() {
+	s.Run("lists every container image", func() {
+		images, err := ContainerImages([]byte(patchTestManifest))
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{"example.com/app@" + testDigest, "example.com/proxy@" + testDigest}, images)
+	})
+
+	s.Run("reads quoted, flow-style and JSON manifests", func() {
+		for _, manifest := range []string{
+			"spec:\n  containers:\n  - image: \"a\"\n  - {image: 'b'}\n",
+			`{"spec": {"containers": [{"image": "a"}, {"image": "b"}]}}`,
+		} {
+			images, err := ContainerImages([]byte(manifest))
+			require.NoError(s.T(), err)
+			require.Equal(s.T(), []string{"a", "b"}, images)
+		}
+	})
+
+	s.Run("ignores images outside containers", func() {
+		images, err := ContainerImages([]byte("spec:\n  containers:\n  - image: a\n    env:\n    - name: NOTE\n      value: 'image: b'\n"))
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []string{"a"}, images)
+	})
+
+	s.Run("rejects invalid manifests", func() {
+		_, err := ContainerImages([]byte("spec: ["))
+		require.ErrorContains(s.T(), err, "parse manifest")
+	})
+}
+
+func (s *rendererSuite) TestPatch() {
+	override := "example.com/other@sha256:" + strings.Repeat("f", 64)
+
+	s.Run("keeps JSON manifests as JSON", func() {
+		fileIO := NewMemoryFileIO(map[string][]byte{
+			"manifest.json": []byte(`{"spec": {"containers": [{"image": "a"}]}}`),
+		})
+		err := NewRenderer(fileIO).Patch(PatchOptions{
+			ManifestPath: "manifest.json",
+			OutputPath:   "out/manifest.json",
+			Images:       []ImagePatch{{Image: override}},
+		})
+		require.NoError(s.T(), err)
+		require.JSONEq(s.T(), `{"spec": {"containers": [{"image": "`+override+`"}]}}`, string(fileIO.Files()["out/manifest.json"]))
+	})
+
+	s.Run("requires an image override", func() {
+		err := NewRenderer(NewMemoryFileIO(nil)).Patch(PatchOptions{ManifestPath: "manifest.yaml", OutputPath: "manifest.yaml"})
+		require.ErrorContains(s.T(), err, "at least one image override is required")
+	})
+}
@@ -0,0 +1,462 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// Built-in policy names, usable from a policy rules file.
+const (
+	policyIngressNotAll          = "ingress-not-all"
+	policyMaxInstances           = "max-instances"
+	policySecretEnvNames         = "secret-env-names"
+	policyServiceAccountRequired = "service-account-required"
+	policyDigestPinnedImages     = "digest-pinned-images"
+
+	defaultPolicyMaxInstances = 100
+)
+
+var (
+	defaultProductionEnvironments = []string{"prd", "prod", "production"}
+	defaultSecretEnvPatterns      = []string{"*_KEY", "*_TOKEN", "*_PASSWORD"}
+)
+
+// PolicyInput is the data a Policy evaluates: a rendered Knative manifest
+// (service, job, or worker pool) decoded into generic maps, plus the
+// environment it is rendered for.
+type PolicyInput struct {
+	Environment string
+	Manifest    map[string]interface{}
+}
+
+// PolicyViolation describes a single guardrail failure.
+type PolicyViolation struct {
+	Policy  string
+	Message string
+}
+
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("[%s] %s", v.Policy, v.Message)
+}
+
+// Policy is an organization guardrail evaluated against a rendered manifest.
+type Policy interface {
+	Name() string
+	Evaluate(input PolicyInput) []PolicyViolation
+}
+
+// PolicyError is returned when one or more policies report violations.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	lines := make([]string, 0, len(e.Violations)+1)
+	lines = append(lines, fmt.Sprintf("policy check failed with %d violation(s):", len(e.Violations)))
+	for _, violation := range e.Violations {
+		lines = append(lines, "  - "+violation.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DefaultPolicies returns every built-in policy with its default settings.
+func DefaultPolicies() []Policy {
+	return []Policy{
+		ingressNotAllPolicy{environments: defaultProductionEnvironments},
+		maxInstancesPolicy{limit: defaultPolicyMaxInstances},
+		secretEnvNamesPolicy{patterns: defaultSecretEnvPatterns},
+		serviceAccountRequiredPolicy{},
+		digestPinnedImagesPolicy{},
+	}
+}
+
+// EvaluatePolicies runs every policy and returns a *PolicyError when any of
+// them reports a violation.
+func EvaluatePolicies(policies []Policy, input PolicyInput) error {
+	var violations []PolicyViolation
+	for _, policy := range policies {
+		violations = append(violations, policy.Evaluate(input)...)
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// ParseManifestForPolicy decodes a rendered Knative manifest (YAML or JSON).
+func ParseManifestForPolicy(data []byte) (map[string]interface{}, error) {
+	var manifest map[string]interface{}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if manifest == nil {
+		return nil, errors.New("parse manifest: document is empty")
+	}
+	return manifest, nil
+}
+
+// ── Rules file ──────────────────────────────────────────────────────────────
+
+// policyFile is the YAML rules file format:
+//
+//	builtins:
+//	  - name: max-instances
+//	    maxInstances: 50
+//	  - name: ingress-not-all
+//	    environments: [prd]
+//	rules:
+//	  - name: gen2-only
+//	    expression: manifest.kind != "Service" || manifest.spec.template.metadata.annotations["run.googleapis.com/execution-environment"] == "gen2"
+//	    message: services must run on the gen2 execution environment
+//
+// When builtins is omitted every built-in policy runs with its defaults; an
+// empty list disables them. CEL expressions see `manifest` (the decoded
+// manifest) and `environment` (string) and must evaluate to true to pass.
+type policyFile struct {
+	Builtins *[]builtinPolicyEntry `yaml:"builtins"`
+	Rules    []celRuleEntry        `yaml:"rules"`
+}
+
+type builtinPolicyEntry struct {
+	Name         string   `yaml:"name"`
+	Environments []string `yaml:"environments"`
+	MaxInstances *int     `yaml:"maxInstances"`
+	Patterns     []string `yaml:"patterns"`
+}
+
+type celRuleEntry struct {
+	Name         string   `yaml:"name"`
+	Expression   string   `yaml:"expression"`
+	Message      string   `yaml:"message"`
+	Environments []string `yaml:"environments"`
+}
+
+// LoadPolicies parses a policy rules file into the policies it enables.
+func LoadPolicies(data []byte) ([]Policy, error) {
+	var file policyFile
+	if err := yamlv3.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse policy yaml: %w", err)
+	}
+
+	var policies []Policy
+	if file.Builtins == nil {
+		policies = DefaultPolicies()
+	} else {
+		for i, entry := range *file.Builtins {
+			policy, err := newBuiltinPolicy(entry)
+			if err != nil {
+				return nil, fmt.Errorf("builtins[%d]: %w", i, err)
+			}
+			policies = append(policies, policy)
+		}
+	}
+
+	for i, entry := range file.Rules {
+		policy, err := newCELPolicy(entry)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func newBuiltinPolicy(entry builtinPolicyEntry) (Policy, error) {
+	switch entry.Name {
+	case policyIngressNotAll:
+		environments := entry.Environments
+		if len(environments) == 0 {
+			environments = defaultProductionEnvironments
+		}
+		return ingressNotAllPolicy{environments: environments}, nil
+	case policyMaxInstances:
+		limit := defaultPolicyMaxInstances
+		if entry.MaxInstances != nil {
+			limit = *entry.MaxInstances
+		}
+		if limit < 1 {
+			return nil, fmt.Errorf("%s: maxInstances must be at least 1, got %d", entry.Name, limit)
+		}
+		return maxInstancesPolicy{limit: limit}, nil
+	case policySecretEnvNames:
+		patterns := entry.Patterns
+		if len(patterns) == 0 {
+			patterns = defaultSecretEnvPatterns
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern %q: %w", entry.Name, pattern, err)
+			}
+		}
+		return secretEnvNamesPolicy{patterns: patterns}, nil
+	case policyServiceAccountRequired:
+		return serviceAccountRequiredPolicy{}, nil
+	case policyDigestPinnedImages:
+		return digestPinnedImagesPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown built-in policy %q", entry.Name)
+	}
+}
+
+// ── Built-in policies ───────────────────────────────────────────────────────
+
+type ingressNotAllPolicy struct {
+	environments []string
+}
+
+func (ingressNotAllPolicy) Name() string { return policyIngressNotAll }
+
+func (p ingressNotAllPolicy) Evaluate(input PolicyInput) []PolicyViolation {
+	view := newManifestView(input.Manifest)
+	if view.kind != "Service" || !containsFold(p.environments, input.Environment) {
+		return nil
+	}
+	ingress := view.annotations["run.googleapis.com/ingress"]
+	if ingress != "all" {
+		return nil
+	}
+	return []PolicyViolation{{
+		Policy:  p.Name(),
+		Message: fmt.Sprintf("ingress %q is not allowed in environment %q; use internal or internal-and-cloud-load-balancing", ingress, input.Environment),
+	}}
+}
+
+type maxInstancesPolicy struct {
+	limit int
+}
+
+func (maxInstancesPolicy) Name() string { return policyMaxInstances }
+
+func (p maxInstancesPolicy) Evaluate(input PolicyInput) []PolicyViolation {
+	view := newManifestView(input.Manifest)
+	var violations []PolicyViolation
+	for _, candidate := range []struct {
+		annotations map[string]string
+		key         string
+	}{
+		{view.annotations, "run.googleapis.com/maxScale"},
+		{view.templateAnnotations, "autoscaling.knative.dev/maxScale"},
+	} {
+		raw, found := candidate.annotations[candidate.key]
+		if !found {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			violations = append(violations, PolicyViolation{
+				Policy:  p.Name(),
+				Message: fmt.Sprintf("annotation %s=%q is not an integer", candidate.key, raw),
+			})
+			continue
+		}
+		if value > p.limit {
+			violations = append(violations, PolicyViolation{
+				Policy:  p.Name(),
+				Message: fmt.Sprintf("max instances %d exceeds the limit of %d", value, p.limit),
+			})
+		}
+	}
+	return violations
+}
+
+type secretEnvNamesPolicy struct {
+	patterns []string
+}
+
+func (secretEnvNamesPolicy) Name() string { return policySecretEnvNames }
+
+func (p secretEnvNamesPolicy) Evaluate(input PolicyInput) []PolicyViolation {
+	var violations []PolicyViolation
+	for _, container := range newManifestView(input.Manifest).containers {
+		for _, env := range sliceOf(container["env"]) {
+			entry := mapOf(env)
+			name, _ := entry["name"].(string)
+			if _, fromSecret := entry["valueFrom"]; fromSecret {
+				continue
+			}
+			for _, pattern := range p.patterns {
+				if matched, _ := path.Match(pattern, name); matched {
+					violations = append(violations, PolicyViolation{
+						Policy:  p.Name(),
+						Message: fmt.Sprintf("env %q matches %q and must use a secret instead of a plaintext value", name, pattern),
+					})
+					break
+				}
+			}
+		}
+	}
+	return violations
+}
+
+type serviceAccountRequiredPolicy struct{}
+
+func (serviceAccountRequiredPolicy) Name() string { return policyServiceAccountRequired }
+
+func (p serviceAccountRequiredPolicy) Evaluate(input PolicyInput) []PolicyViolation {
+	if newManifestView(input.Manifest).serviceAccount != "" {
+		return nil
+	}
+	return []PolicyViolation{{
+		Policy:  p.Name(),
+		Message: "serviceAccount must be set",
+	}}
+}
+
+type digestPinnedImagesPolicy struct{}
+
+func (digestPinnedImagesPolicy) Name() string { return policyDigestPinnedImages }
+
+func (p digestPinnedImagesPolicy) Evaluate(input PolicyInput) []PolicyViolation {
+	var violations []PolicyViolation
+	for _, container := range newManifestView(input.Manifest).containers {
+		image, _ := container["image"].(string)
+		ref, err := parseImageReference(image)
+		if err == nil && ref.Digest != "" {
+			continue
+		}
+		violations = append(violations, PolicyViolation{
+			Policy:  p.Name(),
+			Message: fmt.Sprintf("image %q must be pinned by digest", image),
+		})
+	}
+	return violations
+}
+
+// ── CEL rules ───────────────────────────────────────────────────────────────
+
+type celPolicy struct {
+	name         string
+	message      string
+	environments []string
+	program      cel.Program
+}
+
+func newCELPolicy(entry celRuleEntry) (Policy, error) {
+	if entry.Name == "" {
+		return nil, errors.New("rule name is required")
+	}
+	if entry.Expression == "" {
+		return nil, fmt.Errorf("rule %q: expression is required", entry.Name)
+	}
+	env, err := cel.NewEnv(
+		cel.Variable("manifest", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("environment", cel.StringType),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: create CEL environment: %w", entry.Name, err)
+	}
+	ast, issues := env.Compile(entry.Expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("rule %q: compile expression: %w", entry.Name, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("rule %q: expression must evaluate to bool, got %s", entry.Name, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: build program: %w", entry.Name, err)
+	}
+	message := entry.Message
+	if message == "" {
+		message = "expression evaluated to false: " + entry.Expression
+	}
+	return celPolicy{
+		name:         entry.Name,
+		message:      message,
+		environments: entry.Environments,
+		program:      program,
+	}, nil
+}
+
+func (p celPolicy) Name() string { return p.name }
+
+func (p celPolicy) Evaluate(input PolicyInput) []PolicyViolation {
+	if len(p.environments) > 0 && !containsFold(p.environments, input.Environment) {
+		return nil
+	}
+	out, _, err := p.program.Eval(map[string]interface{}{
+		"manifest":    input.Manifest,
+		"environment": input.Environment,
+	})
+	if err != nil {
+		return []PolicyViolation{{Policy: p.name, Message: fmt.Sprintf("evaluate expression: %v", err)}}
+	}
+	if out == types.True {
+		return nil
+	}
+	if _, isBool := out.(types.Bool); !isBool {
+		return []PolicyViolation{{Policy: p.name, Message: fmt.Sprintf("expression returned %s, want bool", out.Type())}}
+	}
+	return []PolicyViolation{{Policy: p.name, Message: p.message}}
+}
+
+// ── Manifest access ─────────────────────────────────────────────────────────
+
+// manifestView locates the fields policies care about in any of the three
+// Knative manifest shapes rendered by this package.
+type manifestView struct {
+	kind                string
+	annotations         map[string]string
+	templateAnnotations map[string]string
+	serviceAccount      string
+	containers          []map[string]interface{}
+}
+
+func newManifestView(manifest map[string]interface{}) manifestView {
+	view := manifestView{}
+	view.kind, _ = manifest["kind"].(string)
+	view.annotations = stringMapOf(mapOf(manifest["metadata"])["annotations"])
+
+	template := mapOf(mapOf(manifest["spec"])["template"])
+	view.templateAnnotations = stringMapOf(mapOf(template["metadata"])["annotations"])
+	podSpec := mapOf(template["spec"])
+	if view.kind == "Job" {
+		podSpec = mapOf(mapOf(podSpec["template"])["spec"])
+	}
+	view.serviceAccount, _ = podSpec["serviceAccountName"].(string)
+	for _, container := range sliceOf(podSpec["containers"]) {
+		view.containers = append(view.containers, mapOf(container))
+	}
+	return view
+}
+
+func mapOf(node interface{}) map[string]interface{} {
+	if m, ok := node.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
+
+func sliceOf(node interface{}) []interface{} {
+	if s, ok := node.([]interface{}); ok {
+		return s
+	}
+	return nil
+}
+
+func stringMapOf(node interface{}) map[string]string {
+	result := map[string]string{}
+	for key, value := range mapOf(node) {
+		if s, ok := value.(string); ok {
+			result[key] = s
+		}
+	}
+	return result
+}
+
+func containsFold(values []string, target string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package resource
+
+import (
+	"errors"
+
+	"github.com/stretchr/testify/require"
+)
+
+const policyTestManifest = `
+apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  name: myapp
+  annotations:
+    run.googleapis.com/ingress: all
+    run.googleapis.com/maxScale: "200"
+spec:
+  template:
+    metadata:
+      annotations:
+        autoscaling.knative.dev/maxScale: "10"
+    spec:
+      containers:
+        - image: example.com/myapp:v1
+          env:
+            - name: API_KEY
+              value: plaintext
+            - name: DB_PASSWORD
+              valueFrom:
+                secretKeyRef:
+                  name: DB_PASSWORD
+                  key: latest
+`
+
+func (s *rendererSuite) policyViolations(policies []Policy, environment, manifestYAML string) []PolicyViolation {
+	manifest, err := ParseManifestForPolicy([]byte(manifestYAML))
+	require.NoError(s.T(), err)
+
+	err = EvaluatePolicies(policies, PolicyInput{Environment: environment, Manifest: manifest})
+	if err == nil {
+		return nil
+	}
+	var policyErr *PolicyError
+	require.True(s.T(), errors.As(err, &policyErr))
+	return policyErr.Violations
+}
+
+func violatedPolicies(violations []PolicyViolation) []string {
+	names := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		names = append(names, violation.Policy)
+	}
+	return names
+}
+
+func (s *rendererSuite) TestBuiltinPolicies() {
+	s.Run("reports every default violation in production", func() {
+		violations := s.policyViolations(DefaultPolicies(), "prd", policyTestManifest)
+		require.ElementsMatch(s.T(), []string{
+			"ingress-not-all",
+			"max-instances",
+			"secret-env-names",
+			"service-account-required",
+			"digest-pinned-images",
+		}, violatedPolicies(violations))
+	})
+
+	s.Run("allows ingress all outside production", func() {
+		violations := s.policyViolations(DefaultPolicies(), "dev", policyTestManifest)
+		require.NotContains(s.T(), violatedPolicies(violations), "ingress-not-all")
+	})
+
+	s.Run("passes a compliant job manifest", func() {
+		violations := s.policyViolations(DefaultPolicies(), "prd", `
+apiVersion: run.googleapis.com/v1
+kind: Job
+metadata:
+  name: myjob
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          serviceAccountName: job@project.iam.gserviceaccount.com
+          containers:
+            - image: example.com/myjob@`+testDigest+`
+`)
+		require.Empty(s.T(), violations)
+	})
+}
+
+func (s *rendererSuite) TestLoadPolicies() {
+	s.Run("configures built-ins and CEL rules", func() {
+		policies, err := LoadPolicies([]byte(`
+builtins:
+  - name: max-instances
+    maxInstances: 5
+rules:
+  - name: gen2-only
+    expression: >-
+      "run.googleapis.com/execution-environment" in manifest.spec.template.metadata.annotations
+    message: services must run on gen2
+  - name: prod-only
+    expression: "false"
+    environments: [prd]
+`))
+		require.NoError(s.T(), err)
+		require.Len(s.T(), policies, 3)
+
+		violations := s.policyViolations(policies, "dev", policyTestManifest)
+		require.Equal(s.T(), []string{"max-instances", "max-instances", "gen2-only"}, violatedPolicies(violations))
+		require.Equal(s.T(), "services must run on gen2", violations[2].Message)
+	})
+
+	s.Run("runs defaults when builtins is omitted", func() {
+		policies, err := LoadPolicies([]byte("rules: []\n"))
+		require.NoError(s.T(), err)
+		require.Len(s.T(), policies, len(DefaultPolicies()))
+	})
+
+	s.Run("rejects invalid rules", func() {
+		for _, content := range []string{
+			"builtins:\n  - name: no-such-policy\n",
+			"builtins:\n  - name: max-instances\n    maxInstances: 0\n",
+			"rules:\n  - name: broken\n    expression: manifest.kind ==\n",
+			"rules:\n  - name: not-bool\n    expression: environment\n",
+			"rules:\n  - expression: \"true\"\n",
+		} {
+			_, err := LoadPolicies([]byte(content))
+			require.Error(s.T(), err, "expected %q to be rejected", content)
+		}
+	})
+}
+
+func (s *rendererSuite) TestRenderManifestPolicy() {
+	s.Run("fails the render on violations", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte("runConfig:\n  ingress: all\n"),
+				"policy.yaml": []byte("builtins:\n  - name: ingress-not-all\n"),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+			Environment:  "prd",
+			PolicyPath:   "policy.yaml",
+		})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "[ingress-not-all]")
+		require.NotContains(s.T(), fileIO.writeFiles, "manifest.json")
+	})
+
+	s.Run("renders internal ingress", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte("runConfig:\n  ingress: internal-and-cloud-load-balancing\n"),
+				"policy.yaml": []byte("builtins:\n  - name: ingress-not-all\n"),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.yaml",
+			Environment:  "prd",
+			PolicyPath:   "policy.yaml",
+		})
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), string(fileIO.writeFiles["manifest.yaml"]),
+			"run.googleapis.com/ingress: internal-and-cloud-load-balancing")
+	})
+
+	s.Run("rejects unknown ingress", func() {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte("runConfig:\n  ingress: public\n")},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.yaml",
+		})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "runConfig.ingress")
+	})
+}
+
+func (s *rendererSuite) TestLint() {
+	s.Run("uses default policies without a rules file", func() {
+		renderer := NewRenderer(&fakeFileIO{
+			readFiles:  map[string][]byte{"manifest.yaml": []byte(policyTestManifest)},
+			writeFiles: map[string][]byte{},
+		})
+		err := renderer.Lint(LintOptions{ManifestPath: "manifest.yaml", Environment: "prd"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "5 violation(s)")
+	})
+
+	s.Run("requires a manifest", func() {
+		renderer := NewRenderer(&fakeFileIO{})
+		require.Error(s.T(), renderer.Lint(LintOptions{}))
+	})
+}
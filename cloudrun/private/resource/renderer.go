@@ -1,36 +1,42 @@
 package resource
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
-	yamlv3 "gopkg.in/yaml.v3"
-	corev1 "k8s.io/api/core/v1"
-	apiresource "k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
-	servingv1 "knative.dev/serving/pkg/apis/serving/v1"
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
 	"sigs.k8s.io/yaml"
 )
 
 const (
-	defaultCPU            = 1
-	defaultMemoryMiB      = 512
-	defaultTimeoutSeconds = 300
-	defaultTaskCount      = 1
+	defaultCPU            = manifest.DefaultCPU
+	defaultMemoryMiB      = manifest.DefaultMemoryMiB
+	defaultTimeoutSeconds = manifest.DefaultTimeoutSeconds
+	defaultTaskCount      = manifest.DefaultTaskCount
+	defaultIngress        = manifest.DefaultIngress
 	resourceTypeService   = "service"
 	resourceTypeJob       = "job"
 	resourceTypeWorker    = "worker"
+	formatKnativeYAML     = "knative-yaml"
+	formatKnativeJSON     = "knative-json"
+	formatV2JSON          = "v2-json"
+	formatTerraformJSON   = "terraform-json"
 )
 
 // FileIO abstracts file system operations for testability.
 type FileIO interface {
 	ReadFile(path string) ([]byte, error)
 	WriteFile(path string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
 }
 
 // OSFileIO is the production implementation of FileIO.
@@ -44,9 +50,14 @@ func (OSFileIO) WriteFile(path string, data []byte, perm os.FileMode) error {
 	return os.WriteFile(path, data, perm)
 }
 
+func (OSFileIO) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
 // Renderer generates Cloud Run manifests from apphosting configuration files.
 type Renderer struct {
-	fileIO FileIO
+	fileIO   FileIO
+	warnings io.Writer
 }
 
 // RenderOptions specifies the parameters for manifest generation.
@@ -58,781 +69,476 @@ type RenderOptions struct {
 	ResourceType   string
 	TimeoutSeconds int
 	OutputPath     string
-}
-
-type appHostingConfig struct {
-	RunConfig         runConfigEntry `yaml:"runConfig"`
-	Env               []envEntry     `yaml:"env"`
-	ServiceAccount    string         `yaml:"serviceAccount"`
-	CloudSQLConnector string         `yaml:"cloudsqlConnector"`
-}
-
-type runConfigEntry struct {
-	CPU            *int        `yaml:"cpu"`
-	MemoryMiB      *int        `yaml:"memoryMiB"`
-	MinInstances   *int        `yaml:"minInstances"`
-	MaxInstances   *int        `yaml:"maxInstances"`
-	Concurrency    *int        `yaml:"concurrency"`
-	Network        string      `yaml:"network"`
-	Subnet         string      `yaml:"subnet"`
-	VPCConnector   string      `yaml:"vpcConnector"`
-	VPCEgress      string      `yaml:"vpcEgress"`
-	TaskCount      *int        `yaml:"taskCount"`
-	Parallelism    *int        `yaml:"parallelism"`
-	MaxRetries     *int        `yaml:"maxRetries"`
-	TimeoutSeconds *int        `yaml:"timeoutSeconds"`
-	LivenessProbe  *probeEntry `yaml:"livenessProbe"`
-	ReadinessProbe *probeEntry `yaml:"readinessProbe"`
-	StartupProbe   *probeEntry `yaml:"startupProbe"`
-}
-
-type probeEntry struct {
-	InitialDelaySeconds *int32          `yaml:"initialDelaySeconds"`
-	TimeoutSeconds      *int32          `yaml:"timeoutSeconds"`
-	PeriodSeconds       *int32          `yaml:"periodSeconds"`
-	SuccessThreshold    *int32          `yaml:"successThreshold"`
-	FailureThreshold    *int32          `yaml:"failureThreshold"`
-	HTTPGet             *httpGetEntry   `yaml:"httpGet"`
-	TCPSocket           *tcpSocketEntry `yaml:"tcpSocket"`
-	GRPC                *grpcEntry      `yaml:"grpc"`
-}
-
-type httpGetEntry struct {
-	Path        string            `yaml:"path"`
-	Port        *int32            `yaml:"port"`
-	HTTPHeaders []httpHeaderEntry `yaml:"httpHeaders"`
-}
-
-type httpHeaderEntry struct {
-	Name  string `yaml:"name"`
-	Value string `yaml:"value"`
-}
-
-type tcpSocketEntry struct {
-	Port *int32 `yaml:"port"`
-}
-
-type grpcEntry struct {
-	Port    *int32 `yaml:"port"`
-	Service string `yaml:"service"`
-}
-
-type envEntry struct {
-	Variable string `yaml:"variable"`
-	Value    string `yaml:"value"`
-	Secret   string `yaml:"secret"`
-}
+	Format         string
+	Environment    string
+	RequireDigest  bool
+	PolicyPath     string
+	// ConfigDir is the directory envFrom paths are resolved against. It
+	// defaults to the directory of ConfigPath.
+	ConfigDir string
+	// Regions lists every region the resource is deployed to. Domain
+	// mappings are rejected when there is more than one.
+	Regions []string
+	// DomainMappingsPath, when set, receives the DomainMapping manifests for
+	// the config's domains as a multi-document YAML file, which is empty
+	// when no domains are configured.
+	DomainMappingsPath string
+	// ProjectNumber is the number of the deploy project. It overrides the
+	// config's projectNumber and is used for dependency URLs and
+	// cross-project secret aliases.
+	ProjectNumber string
+	// LoadBalancerPath, when set, receives a Terraform JSON document with a
+	// global external load balancer in front of the service in every
+	// region of Regions.
+	LoadBalancerPath string
+	// IAMPolicyPath, when set, receives the IAM policy granting the
+	// config's iam section, which is empty when nothing is granted.
+	IAMPolicyPath string
+	// DeploySettingsPath, when set, receives the config's deploy and
+	// rollout sections with defaults filled in, which is empty without
+	// either.
+	DeploySettingsPath string
+	// WarningsAsErrors fails the render when it produces any warning. No
+	// file is written, and the returned Result holds only the warnings.
+	WarningsAsErrors bool
+}
+
+// The config types are defined by the public manifest package.
+type (
+	appHostingConfig = manifest.Config
+	runConfigEntry   = manifest.RunConfig
+	probeEntry       = manifest.Probe
+	envEntry         = manifest.Env
+)
 
 // NewRenderer creates a Renderer with the given FileIO implementation.
 func NewRenderer(fileIO FileIO) *Renderer {
 	if fileIO == nil {
 		fileIO = OSFileIO{}
 	}
-	return &Renderer{fileIO: fileIO}
+	return &Renderer{fileIO: fileIO, warnings: os.Stderr}
+}
+
+// Result is the outcome of a successful render.
+type Result struct {
+	// Manifest is the rendered manifest in the requested format.
+	Manifest []byte
+	// Warnings lists non-fatal issues found while rendering.
+	Warnings []Warning
+	// DomainMappings holds the DomainMapping manifests as multi-document
+	// YAML; it is nil when the config has no domains.
+	DomainMappings []byte
+	// LoadBalancer holds the load balancer Terraform JSON; it is nil unless
+	// LoadBalancerPath is set or the config has a loadBalancer block.
+	LoadBalancer []byte
+	// IAMPolicy holds the IAM policy as YAML; it is nil when the config has
+	// no iam section.
+	IAMPolicy []byte
+	// DeploySettings holds the deploy and rollout sections as YAML; it is
+	// nil when the config has neither.
+	DeploySettings []byte
+	// ContentHash is the sha256 digest of Manifest, as "sha256:<hex>".
+	ContentHash  string
+	Format       string
+	ResourceType string
 }
 
 // RenderManifest reads an apphosting config and writes a Cloud Run manifest.
+// Warnings are printed to stderr.
 func (r *Renderer) RenderManifest(options RenderOptions) error {
+	result, err := r.RenderManifestContext(context.Background(), options)
+	for _, warning := range result.Warnings {
+		_, _ = fmt.Fprintf(r.warnings, "WARNING: %s\n", warning)
+	}
+	return err
+}
+
+// RenderManifestContext reads an apphosting config, writes a Cloud Run
+// manifest, and returns it together with any warnings. Rendering stops early
+// when ctx is cancelled.
+func (r *Renderer) RenderManifestContext(ctx context.Context, options RenderOptions) (Result, error) {
+	if err := validateRenderPaths(options); err != nil {
+		return Result{}, err
+	}
 	if err := validateRenderOptions(options); err != nil {
-		return err
+		return Result{}, err
+	}
+	if err := r.ensureOutputDirectory(options.OutputPath); err != nil {
+		return Result{}, err
 	}
 
 	configContent, err := r.fileIO.ReadFile(options.ConfigPath)
 	if err != nil {
-		return fmt.Errorf("read config: %w", err)
+		return Result{}, fmt.Errorf("read config: %w", err)
 	}
 
-	var config appHostingConfig
-	if err := yamlv3.Unmarshal(configContent, &config); err != nil {
-		return fmt.Errorf("parse config yaml: %w", err)
-	}
-
-	var manifestContent []byte
-	switch options.ResourceType {
-	case resourceTypeJob:
-		job := buildJobManifest(config, options)
-		manifestContent, err = yaml.Marshal(job)
-		if err != nil {
-			return fmt.Errorf("marshal job manifest: %w", err)
-		}
-	case resourceTypeWorker:
-		worker := buildWorkerManifest(config, options)
-		manifestContent, err = yaml.Marshal(worker)
+	var policies []Policy
+	if options.PolicyPath != "" {
+		policies, err = r.loadPolicies(options.PolicyPath)
 		if err != nil {
-			return fmt.Errorf("marshal worker manifest: %w", err)
-		}
-	default:
-		service, buildErr := buildServiceManifest(config, options)
-		if buildErr != nil {
-			return buildErr
-		}
-		raw, marshalErr := yaml.Marshal(service)
-		if marshalErr != nil {
-			return fmt.Errorf("marshal service manifest: %w", marshalErr)
-		}
-		manifestContent, err = cleanServiceManifest(raw)
-		if err != nil {
-			return fmt.Errorf("clean service manifest: %w", err)
+			return Result{}, err
 		}
 	}
 
-	return r.fileIO.WriteFile(options.OutputPath, manifestContent, 0o644)
-}
-
-// ── Service manifest ────────────────────────────────────────────────────────
-
-func buildServiceManifest(config appHostingConfig, options RenderOptions) (*servingv1.Service, error) {
-	timeout := options.TimeoutSeconds
-	if timeout <= 0 {
-		timeout = defaultTimeoutSeconds
-	}
-
-	cpu := defaultCPU
-	if config.RunConfig.CPU != nil {
-		cpu = *config.RunConfig.CPU
-	}
-	memoryMiB := defaultMemoryMiB
-	if config.RunConfig.MemoryMiB != nil {
-		memoryMiB = *config.RunConfig.MemoryMiB
+	result, err := renderConfig(ctx, r.fileIO, options, configContent, policies)
+	if err != nil {
+		return result, err
 	}
-
-	limits := corev1.ResourceList{
-		corev1.ResourceCPU:    apiresource.MustParse(fmt.Sprintf("%dm", cpu*1000)),
-		corev1.ResourceMemory: apiresource.MustParse(fmt.Sprintf("%dMi", memoryMiB)),
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
 	}
 
-	templateAnnotations := map[string]string{
-	        "run.googleapis.com/execution-environment": "gen2",
-	}
-	if config.CloudSQLConnector != "" {
-	        templateAnnotations["run.googleapis.com/cloudsql-instances"] = config.CloudSQLConnector
-	}
-	if config.RunConfig.Network != "" && config.RunConfig.Subnet != "" {
-		templateAnnotations["run.googleapis.com/network-interfaces"] = fmt.Sprintf(
-			`[{"network":"%s","subnetwork":"%s"}]`,
-			config.RunConfig.Network,
-			config.RunConfig.Subnet,
-		)
+	if err := r.fileIO.WriteFile(options.OutputPath, result.Manifest, 0o644); err != nil {
+		return Result{}, err
 	}
-	if config.RunConfig.VPCConnector != "" {
-		templateAnnotations["run.googleapis.com/vpc-access-connector"] = config.RunConfig.VPCConnector
-	}
-	if config.RunConfig.VPCEgress != "" {
-		templateAnnotations["run.googleapis.com/vpc-access-egress"] = config.RunConfig.VPCEgress
-	}
-
-	envVars := buildServiceEnvironmentVariables(config.Env)
-	container := corev1.Container{
-		Image: options.Image,
-		Resources: corev1.ResourceRequirements{
-			Limits: limits,
-		},
-		Env:            envVars,
-		LivenessProbe:  buildCoreV1Probe(config.RunConfig.LivenessProbe),
-		ReadinessProbe: buildCoreV1Probe(config.RunConfig.ReadinessProbe),
-		StartupProbe:   buildCoreV1Probe(config.RunConfig.StartupProbe),
+	if options.DomainMappingsPath != "" {
+		if err := r.fileIO.WriteFile(options.DomainMappingsPath, result.DomainMappings, 0o644); err != nil {
+			return Result{}, err
+		}
 	}
-
-	timeoutSeconds := int64(timeout)
-	revisionSpec := servingv1.RevisionSpec{
-		PodSpec: corev1.PodSpec{
-			Containers: []corev1.Container{container},
-		},
-		TimeoutSeconds: &timeoutSeconds,
+	if options.LoadBalancerPath != "" {
+		if err := r.fileIO.WriteFile(options.LoadBalancerPath, result.LoadBalancer, 0o644); err != nil {
+			return Result{}, err
+		}
 	}
-	if config.ServiceAccount != "" {
-		revisionSpec.PodSpec.ServiceAccountName = config.ServiceAccount
+	if options.IAMPolicyPath != "" {
+		if err := r.fileIO.WriteFile(options.IAMPolicyPath, result.IAMPolicy, 0o644); err != nil {
+			return Result{}, err
+		}
 	}
-	if config.RunConfig.Concurrency != nil {
-		containerConcurrency := int64(*config.RunConfig.Concurrency)
-		revisionSpec.ContainerConcurrency = &containerConcurrency
+	if options.DeploySettingsPath != "" {
+		if err := r.fileIO.WriteFile(options.DeploySettingsPath, result.DeploySettings, 0o644); err != nil {
+			return Result{}, err
+		}
 	}
+	return result, nil
+}
 
-	serviceAnnotations := map[string]string{
-	        "run.googleapis.com/ingress": "all",
-	}
-	if config.RunConfig.MinInstances != nil {
-	        serviceAnnotations["run.googleapis.com/minScale"] = strconv.Itoa(*config.RunConfig.MinInstances)
-	}
-	if config.RunConfig.MaxInstances != nil {
-	        serviceAnnotations["run.googleapis.com/maxScale"] = strconv.Itoa(*config.RunConfig.MaxInstances)
-	}
-	if config.RunConfig.LivenessProbe != nil || config.RunConfig.ReadinessProbe != nil || config.RunConfig.StartupProbe != nil {
-	        serviceAnnotations["run.googleapis.com/launch-stage"] = "BETA"
+// RenderToResult renders configContent without touching the file system and
+// returns the manifest together with its warnings, content hash and side
+// outputs. ConfigPath and OutputPath are ignored; PolicyPath and envFrom must
+// be empty because those files are read through a Renderer's FileIO.
+func RenderToResult(ctx context.Context, options RenderOptions, configContent []byte) (Result, error) {
+	if err := validateRenderOptions(options); err != nil {
+		return Result{}, err
 	}
-
-	service := &servingv1.Service{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "serving.knative.dev/v1",
-			Kind:       "Service",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        options.ServiceName,
-			Annotations: serviceAnnotations,
-		},
-		Spec: servingv1.ServiceSpec{
-			ConfigurationSpec: servingv1.ConfigurationSpec{
-				Template: servingv1.RevisionTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{
-						Annotations: templateAnnotations,
-					},
-					Spec: revisionSpec,
-				},
-			},
-		},
+	if options.PolicyPath != "" {
+		return Result{}, errors.New("policy path is not supported for in-memory rendering; use Renderer.RenderManifest")
 	}
-	return service, nil
+	return renderConfig(ctx, nil, options, configContent, nil)
 }
 
-func cleanServiceManifest(data []byte) ([]byte, error) {
-	var raw map[string]interface{}
-	if err := yaml.Unmarshal(data, &raw); err != nil {
+// RenderToBytes is RenderToResult for callers that only need the manifest.
+func RenderToBytes(ctx context.Context, options RenderOptions, configContent []byte) ([]byte, error) {
+	result, err := RenderToResult(ctx, options, configContent)
+	if err != nil {
 		return nil, err
 	}
-	delete(raw, "status")
-	removeEmptyContainerNames(raw)
-	return yaml.Marshal(raw)
+	return result.Manifest, nil
 }
 
-func removeEmptyContainerNames(node interface{}) {
-	switch v := node.(type) {
-	case map[string]interface{}:
-		if _, hasImage := v["image"]; hasImage {
-			if name, ok := v["name"].(string); ok && name == "" {
-				delete(v, "name")
-			}
-		}
-		for _, child := range v {
-			removeEmptyContainerNames(child)
-		}
-	case []interface{}:
-		for _, item := range v {
-			removeEmptyContainerNames(item)
-		}
+// renderConfig parses configContent and renders it in options.Format. envFrom
+// sources are read through fileIO. When policies is non-nil they are
+// evaluated against the Knative manifest before any format translation.
+func renderConfig(ctx context.Context, fileIO FileIO, options RenderOptions, configContent []byte, policies []Policy) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
 	}
-}
 
-func buildCoreV1Probe(p *probeEntry) *corev1.Probe {
-	if p == nil {
-		return nil
+	config, err := manifest.ParseConfig(configContent)
+	if err != nil {
+		return Result{}, err
 	}
-	probe := &corev1.Probe{}
-	if p.InitialDelaySeconds != nil {
-		probe.InitialDelaySeconds = *p.InitialDelaySeconds
+	configDir := options.ConfigDir
+	if configDir == "" {
+		configDir = filepath.Dir(options.ConfigPath)
 	}
-	if p.TimeoutSeconds != nil {
-		probe.TimeoutSeconds = *p.TimeoutSeconds
+	config, err = resolveEnvFrom(fileIO, configDir, config)
+	if err != nil {
+		return Result{}, err
 	}
-	if p.PeriodSeconds != nil {
-		probe.PeriodSeconds = *p.PeriodSeconds
+	if options.ProjectNumber != "" {
+		config.ProjectNumber = options.ProjectNumber
 	}
-	if p.SuccessThreshold != nil {
-		probe.SuccessThreshold = *p.SuccessThreshold
+	if options.ResourceType != "" && options.ResourceType != resourceTypeService {
+		config.RunConfig.Mesh = ""
 	}
-	if p.FailureThreshold != nil {
-		probe.FailureThreshold = *p.FailureThreshold
+	// Dependencies are resolved once here so every format and the plaintext
+	// check see the injected env.
+	config, err = manifest.ResolveDependencies(config, manifest.Options{
+		Name:   options.ServiceName,
+		Region: options.Region,
+	})
+	if err != nil {
+		return Result{}, err
 	}
-
-	if p.HTTPGet != nil {
-		probe.HTTPGet = &corev1.HTTPGetAction{
-			Path: p.HTTPGet.Path,
-		}
-		if p.HTTPGet.Port != nil {
-			probe.HTTPGet.Port = intstr.FromInt32(*p.HTTPGet.Port)
-		}
-		for _, h := range p.HTTPGet.HTTPHeaders {
-			probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, corev1.HTTPHeader{
-				Name:  h.Name,
-				Value: h.Value,
-			})
-		}
-	} else if p.TCPSocket != nil {
-		probe.TCPSocket = &corev1.TCPSocketAction{}
-		if p.TCPSocket.Port != nil {
-			probe.TCPSocket.Port = intstr.FromInt32(*p.TCPSocket.Port)
-		}
-	} else if p.GRPC != nil {
-		probe.GRPC = &corev1.GRPCAction{}
-		if p.GRPC.Port != nil {
-			probe.GRPC.Port = *p.GRPC.Port
-		}
-		if p.GRPC.Service != "" {
-			service := p.GRPC.Service
-			probe.GRPC.Service = &service
-		}
+	if err := validatePlaintextEnv(config.Env); err != nil {
+		return Result{}, err
 	}
-	return probe
-}
-
-func buildServiceEnvironmentVariables(entries []envEntry) []corev1.EnvVar {
-	envVars := make([]corev1.EnvVar, 0, len(entries))
-	for _, entry := range entries {
-		if entry.Variable == "" {
-			continue
-		}
-		if entry.Value != "" {
-			envVars = append(envVars, corev1.EnvVar{
-				Name:  entry.Variable,
-				Value: entry.Value,
-			})
-			continue
-		}
-		if entry.Secret == "" {
-			continue
-		}
-		secretName := secretNameFromReference(entry.Secret)
-		envVars = append(envVars, corev1.EnvVar{
-			Name: entry.Variable,
-			ValueFrom: &corev1.EnvVarSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: secretName,
-					},
-					Key: "latest",
-				},
-			},
-		})
-	}
-	return envVars
-}
-
-// ── Job manifest ────────────────────────────────────────────────────────────
-
-type jobManifest struct {
-	APIVersion string      `json:"apiVersion"`
-	Kind       string      `json:"kind"`
-	Metadata   jobMetadata `json:"metadata"`
-	Spec       jobSpec     `json:"spec"`
-}
 
-type jobMetadata struct {
-	Name string `json:"name"`
-}
-
-type jobSpec struct {
-	Template jobExecutionTemplate `json:"template"`
-}
-
-type jobExecutionTemplate struct {
-	Metadata *jobAnnotatedMetadata `json:"metadata,omitempty"`
-	Spec     jobExecutionSpec      `json:"spec"`
-}
-
-type jobAnnotatedMetadata struct {
-	Annotations map[string]string `json:"annotations,omitempty"`
-}
-
-type jobExecutionSpec struct {
-	TaskCount   int             `json:"taskCount"`
-	Parallelism *int            `json:"parallelism,omitempty"`
-	Template    jobTaskTemplate `json:"template"`
-}
-
-type jobTaskTemplate struct {
-	Spec jobTaskSpec `json:"spec"`
-}
-
-type jobTaskSpec struct {
-	Containers         []jobContainer `json:"containers"`
-	ServiceAccountName string         `json:"serviceAccountName,omitempty"`
-	MaxRetries         *int           `json:"maxRetries,omitempty"`
-	TimeoutSeconds     *int           `json:"timeoutSeconds,omitempty"`
-}
-
-type jobContainer struct {
-	Image     string                `json:"image"`
-	Resources jobContainerResources `json:"resources"`
-	Env       []jobEnvVar           `json:"env,omitempty"`
-}
-
-type jobContainerResources struct {
-	Limits map[string]string `json:"limits"`
-}
-
-type jobEnvVar struct {
-	Name      string           `json:"name"`
-	Value     string           `json:"value,omitempty"`
-	ValueFrom *jobEnvVarSource `json:"valueFrom,omitempty"`
-}
-
-type jobEnvVarSource struct {
-	SecretKeyRef *jobSecretKeyRef `json:"secretKeyRef"`
-}
-
-type jobSecretKeyRef struct {
-	Name string `json:"name"`
-	Key  string `json:"key"`
-}
-
-func buildJobManifest(config appHostingConfig, options RenderOptions) *jobManifest {
-	cpu := defaultCPU
-	if config.RunConfig.CPU != nil {
-		cpu = *config.RunConfig.CPU
-	}
-	memoryMiB := defaultMemoryMiB
-	if config.RunConfig.MemoryMiB != nil {
-		memoryMiB = *config.RunConfig.MemoryMiB
+	knativeManifest, err := renderKnativeManifest(config, options)
+	if err != nil {
+		return Result{}, err
 	}
-	taskCount := defaultTaskCount
-	if config.RunConfig.TaskCount != nil {
-		taskCount = *config.RunConfig.TaskCount
+	domainMappings, err := renderDomainMappings(config, options)
+	if err != nil {
+		return Result{}, err
 	}
-
-	container := jobContainer{
-		Image: options.Image,
-		Resources: jobContainerResources{
-			Limits: map[string]string{
-				"cpu":    fmt.Sprintf("%dm", cpu*1000),
-				"memory": fmt.Sprintf("%dMi", memoryMiB),
-			},
-		},
-		Env: buildJobEnvironmentVariables(config.Env),
+	var loadBalancer []byte
+	if options.LoadBalancerPath != "" || config.LoadBalancer != nil {
+		loadBalancer, err = renderLoadBalancer(config, options)
+		if err != nil {
+			return Result{}, err
+		}
 	}
-
-	taskSpec := jobTaskSpec{
-		Containers: []jobContainer{container},
+	iamPolicy, err := renderIAMPolicy(config, options)
+	if err != nil {
+		return Result{}, err
 	}
-	if config.ServiceAccount != "" {
-		taskSpec.ServiceAccountName = config.ServiceAccount
+	deploySettings, err := renderDeploySettings(config, options)
+	if err != nil {
+		return Result{}, err
 	}
-	if config.RunConfig.MaxRetries != nil {
-		taskSpec.MaxRetries = config.RunConfig.MaxRetries
+	if policies != nil {
+		if err := evaluateManifestPolicies(policies, options.Environment, knativeManifest); err != nil {
+			return Result{}, err
+		}
 	}
-	if config.RunConfig.TimeoutSeconds != nil {
-		taskSpec.TimeoutSeconds = config.RunConfig.TimeoutSeconds
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
 	}
 
-	executionSpec := jobExecutionSpec{
-		TaskCount: taskCount,
-		Template:  jobTaskTemplate{Spec: taskSpec},
+	var manifestContent []byte
+	switch options.Format {
+	case formatV2JSON:
+		manifestContent, err = renderV2Manifest(config, options)
+	case formatTerraformJSON:
+		manifestContent, err = renderTerraformManifest(config, options)
+	case formatKnativeJSON:
+		manifestContent, err = knativeYAMLToJSON(knativeManifest)
+	default:
+		manifestContent = knativeManifest
 	}
-	if config.RunConfig.Parallelism != nil {
-		executionSpec.Parallelism = config.RunConfig.Parallelism
+	if err != nil {
+		return Result{}, err
 	}
 
-	executionTemplate := jobExecutionTemplate{
-		Spec: executionSpec,
+	format := options.Format
+	if format == "" {
+		format = formatKnativeYAML
 	}
-	annotations := map[string]string{}
-	if config.CloudSQLConnector != "" {
-		annotations["run.googleapis.com/cloudsql-instances"] = config.CloudSQLConnector
-	}
-	if config.RunConfig.Network != "" && config.RunConfig.Subnet != "" {
-		annotations["run.googleapis.com/network-interfaces"] = fmt.Sprintf(
-			`[{"network":"%s","subnetwork":"%s"}]`,
-			config.RunConfig.Network,
-			config.RunConfig.Subnet,
-		)
-	}
-	if config.RunConfig.VPCConnector != "" {
-		annotations["run.googleapis.com/vpc-access-connector"] = config.RunConfig.VPCConnector
-	}
-	if config.RunConfig.VPCEgress != "" {
-		annotations["run.googleapis.com/vpc-access-egress"] = config.RunConfig.VPCEgress
+	resourceType := options.ResourceType
+	if resourceType == "" {
+		resourceType = resourceTypeService
 	}
-
-	if len(annotations) > 0 {
-		executionTemplate.Metadata = &jobAnnotatedMetadata{
-			Annotations: annotations,
-		}
+	warnings := collectWarnings(config, options)
+	if options.WarningsAsErrors && len(warnings) > 0 {
+		return Result{Warnings: warnings}, fmt.Errorf("%d warning(s) treated as errors", len(warnings))
+	}
+	digest := sha256.Sum256(manifestContent)
+	return Result{
+		Manifest:       manifestContent,
+		DomainMappings: domainMappings,
+		LoadBalancer:   loadBalancer,
+		IAMPolicy:      iamPolicy,
+		DeploySettings: deploySettings,
+		Warnings:       warnings,
+		ContentHash:    "sha256:" + hex.EncodeToString(digest[:]),
+		Format:         format,
+		ResourceType:   resourceType,
+	}, nil
+}
+
+func (r *Renderer) ensureOutputDirectory(outputPath string) error {
+	outputDirectory := filepath.Dir(outputPath)
+	if outputDirectory == "." || outputDirectory == "" {
+		return nil
 	}
-
-	return &jobManifest{
-		APIVersion: "run.googleapis.com/v1",
-		Kind:       "Job",
-		Metadata:   jobMetadata{Name: options.ServiceName},
-		Spec:       jobSpec{Template: executionTemplate},
+	if err := r.fileIO.MkdirAll(outputDirectory, 0o755); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
 	}
+	return nil
 }
 
-func buildJobEnvironmentVariables(entries []envEntry) []jobEnvVar {
-	envVars := make([]jobEnvVar, 0, len(entries))
-	for _, entry := range entries {
-		if entry.Variable == "" {
-			continue
-		}
-		if entry.Value != "" {
-			envVars = append(envVars, jobEnvVar{
-				Name:  entry.Variable,
-				Value: entry.Value,
-			})
-			continue
-		}
-		if entry.Secret == "" {
-			continue
-		}
-		secretName := secretNameFromReference(entry.Secret)
-		envVars = append(envVars, jobEnvVar{
-			Name: entry.Variable,
-			ValueFrom: &jobEnvVarSource{
-				SecretKeyRef: &jobSecretKeyRef{
-					Name: secretName,
-					Key:  "latest",
-				},
-			},
-		})
-	}
-	return envVars
-}
-
-// ── Worker pool manifest ────────────────────────────────────────────────────
-
-type workerPoolManifest struct {
-	APIVersion string             `json:"apiVersion"`
-	Kind       string             `json:"kind"`
-	Metadata   workerPoolMetadata `json:"metadata"`
-	Spec       workerPoolSpec     `json:"spec"`
-}
-
-type workerPoolMetadata struct {
-	Name        string            `json:"name"`
-	Annotations map[string]string `json:"annotations,omitempty"`
-}
-
-type workerPoolSpec struct {
-	Template workerPoolTemplate `json:"template"`
+// LintOptions configures a policy check of an already rendered manifest.
+type LintOptions struct {
+	ManifestPath string
+	PolicyPath   string
+	Environment  string
 }
 
-type workerPoolTemplate struct {
-	Metadata *workerPoolAnnotatedMetadata `json:"metadata,omitempty"`
-	Spec     workerPoolRevisionSpec       `json:"spec"`
-}
-
-type workerPoolAnnotatedMetadata struct {
-	Annotations map[string]string `json:"annotations,omitempty"`
-}
-
-type workerPoolRevisionSpec struct {
-	Containers         []workerPoolContainer `json:"containers"`
-	ServiceAccountName string                `json:"serviceAccountName,omitempty"`
-	TimeoutSeconds     *int                  `json:"timeoutSeconds,omitempty"`
-}
-
-type workerPoolContainer struct {
-	Image          string                       `json:"image"`
-	Resources      workerPoolContainerResources `json:"resources"`
-	Env            []workerPoolEnvVar           `json:"env,omitempty"`
-	LivenessProbe  *workerPoolProbe             `json:"livenessProbe,omitempty"`
-	ReadinessProbe *workerPoolProbe             `json:"readinessProbe,omitempty"`
-	StartupProbe   *workerPoolProbe             `json:"startupProbe,omitempty"`
-}
-
-type workerPoolProbe struct {
-	InitialDelaySeconds *int32                     `json:"initialDelaySeconds,omitempty"`
-	TimeoutSeconds      *int32                     `json:"timeoutSeconds,omitempty"`
-	PeriodSeconds       *int32                     `json:"periodSeconds,omitempty"`
-	SuccessThreshold    *int32                     `json:"successThreshold,omitempty"`
-	FailureThreshold    *int32                     `json:"failureThreshold,omitempty"`
-	HTTPGet             *workerPoolHTTPGetAction   `json:"httpGet,omitempty"`
-	TCPSocket           *workerPoolTCPSocketAction `json:"tcpSocket,omitempty"`
-	GRPC                *workerPoolGRPCAction      `json:"grpc,omitempty"`
-}
-
-type workerPoolHTTPGetAction struct {
-	Path        string                 `json:"path,omitempty"`
-	Port        *int32                 `json:"port,omitempty"`
-	HTTPHeaders []workerPoolHTTPHeader `json:"httpHeaders,omitempty"`
-}
-
-type workerPoolHTTPHeader struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
-}
-
-type workerPoolTCPSocketAction struct {
-	Port *int32 `json:"port,omitempty"`
-}
-
-type workerPoolGRPCAction struct {
-	Port    *int32 `json:"port,omitempty"`
-	Service string `json:"service,omitempty"`
-}
-
-type workerPoolContainerResources struct {
-	Limits map[string]string `json:"limits"`
-}
-
-type workerPoolEnvVar struct {
-	Name      string                  `json:"name"`
-	Value     string                  `json:"value,omitempty"`
-	ValueFrom *workerPoolEnvVarSource `json:"valueFrom,omitempty"`
-}
-
-type workerPoolEnvVarSource struct {
-	SecretKeyRef *workerPoolSecretKeyRef `json:"secretKeyRef"`
-}
-
-type workerPoolSecretKeyRef struct {
-	Name string `json:"name"`
-	Key  string `json:"key"`
-}
-
-func buildWorkerPoolProbe(p *probeEntry) *workerPoolProbe {
-	if p == nil {
-		return nil
+// Lint evaluates the policies in options.PolicyPath (or the built-in
+// defaults when empty) against a rendered Knative manifest.
+func (r *Renderer) Lint(options LintOptions) error {
+	if options.ManifestPath == "" {
+		return errors.New("manifest path is required")
 	}
-	probe := &workerPoolProbe{
-		InitialDelaySeconds: p.InitialDelaySeconds,
-		TimeoutSeconds:      p.TimeoutSeconds,
-		PeriodSeconds:       p.PeriodSeconds,
-		SuccessThreshold:    p.SuccessThreshold,
-		FailureThreshold:    p.FailureThreshold,
+	manifestContent, err := r.fileIO.ReadFile(options.ManifestPath)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
 	}
-
-	if p.HTTPGet != nil {
-		probe.HTTPGet = &workerPoolHTTPGetAction{
-			Path: p.HTTPGet.Path,
-			Port: p.HTTPGet.Port,
-		}
-		for _, h := range p.HTTPGet.HTTPHeaders {
-			probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, workerPoolHTTPHeader{
-				Name:  h.Name,
-				Value: h.Value,
-			})
-		}
-	} else if p.TCPSocket != nil {
-		probe.TCPSocket = &workerPoolTCPSocketAction{
-			Port: p.TCPSocket.Port,
-		}
-	} else if p.GRPC != nil {
-		var service string
-		if p.GRPC.Service != "" {
-			service = p.GRPC.Service
-		}
-		probe.GRPC = &workerPoolGRPCAction{
-			Port:    p.GRPC.Port,
-			Service: service,
-		}
+	policies, err := r.loadPolicies(options.PolicyPath)
+	if err != nil {
+		return err
 	}
-	return probe
+	return evaluateManifestPolicies(policies, options.Environment, manifestContent)
 }
 
-func buildWorkerManifest(config appHostingConfig, options RenderOptions) *workerPoolManifest {
-	cpu := defaultCPU
-	if config.RunConfig.CPU != nil {
-		cpu = *config.RunConfig.CPU
+// loadPolicies reads the policy rules file at policyPath. An empty
+// policyPath selects the built-in defaults.
+func (r *Renderer) loadPolicies(policyPath string) ([]Policy, error) {
+	if policyPath == "" {
+		return DefaultPolicies(), nil
 	}
-	memoryMiB := defaultMemoryMiB
-	if config.RunConfig.MemoryMiB != nil {
-		memoryMiB = *config.RunConfig.MemoryMiB
+	policyContent, err := r.fileIO.ReadFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
 	}
-
-	container := workerPoolContainer{
-		Image: options.Image,
-		Resources: workerPoolContainerResources{
-			Limits: map[string]string{
-				"cpu":    fmt.Sprintf("%dm", cpu*1000),
-				"memory": fmt.Sprintf("%dMi", memoryMiB),
-			},
-		},
-		Env:            buildWorkerEnvironmentVariables(config.Env),
-		LivenessProbe:  buildWorkerPoolProbe(config.RunConfig.LivenessProbe),
-		ReadinessProbe: buildWorkerPoolProbe(config.RunConfig.ReadinessProbe),
-		StartupProbe:   buildWorkerPoolProbe(config.RunConfig.StartupProbe),
+	policies, err := LoadPolicies(policyContent)
+	if err != nil {
+		return nil, fmt.Errorf("load policy %s: %w", policyPath, err)
 	}
+	return policies, nil
+}
 
-	revisionSpec := workerPoolRevisionSpec{
-		Containers: []workerPoolContainer{container},
-	}
-	if config.ServiceAccount != "" {
-		revisionSpec.ServiceAccountName = config.ServiceAccount
+func evaluateManifestPolicies(policies []Policy, environment string, knativeManifest []byte) error {
+	manifest, err := ParseManifestForPolicy(knativeManifest)
+	if err != nil {
+		return err
 	}
-	timeout := options.TimeoutSeconds
-	if timeout > 0 {
-		revisionSpec.TimeoutSeconds = &timeout
+	return EvaluatePolicies(policies, PolicyInput{
+		Environment: environment,
+		Manifest:    manifest,
+	})
+}
+
+// renderKnativeManifest builds the Knative-style manifest for the requested
+// resource type and marshals it to YAML.
+func renderKnativeManifest(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	manifestOptions := manifest.Options{
+		Name:           options.ServiceName,
+		Image:          options.Image,
+		Region:         options.Region,
+		TimeoutSeconds: options.TimeoutSeconds,
 	}
 
-	templateAnnotations := map[string]string{
-		"run.googleapis.com/execution-environment": "gen2",
+	var built interface{}
+	var err error
+	switch options.ResourceType {
+	case resourceTypeJob:
+		built, err = manifest.BuildJob(config, manifestOptions)
+	case resourceTypeWorker:
+		built, err = manifest.BuildWorkerPool(config, manifestOptions)
+	default:
+		built, err = manifest.BuildService(config, manifestOptions)
 	}
-	if config.CloudSQLConnector != "" {
-	        templateAnnotations["run.googleapis.com/cloudsql-instances"] = config.CloudSQLConnector
+	if err != nil {
+		return nil, err
 	}
-	if config.RunConfig.Network != "" && config.RunConfig.Subnet != "" {
-		templateAnnotations["run.googleapis.com/network-interfaces"] = fmt.Sprintf(
-			`[{"network":"%s","subnetwork":"%s"}]`,
-			config.RunConfig.Network,
-			config.RunConfig.Subnet,
-		)
+	return manifest.Marshal(built)
+}
+
+// renderDomainMappings renders the config's domains as DomainMapping
+// manifests separated by YAML document markers.
+func renderDomainMappings(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	if len(config.Domains) == 0 {
+		return nil, nil
 	}
-	if config.RunConfig.VPCConnector != "" {
-		templateAnnotations["run.googleapis.com/vpc-access-connector"] = config.RunConfig.VPCConnector
+	if options.ResourceType != "" && options.ResourceType != resourceTypeService {
+		return nil, fmt.Errorf("domains are only supported for services, not %ss", options.ResourceType)
 	}
-	if config.RunConfig.VPCEgress != "" {
-		templateAnnotations["run.googleapis.com/vpc-access-egress"] = config.RunConfig.VPCEgress
+	if len(options.Regions) > 1 {
+		return nil, fmt.Errorf("domains cannot be mapped in a multi-region deploy (%s); put a global load balancer in front of the service instead",
+			strings.Join(options.Regions, ", "))
 	}
 
-	template := workerPoolTemplate{
-		Spec: revisionSpec,
+	mappings, err := manifest.BuildDomainMappings(config, manifest.Options{Name: options.ServiceName})
+	if err != nil {
+		return nil, err
 	}
-	if len(templateAnnotations) > 0 {
-		template.Metadata = &workerPoolAnnotatedMetadata{
-			Annotations: templateAnnotations,
+	var buffer bytes.Buffer
+	for i, mapping := range mappings {
+		content, err := manifest.Marshal(mapping)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buffer.WriteString("---\n")
 		}
+		buffer.Write(content)
 	}
+	return buffer.Bytes(), nil
+}
 
-	workerAnnotations := map[string]string{}
-	if config.RunConfig.MinInstances != nil {
-	        workerAnnotations["run.googleapis.com/minScale"] = strconv.Itoa(*config.RunConfig.MinInstances)
+// renderIAMPolicy renders the config's iam section as an IAM policy.
+// Worker pools are not invoked, so they cannot grant invokers.
+func renderIAMPolicy(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	if config.IAM == nil {
+		return nil, nil
 	}
-	if config.RunConfig.MaxInstances != nil {
-	        workerAnnotations["run.googleapis.com/maxScale"] = strconv.Itoa(*config.RunConfig.MaxInstances)
+	if options.ResourceType == resourceTypeWorker {
+		return nil, fmt.Errorf("iam is only supported for services and jobs, not %ss", options.ResourceType)
 	}
-	if config.RunConfig.LivenessProbe != nil || config.RunConfig.ReadinessProbe != nil || config.RunConfig.StartupProbe != nil {
-	        workerAnnotations["run.googleapis.com/launch-stage"] = "BETA"
+	policy, err := manifest.BuildIAMPolicy(config)
+	if err != nil || policy == nil {
+		return nil, err
 	}
+	return manifest.Marshal(policy)
+}
 
-	metadata := workerPoolMetadata{
-		Name: options.ServiceName,
+// renderDeploySettings renders the config's deploy and rollout sections.
+// Both follow a single service revision, so they are rejected for jobs,
+// worker pools, and multi-region services.
+func renderDeploySettings(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	settings, err := manifest.BuildDeploySettings(config)
+	if err != nil || settings == nil {
+		return nil, err
 	}
-	if len(workerAnnotations) > 0 {
-		metadata.Annotations = workerAnnotations
+	var sections []string
+	if settings.Verify != nil {
+		sections = append(sections, "deploy.verify")
 	}
-
-	return &workerPoolManifest{
-		APIVersion: "run.googleapis.com/v1",
-		Kind:       "WorkerPool",
-		Metadata:   metadata,
-		Spec:       workerPoolSpec{Template: template},
+	if settings.Rollout != nil {
+		sections = append(sections, "rollout")
 	}
-}
-
-func buildWorkerEnvironmentVariables(entries []envEntry) []workerPoolEnvVar {
-	envVars := make([]workerPoolEnvVar, 0, len(entries))
-	for _, entry := range entries {
-		if entry.Variable == "" {
-			continue
+	for _, section := range sections {
+		if options.ResourceType != "" && options.ResourceType != resourceTypeService {
+			return nil, fmt.Errorf("%s is only supported for services, not %ss", section, options.ResourceType)
 		}
-		if entry.Value != "" {
-			envVars = append(envVars, workerPoolEnvVar{
-				Name:  entry.Variable,
-				Value: entry.Value,
-			})
-			continue
+		if len(options.Regions) > 1 {
+			return nil, fmt.Errorf("%s is not supported for multi-region services", section)
 		}
-		if entry.Secret == "" {
-			continue
-		}
-		secretName := secretNameFromReference(entry.Secret)
-		envVars = append(envVars, workerPoolEnvVar{
-			Name: entry.Variable,
-			ValueFrom: &workerPoolEnvVarSource{
-				SecretKeyRef: &workerPoolSecretKeyRef{
-					Name: secretName,
-					Key:  "latest",
-				},
-			},
-		})
-	}
-	return envVars
+	}
+	return manifest.Marshal(settings)
+}
+
+// knativeYAMLToJSON converts a rendered Knative YAML manifest to indented JSON.
+func knativeYAMLToJSON(data []byte) ([]byte, error) {
+	raw, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("convert manifest to json: %w", err)
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		return nil, fmt.Errorf("indent manifest json: %w", err)
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
 }
 
 // ── Shared utilities ────────────────────────────────────────────────────────
 
-func validateRenderOptions(options RenderOptions) error {
+func validateRenderPaths(options RenderOptions) error {
 	if options.ConfigPath == "" {
 		return errors.New("config path is required")
 	}
+	if options.OutputPath == "" {
+		return errors.New("output path is required")
+	}
+	return nil
+}
+
+func validateRenderOptions(options RenderOptions) error {
 	if options.ServiceName == "" {
 		return errors.New("service name is required")
 	}
@@ -842,8 +548,8 @@ func validateRenderOptions(options RenderOptions) error {
 	if options.Image == "" {
 		return errors.New("image is required")
 	}
-	if options.OutputPath == "" {
-		return errors.New("output path is required")
+	if err := validateImage(options); err != nil {
+		return err
 	}
 	resourceType := options.ResourceType
 	if resourceType == "" {
@@ -853,17 +559,11 @@ func validateRenderOptions(options RenderOptions) error {
 		return fmt.Errorf("resource type must be %q, %q, or %q, got %q",
 			resourceTypeService, resourceTypeWorker, resourceTypeJob, options.ResourceType)
 	}
-	outputDirectory := filepath.Dir(options.OutputPath)
-	if outputDirectory == "." || outputDirectory == "" {
-		return nil
-	}
-	return os.MkdirAll(outputDirectory, 0o755)
-}
-
-func secretNameFromReference(secretReference string) string {
-	parts := strings.Split(secretReference, "/")
-	if len(parts) == 0 {
-		return secretReference
+	switch options.Format {
+	case "", formatKnativeYAML, formatKnativeJSON, formatV2JSON, formatTerraformJSON:
+	default:
+		return fmt.Errorf("format must be %q, %q, %q, or %q, got %q",
+			formatKnativeYAML, formatKnativeJSON, formatV2JSON, formatTerraformJSON, options.Format)
 	}
-	return parts[len(parts)-1]
+	return nil
 }
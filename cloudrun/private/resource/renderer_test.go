@@ -3,6 +3,7 @@ package resource
 import (
 	"errors"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -40,6 +41,10 @@ func (f *fakeFileIO) WriteFile(path string, data []byte, _ os.FileMode) error {
 	return nil
 }
 
+func (f *fakeFileIO) MkdirAll(_ string, _ os.FileMode) error {
+	return nil
+}
+
 func TestRendererSuite(t *testing.T) {
 	suite.Run(t, new(rendererSuite))
 }
@@ -68,7 +73,7 @@ env:
 			ConfigPath:     "config.yaml",
 			ServiceName:    "myapp",
 			Region:         "us-central1",
-			Image:          "example.com/myapp@sha256:abc",
+			Image:          "example.com/myapp@" + testDigest,
 			ResourceType:   "service",
 			TimeoutSeconds: 300,
 			OutputPath:     "manifest.yaml",
@@ -109,7 +114,7 @@ env:
 		require.Len(s.T(), containers, 1)
 
 		container := containers[0].(map[string]interface{})
-		require.Equal(s.T(), "example.com/myapp@sha256:abc", container["image"])
+		require.Equal(s.T(), "example.com/myapp@"+testDigest, container["image"])
 		require.Nil(s.T(), container["name"], "container name must not be present when empty")
 
 		envList := container["env"].([]interface{})
@@ -148,7 +153,7 @@ env:
 			ConfigPath:     "config.yaml",
 			ServiceName:    "myapp",
 			Region:         "us-central1",
-			Image:          "example.com/myapp@sha256:def",
+			Image:          "example.com/myapp@" + testDigest,
 			ResourceType:   "service",
 			TimeoutSeconds: 900,
 			OutputPath:     "manifest.yaml",
@@ -221,7 +226,7 @@ env:
 			ConfigPath:   "config.yaml",
 			ServiceName:  "myjob",
 			Region:       "us-central1",
-			Image:        "example.com/myjob@sha256:abc123",
+			Image:        "example.com/myjob@" + testDigest,
 			ResourceType: "job",
 			OutputPath:   "manifest.yaml",
 		}
@@ -260,7 +265,7 @@ env:
 		containers := taskSpec["containers"].([]interface{})
 		require.Len(s.T(), containers, 1)
 		container := containers[0].(map[string]interface{})
-		require.Equal(s.T(), "example.com/myjob@sha256:abc123", container["image"])
+		require.Equal(s.T(), "example.com/myjob@"+testDigest, container["image"])
 
 		resources := container["resources"].(map[string]interface{})
 		limits := resources["limits"].(map[string]interface{})
@@ -311,7 +316,7 @@ runConfig:
 			ConfigPath:     "config.yaml",
 			ServiceName:    "myapp",
 			Region:         "us-central1",
-			Image:          "example.com/myapp@sha256:abc",
+			Image:          "example.com/myapp@" + testDigest,
 			ResourceType:   "service",
 			TimeoutSeconds: 300,
 			OutputPath:     "manifest.yaml",
@@ -387,7 +392,7 @@ runConfig:
 			ConfigPath:   "config.yaml",
 			ServiceName:  "simple-job",
 			Region:       "us-central1",
-			Image:        "example.com/job@sha256:def",
+			Image:        "example.com/job@" + testDigest,
 			ResourceType: "job",
 			OutputPath:   "manifest.yaml",
 		}
@@ -445,7 +450,7 @@ env:
 			ConfigPath:     "config.yaml",
 			ServiceName:    "myworker",
 			Region:         "us-central1",
-			Image:          "example.com/myworker@sha256:abc123",
+			Image:          "example.com/myworker@" + testDigest,
 			ResourceType:   "worker",
 			TimeoutSeconds: 600,
 			OutputPath:     "manifest.yaml",
@@ -488,7 +493,7 @@ env:
 		containers := tmplSpec["containers"].([]interface{})
 		require.Len(s.T(), containers, 1)
 		container := containers[0].(map[string]interface{})
-		require.Equal(s.T(), "example.com/myworker@sha256:abc123", container["image"])
+		require.Equal(s.T(), "example.com/myworker@"+testDigest, container["image"])
 
 		resources := container["resources"].(map[string]interface{})
 		limits := resources["limits"].(map[string]interface{})
@@ -540,7 +545,7 @@ runConfig:
 			ConfigPath:     "config.yaml",
 			ServiceName:    "myapp-worker",
 			Region:         "us-central1",
-			Image:          "example.com/myapp-worker@sha256:abc",
+			Image:          "example.com/myapp-worker@" + testDigest,
 			ResourceType:   "worker",
 			TimeoutSeconds: 300,
 			OutputPath:     "manifest.yaml",
@@ -616,7 +621,7 @@ runConfig:
 			ConfigPath:   "config.yaml",
 			ServiceName:  "simple-worker",
 			Region:       "us-central1",
-			Image:        "example.com/worker@sha256:def",
+			Image:        "example.com/worker@" + testDigest,
 			ResourceType: "worker",
 			OutputPath:   "manifest.yaml",
 		}
@@ -639,7 +644,7 @@ runConfig:
 		containers := tmplSpec["containers"].([]interface{})
 		require.Len(s.T(), containers, 1)
 		container := containers[0].(map[string]interface{})
-		require.Equal(s.T(), "example.com/worker@sha256:def", container["image"])
+		require.Equal(s.T(), "example.com/worker@"+testDigest, container["image"])
 
 		resources := container["resources"].(map[string]interface{})
 		limits := resources["limits"].(map[string]interface{})
@@ -660,7 +665,7 @@ func (s *rendererSuite) TestRenderManifestErrors() {
 			ConfigPath:   "config.yaml",
 			ServiceName:  "myapp",
 			Region:       "us-central1",
-			Image:        "example.com/myapp@sha256:abc",
+			Image:        "example.com/myapp@" + testDigest,
 			ResourceType: "invalid",
 			OutputPath:   "manifest.yaml",
 		})
@@ -680,7 +685,7 @@ func (s *rendererSuite) TestRenderManifestErrors() {
 			ConfigPath:   "config.yaml",
 			ServiceName:  "myapp",
 			Region:       "us-central1",
-			Image:        "example.com/myapp@sha256:abc",
+			Image:        "example.com/myapp@" + testDigest,
 			ResourceType: "service",
 			OutputPath:   "manifest.yaml",
 		})
@@ -689,12 +694,219 @@ func (s *rendererSuite) TestRenderManifestErrors() {
 	})
 }
 
-func (s *rendererSuite) TestSecretNameFromReference() {
-	s.Run("extracts final segment", func() {
-		require.Equal(s.T(), "API_KEY", secretNameFromReference("projects/123456789/secrets/API_KEY"))
+func (s *rendererSuite) TestRenderManifestEnvErrors() {
+	render := func(resourceType, config string) error {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte(config)},
+			writeFiles: map[string][]byte{},
+		}
+		return NewRenderer(fileIO).RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: resourceType,
+			OutputPath:   "manifest.yaml",
+		})
+	}
+
+	for _, resourceType := range []string{"service", "job", "worker"} {
+		s.Run("rejects duplicate variables for "+resourceType, func() {
+			err := render(resourceType, "env:\n  - variable: A\n    value: x\n  - variable: A\n    value: y\n")
+			require.ErrorContains(s.T(), err, `env "A" is defined more than once`)
+		})
+
+		s.Run("rejects entries without value or secret for "+resourceType, func() {
+			err := render(resourceType, "env:\n  - variable: A\n")
+			require.ErrorContains(s.T(), err, "neither 'value' nor 'secret'")
+		})
+	}
+}
+
+func (s *rendererSuite) TestRenderManifestDependencies() {
+	render := func(options RenderOptions) (string, error) {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte("dependencies:\n  - service: user-api\n")},
+			writeFiles: map[string][]byte{},
+		}
+		options.ConfigPath = "config.yaml"
+		options.ServiceName = "frontend"
+		options.Region = "us-central1"
+		options.Image = "example.com/frontend@" + testDigest
+		options.OutputPath = "manifest"
+		err := NewRenderer(fileIO).RenderManifest(options)
+		return string(fileIO.writeFiles["manifest"]), err
+	}
+
+	s.Run("takes the project number from the options", func() {
+		output, err := render(RenderOptions{ProjectNumber: "123456789"})
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, "name: USER_API_URL\n          value: https://user-api-123456789.us-central1.run.app")
+
+		output, err = render(RenderOptions{ProjectNumber: "123456789", Format: "v2-json"})
+		require.NoError(s.T(), err)
+		require.Contains(s.T(), output, `"value": "https://user-api-123456789.us-central1.run.app"`)
+	})
+
+	s.Run("requires a project number", func() {
+		_, err := render(RenderOptions{})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "project number")
+	})
+}
+
+func (s *rendererSuite) TestRenderManifestDomainMappings() {
+	render := func(config string, options RenderOptions) (*fakeFileIO, error) {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte(config)},
+			writeFiles: map[string][]byte{},
+		}
+		options.ConfigPath = "config.yaml"
+		options.ServiceName = "myapp"
+		options.Region = "us-central1"
+		options.Image = "example.com/myapp@" + testDigest
+		options.OutputPath = "manifest.yaml"
+		options.DomainMappingsPath = "domains.yaml"
+		return fileIO, NewRenderer(fileIO).RenderManifest(options)
+	}
+
+	s.Run("writes one mapping per domain", func() {
+		fileIO, err := render("domains:\n  - api.example.com\n  - www.example.com\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		domains := string(fileIO.writeFiles["domains.yaml"])
+		require.Equal(s.T(), 1, strings.Count(domains, "---\n"))
+		require.Contains(s.T(), domains, "name: api.example.com")
+		require.Contains(s.T(), domains, "name: www.example.com")
+		require.Equal(s.T(), 2, strings.Count(domains, "routeName: myapp"))
+		require.NotContains(s.T(), string(fileIO.writeFiles["manifest.yaml"]), "DomainMapping")
+	})
+
+	s.Run("writes an empty file without domains", func() {
+		fileIO, err := render("runConfig: {}\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		domains, written := fileIO.writeFiles["domains.yaml"]
+		require.True(s.T(), written)
+		require.Empty(s.T(), domains)
+	})
+
+	s.Run("rejects multi-region deploys", func() {
+		_, err := render("domains:\n  - api.example.com\n", RenderOptions{Regions: []string{"us-central1", "europe-west1"}})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "multi-region")
+	})
+
+	s.Run("rejects domains on jobs", func() {
+		_, err := render("domains:\n  - api.example.com\n", RenderOptions{ResourceType: "job"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "only supported for services")
+	})
+}
+
+func (s *rendererSuite) TestRenderManifestIAMPolicy() {
+	render := func(config string, options RenderOptions) (*fakeFileIO, error) {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte(config)},
+			writeFiles: map[string][]byte{},
+		}
+		options.ConfigPath = "config.yaml"
+		options.ServiceName = "myapp"
+		options.Region = "us-central1"
+		options.Image = "example.com/myapp@" + testDigest
+		options.OutputPath = "manifest.yaml"
+		options.IAMPolicyPath = "iam.yaml"
+		return fileIO, NewRenderer(fileIO).RenderManifest(options)
+	}
+
+	s.Run("writes the invoker binding", func() {
+		fileIO, err := render("iam:\n  invokers:\n    - serviceAccount:scheduler@proj.iam.gserviceaccount.com\n", RenderOptions{ResourceType: "job"})
+		require.NoError(s.T(), err)
+		policy := string(fileIO.writeFiles["iam.yaml"])
+		require.Contains(s.T(), policy, "  - serviceAccount:scheduler@proj.iam.gserviceaccount.com\n")
+		require.Contains(s.T(), policy, "  role: roles/run.invoker\n")
+	})
+
+	s.Run("writes an empty file without iam", func() {
+		fileIO, err := render("runConfig: {}\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		policy, written := fileIO.writeFiles["iam.yaml"]
+		require.True(s.T(), written)
+		require.Empty(s.T(), policy)
+	})
+
+	s.Run("rejects invalid members", func() {
+		_, err := render("iam:\n  invokers: [group:eng@]\n", RenderOptions{})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "iam.invokers[0]")
+	})
+
+	s.Run("rejects iam on worker pools", func() {
+		_, err := render("iam:\n  invokers: [allUsers]\n", RenderOptions{ResourceType: "worker"})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "only supported for services and jobs")
+	})
+}
+
+func (s *rendererSuite) TestRenderManifestDeploySettings() {
+	render := func(config string, options RenderOptions) (*fakeFileIO, error) {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte(config)},
+			writeFiles: map[string][]byte{},
+		}
+		options.ConfigPath = "config.yaml"
+		options.ServiceName = "myapp"
+		options.Region = "us-central1"
+		options.Image = "example.com/myapp@" + testDigest
+		options.OutputPath = "manifest.yaml"
+		options.DeploySettingsPath = "deploy.yaml"
+		return fileIO, NewRenderer(fileIO).RenderManifest(options)
+	}
+
+	s.Run("writes the verification settings with defaults", func() {
+		fileIO, err := render("deploy:\n  verify:\n    path: /healthz\n    timeoutSeconds: 120\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), "verify:\n  path: /healthz\n  rollback: true\n  tag: verify\n  timeoutSeconds: 120\n", string(fileIO.writeFiles["deploy.yaml"]))
+	})
+
+	s.Run("writes an empty file without a deploy section", func() {
+		fileIO, err := render("runConfig: {}\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		settings, written := fileIO.writeFiles["deploy.yaml"]
+		require.True(s.T(), written)
+		require.Empty(s.T(), settings)
+	})
+
+	s.Run("rejects verification of jobs and multi-region services", func() {
+		_, err := render("deploy:\n  verify: {}\n", RenderOptions{ResourceType: "job"})
+		require.ErrorContains(s.T(), err, "deploy.verify is only supported for services, not jobs")
+
+		_, err = render("deploy:\n  verify: {}\n", RenderOptions{Regions: []string{"us-central1", "europe-west1"}})
+		require.ErrorContains(s.T(), err, "not supported for multi-region services")
+	})
+	s.Run("writes the rollout plan", func() {
+		config := `rollout:
+  steps:
+  - percent: 5
+    bakeSeconds: 600
+  - percent: 100
+  gate:
+    command: [./scripts/check.sh]
+`
+		fileIO, err := render(config, RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), `rollout:
+  gate:
+    command:
+    - ./scripts/check.sh
+  steps:
+  - bakeSeconds: 600
+    percent: 5
+  - percent: 100
+  tag: canary
+`, string(fileIO.writeFiles["deploy.yaml"]))
 	})
 
-	s.Run("returns raw value when no delimiter", func() {
-		require.Equal(s.T(), "API_KEY", secretNameFromReference("API_KEY"))
+	s.Run("rejects rollouts of workers", func() {
+		_, err := render("rollout:\n  steps:\n  - percent: 100\n", RenderOptions{ResourceType: "worker"})
+		require.ErrorContains(s.T(), err, "rollout is only supported for services, not workers")
 	})
 }
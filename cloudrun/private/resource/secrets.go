@@ -0,0 +1,97 @@
+package resource
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+const (
+	// Values shorter than this are too short for the entropy heuristic to
+	// tell a token from an ordinary identifier.
+	secretEntropyMinLength = 24
+	// Shannon entropy, in bits per character, above which a token-shaped
+	// value is treated as random key material.
+	secretEntropyThreshold = 4.0
+	// Hex digits carry at most 4 bits per character, so random hex keys sit
+	// well below secretEntropyThreshold and get a lower bar of their own.
+	// The longer minimum keeps short hex identifiers out.
+	hexSecretEntropyMinLength = 32
+	hexSecretEntropyThreshold = 3.0
+)
+
+// knownSecretPatterns match credential formats with a recognizable prefix.
+var knownSecretPatterns = []struct {
+	description string
+	pattern     *regexp.Regexp
+}{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{20,}\b|\bgithub_pat_[A-Za-z0-9_]{20,}`)},
+	{"API secret key", regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"PEM block", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----`)},
+}
+
+var (
+	secretTokenPattern  = regexp.MustCompile(`^[A-Za-z0-9+/=_-]+$`)
+	secretHexPattern    = regexp.MustCompile(`^[0-9A-Fa-f]+$`)
+	secretLetterPattern = regexp.MustCompile(`[A-Za-z]`)
+	secretDigitPattern  = regexp.MustCompile(`[0-9]`)
+)
+
+// validatePlaintextEnv refuses env values that look like credentials unless
+// the entry opts out with allowPlaintext. Rendered values end up verbatim in
+// the manifest and in the generated deploy script.
+func validatePlaintextEnv(entries []envEntry) error {
+	for _, entry := range entries {
+		if entry.Value == nil || entry.AllowPlaintext {
+			continue
+		}
+		if reason, found := detectPlaintextSecret(*entry.Value); found {
+			return fmt.Errorf("env %q value looks like a plaintext secret (%s); reference it from Secret Manager with 'secret:' or set 'allowPlaintext: true' if it is not sensitive",
+				entry.Variable, reason)
+		}
+	}
+	return nil
+}
+
+// detectPlaintextSecret reports whether value resembles a credential and
+// describes why.
+func detectPlaintextSecret(value string) (string, bool) {
+	for _, known := range knownSecretPatterns {
+		if known.pattern.MatchString(value) {
+			return known.description, true
+		}
+	}
+
+	if !secretTokenPattern.MatchString(value) ||
+		!secretLetterPattern.MatchString(value) ||
+		!secretDigitPattern.MatchString(value) {
+		return "", false
+	}
+	minLength, threshold, charset := secretEntropyMinLength, secretEntropyThreshold, "string"
+	if secretHexPattern.MatchString(value) {
+		minLength, threshold, charset = hexSecretEntropyMinLength, hexSecretEntropyThreshold, "hex string"
+	}
+	if len(value) >= minLength {
+		if entropy := shannonEntropy(value); entropy >= threshold {
+			return fmt.Sprintf("high-entropy %s, %.1f bits per character", charset, entropy), true
+		}
+	}
+	return "", false
+}
+
+func shannonEntropy(value string) float64 {
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range value {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,105 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Terraform resource types emitted for each Cloud Run resource type.
+const (
+	terraformServiceType    = "google_cloud_run_v2_service"
+	terraformJobType        = "google_cloud_run_v2_job"
+	terraformWorkerPoolType = "google_cloud_run_v2_worker_pool"
+)
+
+// renderTerraformManifest renders the config as a Terraform JSON (.tf.json)
+// document containing a single google_cloud_run_v2_* resource block.
+//
+// The google provider's v2 resources follow the Admin API v2 shape with
+// snake_case attribute names, so the block is derived from the v2 translation
+// rather than maintained as a third set of types.
+func renderTerraformManifest(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	body, err := terraformResourceBody(buildV2Resource(config, options))
+	if err != nil {
+		return nil, err
+	}
+	body["location"] = options.Region
+
+	document := map[string]interface{}{
+		"resource": map[string]interface{}{
+			terraformResourceType(options.ResourceType): map[string]interface{}{
+				terraformResourceName(options.ServiceName): body,
+			},
+		},
+	}
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal terraform %s: %w", options.ResourceType, err)
+	}
+	return append(content, '\n'), nil
+}
+
+func terraformResourceBody(resource interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(resource)
+	if err != nil {
+		return nil, fmt.Errorf("marshal v2 resource: %w", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("decode v2 resource: %w", err)
+	}
+	return snakeCaseKeys(body).(map[string]interface{}), nil
+}
+
+func terraformResourceType(resourceType string) string {
+	switch resourceType {
+	case resourceTypeJob:
+		return terraformJobType
+	case resourceTypeWorker:
+		return terraformWorkerPoolType
+	default:
+		return terraformServiceType
+	}
+}
+
+// terraformResourceName converts a Cloud Run resource name into a Terraform
+// resource label (my-app -> my_app).
+func terraformResourceName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// snakeCaseKeys recursively renames object keys from the v2 API's camelCase
+// to Terraform's snake_case. Values are left untouched.
+func snakeCaseKeys(node interface{}) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			converted[snakeCase(key)] = snakeCaseKeys(child)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = snakeCaseKeys(item)
+		}
+		return v
+	default:
+		return node
+	}
+}
+
+func snakeCase(key string) string {
+	var builder strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				builder.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -0,0 +1,96 @@
+package resource
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files under testdata/")
+
+const terraformGoldenConfig = `
+runConfig:
+  cpu: 2
+  memoryMiB: 1024
+  minInstances: 1
+  maxInstances: 5
+  concurrency: 80
+  taskCount: 4
+  parallelism: 2
+  maxRetries: 1
+  timeoutSeconds: 600
+  network: default
+  subnet: app-subnet
+  vpcEgress: private-ranges-only
+  livenessProbe:
+    periodSeconds: 15
+    httpGet:
+      path: /healthz
+      port: 8080
+      httpHeaders:
+        - name: X-Probe
+          value: liveness
+  startupProbe:
+    tcpSocket:
+      port: 8080
+serviceAccount: app@project.iam.gserviceaccount.com
+cloudsqlConnector: project:region:instance
+env:
+  - variable: LOG_LEVEL
+    value: info
+  - variable: API_KEY
+    secret: projects/123456789/secrets/API_KEY
+`
+
+func (s *rendererSuite) TestRenderTerraformManifest() {
+	cases := []struct {
+		resourceType string
+		name         string
+		golden       string
+	}{
+		{resourceType: "service", name: "my-app", golden: "service.tf.json"},
+		{resourceType: "job", name: "my-job", golden: "job.tf.json"},
+		{resourceType: "worker", name: "my-worker", golden: "worker.tf.json"},
+	}
+
+	for _, tc := range cases {
+		s.Run("matches golden "+tc.golden, func() {
+			fileIO := &fakeFileIO{
+				readFiles:  map[string][]byte{"config.yaml": []byte(terraformGoldenConfig)},
+				writeFiles: map[string][]byte{},
+			}
+
+			renderer := NewRenderer(fileIO)
+			err := renderer.RenderManifest(RenderOptions{
+				ConfigPath:     "config.yaml",
+				ServiceName:    tc.name,
+				Region:         "us-central1",
+				Image:          "example.com/app@" + testDigest,
+				ResourceType:   tc.resourceType,
+				TimeoutSeconds: 300,
+				OutputPath:     "main.tf.json",
+				Format:         "terraform-json",
+			})
+			require.NoError(s.T(), err)
+
+			actual := fileIO.writeFiles["main.tf.json"]
+			goldenPath := filepath.Join("testdata", "terraform", tc.golden)
+			if *updateGolden {
+				require.NoError(s.T(), os.WriteFile(goldenPath, actual, 0o644))
+			}
+			expected, err := os.ReadFile(goldenPath)
+			require.NoError(s.T(), err)
+			require.Equal(s.T(), string(expected), string(actual))
+		})
+	}
+}
+
+func (s *rendererSuite) TestSnakeCase() {
+	s.Run("converts camelCase keys", func() {
+		require.Equal(s.T(), "max_instance_request_concurrency", snakeCase("maxInstanceRequestConcurrency"))
+		require.Equal(s.T(), "cloud_sql_instance", snakeCase("cloudSqlInstance"))
+		require.Equal(s.T(), "image", snakeCase("image"))
+	})
+}
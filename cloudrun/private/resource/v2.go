@@ -0,0 +1,620 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+)
+
+// Cloud Run Admin API v2 resource shapes. These mirror the REST
+// representation of Service, Job and WorkerPool closely enough for IaC tools
+// (Terraform, Pulumi) to consume them; fields rules_cloudrun never sets are
+// omitted.
+
+const (
+	v2CloudSQLVolumeName = "cloudsql"
+	v2CloudSQLMountPath  = "/cloudsql"
+)
+
+// v2IngressValues maps the Knative ingress annotation values to the v2 enum.
+var v2IngressValues = map[string]string{
+	"all":                               "INGRESS_TRAFFIC_ALL",
+	"internal":                          "INGRESS_TRAFFIC_INTERNAL_ONLY",
+	"internal-and-cloud-load-balancing": "INGRESS_TRAFFIC_INTERNAL_LOAD_BALANCER",
+}
+
+type v2Service struct {
+	Name                string                 `json:"name"`
+	LaunchStage         string                 `json:"launchStage,omitempty"`
+	Ingress             string                 `json:"ingress,omitempty"`
+	Scaling             *v2Scaling             `json:"scaling,omitempty"`
+	BinaryAuthorization *v2BinaryAuthorization `json:"binaryAuthorization,omitempty"`
+	Template            v2RevisionTemplate     `json:"template"`
+}
+
+type v2BinaryAuthorization struct {
+	UseDefault              bool   `json:"useDefault,omitempty"`
+	Policy                  string `json:"policy,omitempty"`
+	BreakglassJustification string `json:"breakglassJustification,omitempty"`
+}
+
+type v2Scaling struct {
+	MinInstanceCount *int `json:"minInstanceCount,omitempty"`
+	MaxInstanceCount *int `json:"maxInstanceCount,omitempty"`
+}
+
+type v2RevisionTemplate struct {
+	ExecutionEnvironment          string         `json:"executionEnvironment,omitempty"`
+	Scaling                       *v2Scaling     `json:"scaling,omitempty"`
+	ServiceAccount                string         `json:"serviceAccount,omitempty"`
+	Timeout                       string         `json:"timeout,omitempty"`
+	MaxInstanceRequestConcurrency *int           `json:"maxInstanceRequestConcurrency,omitempty"`
+	VPCAccess                     *v2VPCAccess   `json:"vpcAccess,omitempty"`
+	ServiceMesh                   *v2ServiceMesh `json:"serviceMesh,omitempty"`
+	EncryptionKey                 string         `json:"encryptionKey,omitempty"`
+	EncryptionKeyRevocationAction string         `json:"encryptionKeyRevocationAction,omitempty"`
+	EncryptionKeyShutdownDuration string         `json:"encryptionKeyShutdownDuration,omitempty"`
+	Volumes                       []v2Volume     `json:"volumes,omitempty"`
+	Containers                    []v2Container  `json:"containers"`
+}
+
+type v2ServiceMesh struct {
+	Mesh string `json:"mesh"`
+}
+
+type v2Job struct {
+	Name                string                 `json:"name"`
+	LaunchStage         string                 `json:"launchStage,omitempty"`
+	BinaryAuthorization *v2BinaryAuthorization `json:"binaryAuthorization,omitempty"`
+	Template            v2ExecutionTemplate    `json:"template"`
+}
+
+type v2ExecutionTemplate struct {
+	TaskCount   int            `json:"taskCount"`
+	Parallelism *int           `json:"parallelism,omitempty"`
+	Template    v2TaskTemplate `json:"template"`
+}
+
+type v2TaskTemplate struct {
+	ServiceAccount string        `json:"serviceAccount,omitempty"`
+	Timeout        string        `json:"timeout,omitempty"`
+	MaxRetries     *int          `json:"maxRetries,omitempty"`
+	VPCAccess      *v2VPCAccess  `json:"vpcAccess,omitempty"`
+	EncryptionKey  string        `json:"encryptionKey,omitempty"`
+	Volumes        []v2Volume    `json:"volumes,omitempty"`
+	Containers     []v2Container `json:"containers"`
+}
+
+type v2WorkerPool struct {
+	Name                string                       `json:"name"`
+	LaunchStage         string                       `json:"launchStage,omitempty"`
+	Scaling             *v2WorkerPoolScaling         `json:"scaling,omitempty"`
+	InstanceSplits      []v2InstanceSplit            `json:"instanceSplits,omitempty"`
+	BinaryAuthorization *v2BinaryAuthorization       `json:"binaryAuthorization,omitempty"`
+	Template            v2WorkerPoolRevisionTemplate `json:"template"`
+}
+
+type v2WorkerPoolScaling struct {
+	ScalingMode         string `json:"scalingMode,omitempty"`
+	MinInstanceCount    *int   `json:"minInstanceCount,omitempty"`
+	MaxInstanceCount    *int   `json:"maxInstanceCount,omitempty"`
+	ManualInstanceCount *int   `json:"manualInstanceCount,omitempty"`
+}
+
+type v2InstanceSplit struct {
+	Type     string `json:"type"`
+	Revision string `json:"revision,omitempty"`
+	Percent  int    `json:"percent"`
+}
+
+type v2WorkerPoolRevisionTemplate struct {
+	ServiceAccount                string        `json:"serviceAccount,omitempty"`
+	VPCAccess                     *v2VPCAccess  `json:"vpcAccess,omitempty"`
+	EncryptionKey                 string        `json:"encryptionKey,omitempty"`
+	EncryptionKeyRevocationAction string        `json:"encryptionKeyRevocationAction,omitempty"`
+	EncryptionKeyShutdownDuration string        `json:"encryptionKeyShutdownDuration,omitempty"`
+	Volumes                       []v2Volume    `json:"volumes,omitempty"`
+	Containers                    []v2Container `json:"containers"`
+}
+
+type v2VPCAccess struct {
+	Connector         string               `json:"connector,omitempty"`
+	Egress            string               `json:"egress,omitempty"`
+	NetworkInterfaces []v2NetworkInterface `json:"networkInterfaces,omitempty"`
+}
+
+type v2NetworkInterface struct {
+	Network    string `json:"network"`
+	Subnetwork string `json:"subnetwork"`
+}
+
+type v2Volume struct {
+	Name             string              `json:"name"`
+	CloudSQLInstance *v2CloudSQLInstance `json:"cloudSqlInstance,omitempty"`
+	Secret           *v2SecretVolume     `json:"secret,omitempty"`
+}
+
+type v2SecretVolume struct {
+	Secret string            `json:"secret"`
+	Items  []v2VersionToPath `json:"items"`
+}
+
+type v2VersionToPath struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+}
+
+type v2CloudSQLInstance struct {
+	Instances []string `json:"instances"`
+}
+
+type v2Container struct {
+	Image          string                 `json:"image"`
+	Command        []string               `json:"command,omitempty"`
+	Args           []string               `json:"args,omitempty"`
+	WorkingDir     string                 `json:"workingDir,omitempty"`
+	Ports          []v2ContainerPort      `json:"ports,omitempty"`
+	Resources      v2ResourceRequirements `json:"resources"`
+	Env            []v2EnvVar             `json:"env,omitempty"`
+	VolumeMounts   []v2VolumeMount        `json:"volumeMounts,omitempty"`
+	LivenessProbe  *v2Probe               `json:"livenessProbe,omitempty"`
+	ReadinessProbe *v2Probe               `json:"readinessProbe,omitempty"`
+	StartupProbe   *v2Probe               `json:"startupProbe,omitempty"`
+}
+
+type v2ContainerPort struct {
+	Name          string `json:"name,omitempty"`
+	ContainerPort int32  `json:"containerPort"`
+}
+
+type v2ResourceRequirements struct {
+	Limits map[string]string `json:"limits"`
+}
+
+type v2EnvVar struct {
+	Name        string         `json:"name"`
+	Value       string         `json:"value,omitempty"`
+	ValueSource *v2ValueSource `json:"valueSource,omitempty"`
+}
+
+type v2ValueSource struct {
+	SecretKeyRef *v2SecretKeySelector `json:"secretKeyRef"`
+}
+
+type v2SecretKeySelector struct {
+	Secret  string `json:"secret"`
+	Version string `json:"version"`
+}
+
+type v2VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+type v2Probe struct {
+	InitialDelaySeconds *int32             `json:"initialDelaySeconds,omitempty"`
+	TimeoutSeconds      *int32             `json:"timeoutSeconds,omitempty"`
+	PeriodSeconds       *int32             `json:"periodSeconds,omitempty"`
+	SuccessThreshold    *int32             `json:"successThreshold,omitempty"`
+	FailureThreshold    *int32             `json:"failureThreshold,omitempty"`
+	HTTPGet             *v2HTTPGetAction   `json:"httpGet,omitempty"`
+	TCPSocket           *v2TCPSocketAction `json:"tcpSocket,omitempty"`
+	GRPC                *v2GRPCAction      `json:"grpc,omitempty"`
+}
+
+type v2HTTPGetAction struct {
+	Path        string         `json:"path,omitempty"`
+	Port        *int32         `json:"port,omitempty"`
+	HTTPHeaders []v2HTTPHeader `json:"httpHeaders,omitempty"`
+}
+
+type v2HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type v2TCPSocketAction struct {
+	Port *int32 `json:"port,omitempty"`
+}
+
+type v2GRPCAction struct {
+	Port    *int32 `json:"port,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// renderV2Manifest translates the config into the Cloud Run Admin API v2
+// resource for the requested type and marshals it to indented JSON.
+func renderV2Manifest(config appHostingConfig, options RenderOptions) ([]byte, error) {
+	resource := buildV2Resource(config, options)
+	content, err := json.MarshalIndent(resource, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal v2 %s: %w", options.ResourceType, err)
+	}
+	return append(content, '\n'), nil
+}
+
+func buildV2Resource(config appHostingConfig, options RenderOptions) interface{} {
+	switch options.ResourceType {
+	case resourceTypeJob:
+		return buildV2Job(config, options)
+	case resourceTypeWorker:
+		return buildV2WorkerPool(config, options)
+	default:
+		return buildV2Service(config, options)
+	}
+}
+
+func buildV2Service(config appHostingConfig, options RenderOptions) *v2Service {
+	timeout := options.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = defaultTimeoutSeconds
+	}
+
+	volumes, mounts := buildV2Volumes(config)
+	container := buildV2Container(config, options, mounts)
+	container.Ports = buildV2Ports(config.RunConfig.Port)
+	container.LivenessProbe = buildV2Probe(config.RunConfig.LivenessProbe)
+	container.ReadinessProbe = buildV2Probe(config.RunConfig.ReadinessProbe)
+	container.StartupProbe = buildV2Probe(config.RunConfig.StartupProbe)
+
+	// The v2 API has no CPU utilization target; collectWarnings reports it
+	// as dropped.
+	scaling := config.RunConfig.ServiceScaling()
+	key, revocationAction, shutdown := v2Encryption(config.Security)
+	return &v2Service{
+		Name:                options.ServiceName,
+		LaunchStage:         v2LaunchStage(config),
+		Ingress:             v2Ingress(config.RunConfig.Ingress),
+		Scaling:             buildV2Scaling(scaling.MinInstances, scaling.MaxInstances),
+		BinaryAuthorization: buildV2BinaryAuthorization(config.Security),
+		Template: v2RevisionTemplate{
+			ExecutionEnvironment:          "EXECUTION_ENVIRONMENT_GEN2",
+			Scaling:                       buildV2Scaling(scaling.RevisionMinInstances, scaling.RevisionMaxInstances),
+			ServiceAccount:                config.ServiceAccount,
+			Timeout:                       v2Duration(timeout),
+			MaxInstanceRequestConcurrency: config.RunConfig.Concurrency,
+			VPCAccess:                     buildV2VPCAccess(config.RunConfig),
+			ServiceMesh:                   buildV2ServiceMesh(config.RunConfig.Mesh),
+			EncryptionKey:                 key,
+			EncryptionKeyRevocationAction: revocationAction,
+			EncryptionKeyShutdownDuration: shutdown,
+			Volumes:                       volumes,
+			Containers:                    []v2Container{container},
+		},
+	}
+}
+
+func buildV2Job(config appHostingConfig, options RenderOptions) *v2Job {
+	taskCount := defaultTaskCount
+	if config.RunConfig.TaskCount != nil {
+		taskCount = *config.RunConfig.TaskCount
+	}
+
+	volumes, mounts := buildV2Volumes(config)
+	key, _, _ := v2Encryption(config.Security)
+	taskTemplate := v2TaskTemplate{
+		ServiceAccount: config.ServiceAccount,
+		MaxRetries:     config.RunConfig.MaxRetries,
+		VPCAccess:      buildV2VPCAccess(config.RunConfig),
+		EncryptionKey:  key,
+		Volumes:        volumes,
+		Containers:     []v2Container{buildV2Container(config, options, mounts)},
+	}
+	if config.RunConfig.TimeoutSeconds != nil {
+		taskTemplate.Timeout = v2Duration(*config.RunConfig.TimeoutSeconds)
+	}
+
+	return &v2Job{
+		Name:                options.ServiceName,
+		BinaryAuthorization: buildV2BinaryAuthorization(config.Security),
+		Template: v2ExecutionTemplate{
+			TaskCount:   taskCount,
+			Parallelism: config.RunConfig.Parallelism,
+			Template:    taskTemplate,
+		},
+	}
+}
+
+func buildV2WorkerPool(config appHostingConfig, options RenderOptions) *v2WorkerPool {
+	volumes, mounts := buildV2Volumes(config)
+	container := buildV2Container(config, options, mounts)
+	container.LivenessProbe = buildV2Probe(config.RunConfig.LivenessProbe)
+	container.ReadinessProbe = buildV2Probe(config.RunConfig.ReadinessProbe)
+	container.StartupProbe = buildV2Probe(config.RunConfig.StartupProbe)
+
+	key, revocationAction, shutdown := v2Encryption(config.Security)
+	return &v2WorkerPool{
+		Name:                options.ServiceName,
+		LaunchStage:         v2LaunchStage(config),
+		Scaling:             buildV2WorkerPoolScaling(config.RunConfig),
+		InstanceSplits:      buildV2InstanceSplits(config.RunConfig.InstanceSplits),
+		BinaryAuthorization: buildV2BinaryAuthorization(config.Security),
+		Template: v2WorkerPoolRevisionTemplate{
+			ServiceAccount:                config.ServiceAccount,
+			VPCAccess:                     buildV2VPCAccess(config.RunConfig),
+			EncryptionKey:                 key,
+			EncryptionKeyRevocationAction: revocationAction,
+			EncryptionKeyShutdownDuration: shutdown,
+			Volumes:                       volumes,
+			Containers:                    []v2Container{container},
+		},
+	}
+}
+
+func buildV2Container(config appHostingConfig, options RenderOptions, mounts []v2VolumeMount) v2Container {
+	cpu := defaultCPU
+	if config.RunConfig.CPU != nil {
+		cpu = *config.RunConfig.CPU
+	}
+	memoryMiB := defaultMemoryMiB
+	if config.RunConfig.MemoryMiB != nil {
+		memoryMiB = *config.RunConfig.MemoryMiB
+	}
+
+	return v2Container{
+		Image: options.Image,
+		Resources: v2ResourceRequirements{
+			Limits: map[string]string{
+				"cpu":    strconv.Itoa(cpu),
+				"memory": fmt.Sprintf("%dMi", memoryMiB),
+			},
+		},
+		Command:      config.RunConfig.Command,
+		Args:         config.RunConfig.Args,
+		WorkingDir:   config.RunConfig.WorkingDir,
+		Env:          buildV2EnvironmentVariables(config.Env),
+		VolumeMounts: mounts,
+	}
+}
+
+func buildV2Ports(port *manifest.Port) []v2ContainerPort {
+	if port == nil {
+		return nil
+	}
+	number := manifest.DefaultPort
+	if port.Number != nil {
+		number = *port.Number
+	}
+	return []v2ContainerPort{{Name: port.Name, ContainerPort: number}}
+}
+
+func buildV2EnvironmentVariables(entries []envEntry) []v2EnvVar {
+	envVars := make([]v2EnvVar, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Value != nil {
+			envVars = append(envVars, v2EnvVar{
+				Name:  entry.Variable,
+				Value: *entry.Value,
+			})
+			continue
+		}
+		// v2 accepts the full projects/<num>/secrets/<name> reference, which
+		// also keeps cross-project secrets resolvable.
+		envVars = append(envVars, v2EnvVar{
+			Name: entry.Variable,
+			ValueSource: &v2ValueSource{
+				SecretKeyRef: &v2SecretKeySelector{
+					Secret:  entry.Secret,
+					Version: manifest.SecretVersion(entry.Version),
+				},
+			},
+		})
+	}
+	return envVars
+}
+
+func buildV2Probe(p *probeEntry) *v2Probe {
+	if p == nil {
+		return nil
+	}
+	probe := &v2Probe{
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		SuccessThreshold:    p.SuccessThreshold,
+		FailureThreshold:    p.FailureThreshold,
+	}
+
+	if p.HTTPGet != nil {
+		probe.HTTPGet = &v2HTTPGetAction{
+			Path: p.HTTPGet.Path,
+			Port: p.HTTPGet.Port,
+		}
+		for _, h := range p.HTTPGet.HTTPHeaders {
+			probe.HTTPGet.HTTPHeaders = append(probe.HTTPGet.HTTPHeaders, v2HTTPHeader{
+				Name:  h.Name,
+				Value: h.Value,
+			})
+		}
+	} else if p.TCPSocket != nil {
+		probe.TCPSocket = &v2TCPSocketAction{
+			Port: p.TCPSocket.Port,
+		}
+	} else if p.GRPC != nil {
+		probe.GRPC = &v2GRPCAction{
+			Port:    p.GRPC.Port,
+			Service: p.GRPC.Service,
+		}
+	}
+	return probe
+}
+
+func buildV2Scaling(minInstances, maxInstances *int) *v2Scaling {
+	if minInstances == nil && maxInstances == nil {
+		return nil
+	}
+	return &v2Scaling{
+		MinInstanceCount: minInstances,
+		MaxInstanceCount: maxInstances,
+	}
+}
+
+// buildV2WorkerPoolScaling maps the worker scaling settings to the v2
+// scaling block. The mode is only written when the config names it or
+// implies manual scaling, as the Knative annotations are.
+func buildV2WorkerPoolScaling(runConfig runConfigEntry) *v2WorkerPoolScaling {
+	mode := runConfig.WorkerScalingMode()
+	if mode == "" {
+		return nil
+	}
+	scaling := &v2WorkerPoolScaling{
+		MinInstanceCount:    runConfig.MinInstances,
+		MaxInstanceCount:    runConfig.MaxInstances,
+		ManualInstanceCount: runConfig.InstanceCount,
+	}
+	if mode == manifest.ScalingModeManual || runConfig.ScalingMode != "" {
+		scaling.ScalingMode = strings.ToUpper(mode)
+	}
+	return scaling
+}
+
+func buildV2InstanceSplits(splits []manifest.InstanceSplit) []v2InstanceSplit {
+	var v2Splits []v2InstanceSplit
+	for _, split := range splits {
+		v2Split := v2InstanceSplit{
+			Type:     "INSTANCE_SPLIT_ALLOCATION_TYPE_REVISION",
+			Revision: split.Revision,
+			Percent:  split.Percent,
+		}
+		if split.Latest {
+			v2Split.Type = "INSTANCE_SPLIT_ALLOCATION_TYPE_LATEST"
+		}
+		v2Splits = append(v2Splits, v2Split)
+	}
+	return v2Splits
+}
+
+func buildV2VPCAccess(runConfig runConfigEntry) *v2VPCAccess {
+	access := &v2VPCAccess{
+		Connector: runConfig.VPCConnector,
+		Egress:    v2VPCEgress(runConfig.VPCEgress),
+	}
+	if runConfig.Network != "" && runConfig.Subnet != "" {
+		access.NetworkInterfaces = []v2NetworkInterface{{
+			Network:    runConfig.Network,
+			Subnetwork: runConfig.Subnet,
+		}}
+	}
+	if access.Connector == "" && access.Egress == "" && len(access.NetworkInterfaces) == 0 {
+		return nil
+	}
+	return access
+}
+
+// buildV2Volumes returns the Cloud SQL volume followed by the secret
+// volumes, with their container mounts.
+func buildV2Volumes(config appHostingConfig) ([]v2Volume, []v2VolumeMount) {
+	volumes, mounts := buildV2CloudSQLVolumes(config.CloudSQLConnector)
+	names := manifest.SecretVolumeNames(config.SecretVolumes)
+	for i, volume := range config.SecretVolumes {
+		fileName := volume.FileName
+		if fileName == "" {
+			fileName = volume.Secret[strings.LastIndex(volume.Secret, "/")+1:]
+		}
+		// Like env, v2 takes the full secret reference, so cross-project
+		// secrets need no alias.
+		volumes = append(volumes, v2Volume{
+			Name: names[i],
+			Secret: &v2SecretVolume{
+				Secret: volume.Secret,
+				Items: []v2VersionToPath{{
+					Version: manifest.SecretVersion(volume.Version),
+					Path:    fileName,
+				}},
+			},
+		})
+		mounts = append(mounts, v2VolumeMount{
+			Name:      names[i],
+			MountPath: volume.MountPath,
+		})
+	}
+	return volumes, mounts
+}
+
+func buildV2CloudSQLVolumes(connector string) ([]v2Volume, []v2VolumeMount) {
+	if connector == "" {
+		return nil, nil
+	}
+	var instances []string
+	for _, instance := range strings.Split(connector, ",") {
+		if instance = strings.TrimSpace(instance); instance != "" {
+			instances = append(instances, instance)
+		}
+	}
+	volumes := []v2Volume{{
+		Name:             v2CloudSQLVolumeName,
+		CloudSQLInstance: &v2CloudSQLInstance{Instances: instances},
+	}}
+	mounts := []v2VolumeMount{{
+		Name:      v2CloudSQLVolumeName,
+		MountPath: v2CloudSQLMountPath,
+	}}
+	return volumes, mounts
+}
+
+func buildV2BinaryAuthorization(security *manifest.Security) *v2BinaryAuthorization {
+	if security == nil || security.BinaryAuthorization == "" {
+		return nil
+	}
+	binaryAuthorization := &v2BinaryAuthorization{
+		Policy:                  security.BinaryAuthorization,
+		BreakglassJustification: security.BreakglassJustification,
+	}
+	if security.BinaryAuthorization == manifest.BinaryAuthorizationDefault {
+		binaryAuthorization.UseDefault = true
+		binaryAuthorization.Policy = ""
+	}
+	return binaryAuthorization
+}
+
+// v2Encryption returns the encryption key of the security section with its
+// revocation action and shutdown duration in the v2 shape.
+func v2Encryption(security *manifest.Security) (key, revocationAction, shutdown string) {
+	if security == nil {
+		return "", "", ""
+	}
+	if security.KeyRevocationAction != "" {
+		revocationAction = manifest.KeyRevocationActionType(security.KeyRevocationAction)
+	}
+	if security.KeyShutdownHours != nil {
+		shutdown = v2Duration(*security.KeyShutdownHours * 3600)
+	}
+	return security.EncryptionKey, revocationAction, shutdown
+}
+
+func buildV2ServiceMesh(mesh string) *v2ServiceMesh {
+	if mesh == "" {
+		return nil
+	}
+	return &v2ServiceMesh{Mesh: mesh}
+}
+
+// v2VPCEgress maps the Knative annotation value (all-traffic,
+// private-ranges-only) to the v2 enum (ALL_TRAFFIC, PRIVATE_RANGES_ONLY).
+func v2VPCEgress(egress string) string {
+	if egress == "" {
+		return ""
+	}
+	return strings.ToUpper(strings.ReplaceAll(egress, "-", "_"))
+}
+
+func v2Ingress(ingress string) string {
+	if ingress == "" {
+		ingress = defaultIngress
+	}
+	return v2IngressValues[ingress]
+}
+
+func v2LaunchStage(config appHostingConfig) string {
+	if config.RunConfig.LivenessProbe != nil || config.RunConfig.ReadinessProbe != nil || config.RunConfig.StartupProbe != nil {
+		return "BETA"
+	}
+	return ""
+}
+
+func v2Duration(seconds int) string {
+	return strconv.Itoa(seconds) + "s"
+}
@@ -0,0 +1,394 @@
+package resource
+
+import (
+	"encoding/json"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *rendererSuite) TestRenderV2Manifest() {
+	s.Run("renders container command, args, and port", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  command: [/app/server]
+  args: [--grpc]
+  workingDir: /app
+  port:
+    number: 50051
+    name: h2c
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		err := NewRenderer(fileIO).RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+		container := raw["template"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})
+		require.Equal(s.T(), []interface{}{"/app/server"}, container["command"])
+		require.Equal(s.T(), []interface{}{"--grpc"}, container["args"])
+		require.Equal(s.T(), "/app", container["workingDir"])
+		require.Equal(s.T(), []interface{}{map[string]interface{}{"name": "h2c", "containerPort": float64(50051)}}, container["ports"])
+	})
+
+	s.Run("renders v2 service json", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  cpu: 2
+  memoryMiB: 1024
+  minInstances: 1
+  maxInstances: 10
+  concurrency: 250
+  network: default
+  subnet: app-subnet
+  vpcEgress: private-ranges-only
+  startupProbe:
+    tcpSocket:
+      port: 8080
+serviceAccount: app@project.iam.gserviceaccount.com
+cloudsqlConnector: project:region:instance
+env:
+  - variable: LOG_LEVEL
+    value: info
+  - variable: API_KEY
+    secret: projects/123456789/secrets/API_KEY
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:     "config.yaml",
+			ServiceName:    "myapp",
+			Region:         "us-central1",
+			Image:          "example.com/myapp@" + testDigest,
+			ResourceType:   "service",
+			TimeoutSeconds: 900,
+			OutputPath:     "manifest.json",
+			Format:         "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+
+		require.Equal(s.T(), "myapp", raw["name"])
+		require.Equal(s.T(), "INGRESS_TRAFFIC_ALL", raw["ingress"])
+		require.Equal(s.T(), "BETA", raw["launchStage"])
+		scaling := raw["scaling"].(map[string]interface{})
+		require.EqualValues(s.T(), 1, scaling["minInstanceCount"])
+		require.EqualValues(s.T(), 10, scaling["maxInstanceCount"])
+
+		template := raw["template"].(map[string]interface{})
+		require.Equal(s.T(), "EXECUTION_ENVIRONMENT_GEN2", template["executionEnvironment"])
+		require.Equal(s.T(), "app@project.iam.gserviceaccount.com", template["serviceAccount"])
+		require.Equal(s.T(), "900s", template["timeout"])
+		require.EqualValues(s.T(), 250, template["maxInstanceRequestConcurrency"])
+
+		vpcAccess := template["vpcAccess"].(map[string]interface{})
+		require.Equal(s.T(), "PRIVATE_RANGES_ONLY", vpcAccess["egress"])
+		networkInterface := vpcAccess["networkInterfaces"].([]interface{})[0].(map[string]interface{})
+		require.Equal(s.T(), "default", networkInterface["network"])
+		require.Equal(s.T(), "app-subnet", networkInterface["subnetwork"])
+
+		volume := template["volumes"].([]interface{})[0].(map[string]interface{})
+		require.Equal(s.T(), "cloudsql", volume["name"])
+		instances := volume["cloudSqlInstance"].(map[string]interface{})["instances"].([]interface{})
+		require.Equal(s.T(), []interface{}{"project:region:instance"}, instances)
+
+		container := template["containers"].([]interface{})[0].(map[string]interface{})
+		require.Equal(s.T(), "example.com/myapp@"+testDigest, container["image"])
+		limits := container["resources"].(map[string]interface{})["limits"].(map[string]interface{})
+		require.Equal(s.T(), "2", limits["cpu"])
+		require.Equal(s.T(), "1024Mi", limits["memory"])
+		mount := container["volumeMounts"].([]interface{})[0].(map[string]interface{})
+		require.Equal(s.T(), "/cloudsql", mount["mountPath"])
+
+		envList := container["env"].([]interface{})
+		require.Len(s.T(), envList, 2)
+		require.Equal(s.T(), "info", envList[0].(map[string]interface{})["value"])
+		secretRef := envList[1].(map[string]interface{})["valueSource"].(map[string]interface{})["secretKeyRef"].(map[string]interface{})
+		require.Equal(s.T(), "projects/123456789/secrets/API_KEY", secretRef["secret"])
+		require.Equal(s.T(), "latest", secretRef["version"])
+
+		startup := container["startupProbe"].(map[string]interface{})
+		require.EqualValues(s.T(), 8080, startup["tcpSocket"].(map[string]interface{})["port"])
+	})
+
+	s.Run("renders the service mesh", func() {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte("runConfig:\n  mesh: projects/p/locations/global/meshes/m\n")},
+			writeFiles: map[string][]byte{},
+		}
+		err := NewRenderer(fileIO).RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+		serviceMesh := raw["template"].(map[string]interface{})["serviceMesh"].(map[string]interface{})
+		require.Equal(s.T(), "projects/p/locations/global/meshes/m", serviceMesh["mesh"])
+	})
+
+	s.Run("renders v2 job json", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  taskCount: 5
+  parallelism: 2
+  maxRetries: 3
+  timeoutSeconds: 600
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myjob",
+			Region:       "us-central1",
+			Image:        "example.com/myjob@" + testDigest,
+			ResourceType: "job",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+
+		require.Equal(s.T(), "myjob", raw["name"])
+		template := raw["template"].(map[string]interface{})
+		require.EqualValues(s.T(), 5, template["taskCount"])
+		require.EqualValues(s.T(), 2, template["parallelism"])
+		taskTemplate := template["template"].(map[string]interface{})
+		require.EqualValues(s.T(), 3, taskTemplate["maxRetries"])
+		require.Equal(s.T(), "600s", taskTemplate["timeout"])
+		require.Nil(s.T(), taskTemplate["vpcAccess"])
+		require.Nil(s.T(), taskTemplate["volumes"])
+	})
+
+	s.Run("renders service and revision scaling", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  minInstances: 1
+  scaling:
+    maxInstances: 20
+    revisionMinInstances: 2
+    revisionMaxInstances: 10
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+		require.Equal(s.T(), map[string]interface{}{"minInstanceCount": float64(1), "maxInstanceCount": float64(20)}, raw["scaling"])
+		template := raw["template"].(map[string]interface{})
+		require.Equal(s.T(), map[string]interface{}{"minInstanceCount": float64(2), "maxInstanceCount": float64(10)}, template["scaling"])
+	})
+
+	s.Run("renders binary authorization and encryption settings", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+security:
+  binaryAuthorization: default
+  breakglassJustification: INC-1234
+  encryptionKey: projects/p/locations/us-central1/keyRings/run/cryptoKeys/images
+  keyRevocationAction: shutdown
+  keyShutdownHours: 2
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+		require.Equal(s.T(), map[string]interface{}{"useDefault": true, "breakglassJustification": "INC-1234"}, raw["binaryAuthorization"])
+		template := raw["template"].(map[string]interface{})
+		require.Equal(s.T(), "projects/p/locations/us-central1/keyRings/run/cryptoKeys/images", template["encryptionKey"])
+		require.Equal(s.T(), "SHUTDOWN", template["encryptionKeyRevocationAction"])
+		require.Equal(s.T(), "7200s", template["encryptionKeyShutdownDuration"])
+	})
+
+	s.Run("renders v2 worker pool json", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  minInstances: 1
+  maxInstances: 5
+  vpcConnector: connector-a
+  vpcEgress: all-traffic
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myworker",
+			Region:       "us-central1",
+			Image:        "example.com/myworker@" + testDigest,
+			ResourceType: "worker",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+
+		require.Equal(s.T(), "myworker", raw["name"])
+		require.Nil(s.T(), raw["launchStage"])
+		scaling := raw["scaling"].(map[string]interface{})
+		require.EqualValues(s.T(), 1, scaling["minInstanceCount"])
+		require.EqualValues(s.T(), 5, scaling["maxInstanceCount"])
+		template := raw["template"].(map[string]interface{})
+		vpcAccess := template["vpcAccess"].(map[string]interface{})
+		require.Equal(s.T(), "connector-a", vpcAccess["connector"])
+		require.Equal(s.T(), "ALL_TRAFFIC", vpcAccess["egress"])
+	})
+
+	s.Run("renders v2 worker pool manual scaling and instance splits", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  scalingMode: manual
+  instanceCount: 4
+  instanceSplits:
+    - revision: myworker-00001-abc
+      percent: 50
+    - latest: true
+      percent: 50
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myworker",
+			Region:       "us-central1",
+			Image:        "example.com/myworker@" + testDigest,
+			ResourceType: "worker",
+			OutputPath:   "manifest.json",
+			Format:       "v2-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+		require.Equal(s.T(), map[string]interface{}{
+			"scalingMode":         "MANUAL",
+			"manualInstanceCount": float64(4),
+		}, raw["scaling"])
+		require.Equal(s.T(), []interface{}{
+			map[string]interface{}{"type": "INSTANCE_SPLIT_ALLOCATION_TYPE_REVISION", "revision": "myworker-00001-abc", "percent": float64(50)},
+			map[string]interface{}{"type": "INSTANCE_SPLIT_ALLOCATION_TYPE_LATEST", "percent": float64(50)},
+		}, raw["instanceSplits"])
+	})
+
+	s.Run("renders knative json", func() {
+		fileIO := &fakeFileIO{
+			readFiles: map[string][]byte{
+				"config.yaml": []byte(`
+runConfig:
+  maxInstances: 3
+`),
+			},
+			writeFiles: map[string][]byte{},
+		}
+
+		renderer := NewRenderer(fileIO)
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "knative-json",
+		})
+		require.NoError(s.T(), err)
+
+		var raw map[string]interface{}
+		require.NoError(s.T(), json.Unmarshal(fileIO.writeFiles["manifest.json"], &raw))
+		require.Equal(s.T(), "serving.knative.dev/v1", raw["apiVersion"])
+		require.Nil(s.T(), raw["status"])
+		annotations := raw["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+		require.Equal(s.T(), "3", annotations["run.googleapis.com/maxScale"])
+	})
+
+	s.Run("rejects unknown format", func() {
+		renderer := NewRenderer(&fakeFileIO{
+			readFiles:  map[string][]byte{},
+			writeFiles: map[string][]byte{},
+		})
+		err := renderer.RenderManifest(RenderOptions{
+			ConfigPath:   "config.yaml",
+			ServiceName:  "myapp",
+			Region:       "us-central1",
+			Image:        "example.com/myapp@" + testDigest,
+			ResourceType: "service",
+			OutputPath:   "manifest.json",
+			Format:       "helm",
+		})
+		require.Error(s.T(), err)
+		require.Contains(s.T(), err.Error(), "format")
+	})
+}
@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/justinswe/rules_cloudrun/cloudrun/manifest"
+)
+
+// WarningCode identifies the kind of a non-fatal rendering issue.
+type WarningCode string
+
+const (
+	// WarningLaunchStageBeta: probes force the BETA launch stage.
+	WarningLaunchStageBeta WarningCode = "launch-stage-beta"
+	// WarningSecretNameStripped: the Knative manifest references a secret
+	// by its short name, dropping the project from the config reference.
+	WarningSecretNameStripped WarningCode = "secret-name-stripped"
+	// WarningMutableImageTag: a production image uses a mutable tag.
+	WarningMutableImageTag WarningCode = "mutable-image-tag"
+	// WarningPublicInternalService: allUsers may invoke a service that
+	// only accepts internal traffic.
+	WarningPublicInternalService WarningCode = "public-internal-service"
+	// WarningScalingTargetDropped: the v2 API has no CPU utilization
+	// target, so v2 and Terraform manifests leave it out.
+	WarningScalingTargetDropped WarningCode = "scaling-target-dropped"
+)
+
+// Warning is a non-fatal rendering issue. Path locates the offending value
+// in the config (for example env[2].secret) or names the option it came
+// from.
+type Warning struct {
+	Code    WarningCode
+	Path    string
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Path == "" {
+		return fmt.Sprintf("%s [%s]", w.Message, w.Code)
+	}
+	return fmt.Sprintf("%s [%s at %s]", w.Message, w.Code, w.Path)
+}
+
+// collectWarnings reports what the builders change or drop silently.
+func collectWarnings(config appHostingConfig, options RenderOptions) []Warning {
+	warnings := imageWarnings(options)
+
+	if options.ResourceType != resourceTypeJob {
+		for _, probe := range []struct {
+			path  string
+			isSet bool
+		}{
+			{"runConfig.livenessProbe", config.RunConfig.LivenessProbe != nil},
+			{"runConfig.readinessProbe", config.RunConfig.ReadinessProbe != nil},
+			{"runConfig.startupProbe", config.RunConfig.StartupProbe != nil},
+		} {
+			if probe.isSet {
+				warnings = append(warnings, Warning{
+					Code:    WarningLaunchStageBeta,
+					Path:    probe.path,
+					Message: "probes require the BETA launch stage; run.googleapis.com/launch-stage is set to BETA",
+				})
+				break
+			}
+		}
+	}
+
+	if config.IAM != nil && options.ResourceType != resourceTypeJob && config.RunConfig.Ingress == manifest.IngressInternal {
+		for i, member := range config.IAM.Invokers {
+			if member == manifest.MemberAllUsers {
+				warnings = append(warnings, Warning{
+					Code:    WarningPublicInternalService,
+					Path:    fmt.Sprintf("iam.invokers[%d]", i),
+					Message: "allUsers is granted roles/run.invoker, but runConfig.ingress is internal, so the service is not reachable from the internet",
+				})
+			}
+		}
+	}
+
+	if config.RunConfig.Scaling != nil && config.RunConfig.Scaling.CPUUtilization != nil && (options.Format == formatV2JSON || options.Format == formatTerraformJSON) {
+		warnings = append(warnings, Warning{
+			Code:    WarningScalingTargetDropped,
+			Path:    "runConfig.scaling.cpuUtilization",
+			Message: fmt.Sprintf("the Cloud Run Admin API v2 has no CPU utilization target; the %s manifest leaves it out", options.Format),
+		})
+	}
+
+	// Without a projectNumber the Knative builders cannot tell same-project
+	// secrets from cross-project ones and reference both by short name.
+	knative := options.Format == "" || options.Format == formatKnativeYAML || options.Format == formatKnativeJSON
+	if !knative || config.ProjectNumber != "" {
+		return warnings
+	}
+	stripped := func(path, subject, secret string) {
+		if strings.Contains(secret, "/") {
+			warnings = append(warnings, Warning{
+				Code: WarningSecretNameStripped,
+				Path: path,
+				Message: fmt.Sprintf("%s references secret %q by its short name %q; the secret must exist in the deploy project (set projectNumber to alias secrets from other projects)",
+					subject, secret, secret[strings.LastIndex(secret, "/")+1:]),
+			})
+		}
+	}
+	for i, entry := range config.Env {
+		if entry.Value == nil {
+			stripped(fmt.Sprintf("env[%d].secret", i), fmt.Sprintf("env %q", entry.Variable), entry.Secret)
+		}
+	}
+	for i, volume := range config.SecretVolumes {
+		stripped(fmt.Sprintf("secretVolumes[%d].secret", i), fmt.Sprintf("secret volume %q", volume.MountPath), volume.Secret)
+	}
+	return warnings
+}
@@ -0,0 +1,134 @@
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (s *rendererSuite) TestRenderManifestContext() {
+	render := func(ctx context.Context, config string, options RenderOptions) (Result, *fakeFileIO, error) {
+		fileIO := &fakeFileIO{
+			readFiles:  map[string][]byte{"config.yaml": []byte(config)},
+			writeFiles: map[string][]byte{},
+		}
+		options.ConfigPath = "config.yaml"
+		options.ServiceName = "myapp"
+		options.Region = "us-central1"
+		options.OutputPath = "manifest.yaml"
+		if options.Image == "" {
+			options.Image = "example.com/myapp@" + testDigest
+		}
+		result, err := NewRenderer(fileIO).RenderManifestContext(ctx, options)
+		return result, fileIO, err
+	}
+
+	s.Run("returns manifest, hash and metadata", func() {
+		result, fileIO, err := render(context.Background(), "runConfig: {}\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), fileIO.writeFiles["manifest.yaml"], result.Manifest)
+		digest := sha256.Sum256(result.Manifest)
+		require.Equal(s.T(), "sha256:"+hex.EncodeToString(digest[:]), result.ContentHash)
+		require.Equal(s.T(), "knative-yaml", result.Format)
+		require.Equal(s.T(), "service", result.ResourceType)
+		require.Empty(s.T(), result.Warnings)
+	})
+
+	s.Run("reports silent rewrites as warnings", func() {
+		result, _, err := render(context.Background(), `
+runConfig:
+  readinessProbe:
+    tcpSocket:
+      port: 8080
+env:
+  - variable: API_KEY
+    secret: projects/123456789/secrets/API_KEY
+`, RenderOptions{Image: "gcr.io/proj/app:latest", Environment: "prd"})
+		require.NoError(s.T(), err)
+		require.Equal(s.T(), []Warning{
+			{Code: WarningMutableImageTag, Path: "image", Message: result.Warnings[0].Message},
+			{Code: WarningLaunchStageBeta, Path: "runConfig.readinessProbe", Message: result.Warnings[1].Message},
+			{Code: WarningSecretNameStripped, Path: "env[0].secret", Message: result.Warnings[2].Message},
+		}, result.Warnings)
+		require.Contains(s.T(), result.Warnings[2].String(), `[secret-name-stripped at env[0].secret]`)
+	})
+
+	s.Run("writes nothing when warnings are errors", func() {
+		result, fileIO, err := render(context.Background(), "runConfig: {}\n", RenderOptions{
+			Image:            "gcr.io/proj/app:latest",
+			Environment:      "prd",
+			WarningsAsErrors: true,
+		})
+		require.EqualError(s.T(), err, "1 warning(s) treated as errors")
+		require.Len(s.T(), result.Warnings, 1)
+		require.Empty(s.T(), result.Manifest)
+		require.Empty(s.T(), fileIO.writeFiles)
+	})
+
+	s.Run("does not warn about stripped secrets in v2 output", func() {
+		result, _, err := render(context.Background(), `
+env:
+  - variable: API_KEY
+    secret: projects/123456789/secrets/API_KEY
+`, RenderOptions{Format: "v2-json"})
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), result.Warnings)
+	})
+
+	s.Run("warns about secret volumes unless projectNumber is set", func() {
+		config := `
+secretVolumes:
+  - mountPath: /etc/tls
+    secret: projects/123456789/secrets/tls-cert
+`
+		result, _, err := render(context.Background(), config, RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), result.Warnings, 1)
+		require.Equal(s.T(), "secretVolumes[0].secret", result.Warnings[0].Path)
+
+		result, _, err = render(context.Background(), config+"projectNumber: \"123456789\"\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), result.Warnings)
+	})
+
+	s.Run("warns about allUsers on an internal service", func() {
+		config := `
+runConfig:
+  ingress: internal
+iam:
+  invokers: [group:eng@example.com, allUsers]
+`
+		result, _, err := render(context.Background(), config, RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), result.Warnings, 1)
+		require.Equal(s.T(), WarningPublicInternalService, result.Warnings[0].Code)
+		require.Equal(s.T(), "iam.invokers[1]", result.Warnings[0].Path)
+
+		result, _, err = render(context.Background(), "iam:\n  invokers: [allUsers]\n", RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), result.Warnings)
+	})
+
+	s.Run("warns that v2 output drops the cpu utilization target", func() {
+		config := "runConfig:\n  scaling:\n    cpuUtilization: 60\n"
+		result, _, err := render(context.Background(), config, RenderOptions{Format: "v2-json"})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), result.Warnings, 1)
+		require.Equal(s.T(), WarningScalingTargetDropped, result.Warnings[0].Code)
+		require.Equal(s.T(), "runConfig.scaling.cpuUtilization", result.Warnings[0].Path)
+
+		result, _, err = render(context.Background(), config, RenderOptions{})
+		require.NoError(s.T(), err)
+		require.Empty(s.T(), result.Warnings)
+	})
+
+	s.Run("stops when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, fileIO, err := render(ctx, "runConfig: {}\n", RenderOptions{})
+		require.ErrorIs(s.T(), err, context.Canceled)
+		require.Empty(s.T(), fileIO.writeFiles)
+	})
+}
@@ -27,17 +27,17 @@ ERRORS=()
 
 # ── Allowed keys per resource type ────────────────────────────────────────────
 
-ALLOWED_TOP="runConfig env serviceAccount cloudsqlConnector"
+ALLOWED_TOP="runConfig env envFrom secretVolumes dependencies domains loadBalancer iam deploy rollout security serviceAccount cloudsqlConnector projectNumber"
 
 case "$RESOURCE_TYPE" in
   service)
-    ALLOWED_RUN="cpu memoryMiB minInstances maxInstances concurrency network subnet vpcConnector vpcEgress livenessProbe readinessProbe startupProbe"
+    ALLOWED_RUN="cpu memoryMiB minInstances maxInstances concurrency ingress mesh network subnet vpcConnector vpcEgress livenessProbe readinessProbe startupProbe command args workingDir port scaling"
     ;;
   job)
-    ALLOWED_RUN="cpu memoryMiB taskCount parallelism maxRetries timeoutSeconds network subnet vpcConnector vpcEgress"
+    ALLOWED_RUN="cpu memoryMiB taskCount parallelism maxRetries timeoutSeconds network subnet vpcConnector vpcEgress command args workingDir"
     ;;
   worker)
-    ALLOWED_RUN="cpu memoryMiB minInstances maxInstances network subnet vpcConnector vpcEgress livenessProbe readinessProbe startupProbe"
+    ALLOWED_RUN="cpu memoryMiB minInstances maxInstances scalingMode instanceCount instanceSplits network subnet vpcConnector vpcEgress livenessProbe readinessProbe startupProbe command args workingDir"
     ;;
   *)
     echo "ERROR: Unknown resource type '$RESOURCE_TYPE'" >&2
@@ -80,7 +80,7 @@ if [[ "$HAS_RUN_CONFIG" == "!!map" ]]; then
   done
 
   # ── Numeric field validation ─────────────────────────────────────────────
-  for field in cpu memoryMiB minInstances maxInstances concurrency taskCount parallelism maxRetries timeoutSeconds; do
+  for field in cpu memoryMiB minInstances maxInstances concurrency instanceCount taskCount parallelism maxRetries timeoutSeconds; do
     val=$("$YQ" ".runConfig.$field // \"\"" "$CONFIG" 2>/dev/null || echo "")
     if [[ -n "$val" ]]; then
       if ! [[ "$val" =~ ^[0-9]+$ ]]; then
@@ -106,6 +106,21 @@ if [[ "$HAS_RUN_CONFIG" == "!!map" ]]; then
     fi
   fi
 
+  # ingress must be a Cloud Run ingress setting
+  ingress_val=$("$YQ" '.runConfig.ingress // ""' "$CONFIG" 2>/dev/null || echo "")
+  if [[ -n "$ingress_val" ]]; then
+    case "$ingress_val" in
+      all|internal|internal-and-cloud-load-balancing) ;;
+      *) ERRORS+=("runConfig.ingress must be all, internal, or internal-and-cloud-load-balancing, got '$ingress_val'");;
+    esac
+  fi
+
+  # mesh must be a Cloud Service Mesh resource name
+  mesh_val=$("$YQ" '.runConfig.mesh // ""' "$CONFIG" 2>/dev/null || echo "")
+  if [[ -n "$mesh_val" ]] && ! [[ "$mesh_val" =~ ^projects/[^/]+/locations/global/meshes/[^/]+$ ]]; then
+    ERRORS+=("runConfig.mesh must match 'projects/<project>/locations/global/meshes/<mesh>', got '$mesh_val'")
+  fi
+
   # network/subnet co-dependency
   net=$("$YQ" '.runConfig.network // ""' "$CONFIG" 2>/dev/null || echo "")
   sub=$("$YQ" '.runConfig.subnet // ""' "$CONFIG" 2>/dev/null || echo "")
@@ -115,6 +130,163 @@ if [[ "$HAS_RUN_CONFIG" == "!!map" ]]; then
   if [[ -z "$net" && -n "$sub" ]]; then
     ERRORS+=("runConfig.subnet requires runConfig.network")
   fi
+
+  # command and args are lists of strings
+  for field in command args; do
+    field_type=$("$YQ" ".runConfig.$field | type" "$CONFIG" 2>/dev/null || echo "!!null")
+    if [[ "$field_type" != "!!null" && "$field_type" != "!!seq" ]]; then
+      ERRORS+=("runConfig.$field must be a list of strings")
+    elif [[ "$field_type" == "!!seq" && "$("$YQ" "[.runConfig.$field[] | select(tag == \"!!map\" or tag == \"!!seq\")] | length" "$CONFIG")" != "0" ]]; then
+      ERRORS+=("runConfig.$field must be a list of strings")
+    fi
+  done
+  if [[ "$("$YQ" '.runConfig.command[0] // "x"' "$CONFIG" 2>/dev/null)" == "" ]]; then
+    ERRORS+=("runConfig.command must name a program")
+  fi
+
+  # workingDir must be absolute
+  working_dir=$("$YQ" '.runConfig.workingDir // ""' "$CONFIG" 2>/dev/null || echo "")
+  if [[ -n "$working_dir" && "$working_dir" != /* ]]; then
+    ERRORS+=("runConfig.workingDir must be an absolute path, got '$working_dir'")
+  fi
+
+  # port: number 1–65535, name http1 or h2c
+  if [[ "$("$YQ" '.runConfig | has("port")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+    PORT_KEYS=$("$YQ" '.runConfig.port | keys | .[]' "$CONFIG" 2>/dev/null || true)
+    for pk in $PORT_KEYS; do
+      case "$pk" in
+        number|name) ;;
+        *) ERRORS+=("runConfig.port has unknown key '$pk'. Allowed: number name");;
+      esac
+    done
+    port_number=$("$YQ" '.runConfig.port.number // ""' "$CONFIG")
+    if [[ -n "$port_number" ]] && { ! [[ "$port_number" =~ ^[0-9]+$ ]] || (( port_number < 1 || port_number > 65535 )); }; then
+      ERRORS+=("runConfig.port.number must be an integer between 1 and 65535, got '$port_number'")
+    fi
+    port_name=$("$YQ" '.runConfig.port.name // ""' "$CONFIG")
+    case "$port_name" in
+      ""|http1|h2c) ;;
+      *) ERRORS+=("runConfig.port.name must be http1 or h2c, got '$port_name'");;
+    esac
+  fi
+
+  # scaling: service-level and revision-level bounds, CPU target in percent
+  min_field=minInstances
+  max_field=maxInstances
+  min_instances=$("$YQ" '.runConfig.minInstances // ""' "$CONFIG" 2>/dev/null || echo "")
+  max_instances=$("$YQ" '.runConfig.maxInstances // ""' "$CONFIG" 2>/dev/null || echo "")
+  revision_min=""
+  revision_max=""
+  if [[ "$("$YQ" '.runConfig | has("scaling")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+    SCALING_KEYS=$("$YQ" '.runConfig.scaling | keys | .[]' "$CONFIG" 2>/dev/null || true)
+    for sk in $SCALING_KEYS; do
+      case "$sk" in
+        minInstances|maxInstances|revisionMinInstances|revisionMaxInstances|cpuUtilization) ;;
+        *) ERRORS+=("runConfig.scaling has unknown key '$sk'. Allowed: minInstances maxInstances revisionMinInstances revisionMaxInstances cpuUtilization");;
+      esac
+    done
+    for field in minInstances maxInstances revisionMinInstances revisionMaxInstances cpuUtilization; do
+      val=$("$YQ" ".runConfig.scaling.$field // \"\"" "$CONFIG" 2>/dev/null || echo "")
+      if [[ -n "$val" ]] && ! [[ "$val" =~ ^[0-9]+$ ]]; then
+        ERRORS+=("runConfig.scaling.$field must be a positive integer, got '$val'")
+      fi
+    done
+    scaling_min=$("$YQ" '.runConfig.scaling.minInstances // ""' "$CONFIG")
+    scaling_max=$("$YQ" '.runConfig.scaling.maxInstances // ""' "$CONFIG")
+    if [[ -n "$scaling_min" ]]; then
+      if [[ -n "$min_instances" ]]; then
+        ERRORS+=("runConfig.minInstances and runConfig.scaling.minInstances both set the service minimum; keep one")
+      fi
+      min_field=scaling.minInstances
+      min_instances="$scaling_min"
+    fi
+    if [[ -n "$scaling_max" ]]; then
+      if [[ -n "$max_instances" ]]; then
+        ERRORS+=("runConfig.maxInstances and runConfig.scaling.maxInstances both set the service maximum; keep one")
+      fi
+      max_field=scaling.maxInstances
+      max_instances="$scaling_max"
+    fi
+    revision_min=$("$YQ" '.runConfig.scaling.revisionMinInstances // ""' "$CONFIG")
+    revision_max=$("$YQ" '.runConfig.scaling.revisionMaxInstances // ""' "$CONFIG")
+    cpu_target=$("$YQ" '.runConfig.scaling.cpuUtilization // ""' "$CONFIG")
+    if [[ "$cpu_target" =~ ^[0-9]+$ ]] && (( cpu_target < 1 || cpu_target > 100 )); then
+      ERRORS+=("runConfig.scaling.cpuUtilization must be between 1 and 100, got '$cpu_target'")
+    fi
+  fi
+  for bounds in "$min_field:$min_instances:$max_field:$max_instances" \
+                "scaling.revisionMinInstances:$revision_min:scaling.revisionMaxInstances:$revision_max" \
+                "scaling.revisionMinInstances:$revision_min:$max_field:$max_instances"; do
+    IFS=: read -r min_name min_value max_name max_value <<< "$bounds"
+    if [[ "$min_value" =~ ^[0-9]+$ && "$max_value" =~ ^[0-9]+$ ]] && (( min_value > max_value )); then
+      ERRORS+=("runConfig.$min_name ($min_value) must not exceed runConfig.$max_name ($max_value)")
+    fi
+  done
+
+  # worker scaling: manual pools set instanceCount, automatic ones the bounds
+  if [[ "$RESOURCE_TYPE" == "worker" ]]; then
+    scaling_mode=$("$YQ" '.runConfig.scalingMode // ""' "$CONFIG" 2>/dev/null || echo "")
+    has_count=$("$YQ" '.runConfig | has("instanceCount")' "$CONFIG" 2>/dev/null || echo "false")
+    has_bounds=$("$YQ" '(.runConfig | has("minInstances")) or (.runConfig | has("maxInstances"))' "$CONFIG" 2>/dev/null || echo "false")
+    case "$scaling_mode" in
+      "")
+        if [[ "$has_count" == "true" ]]; then
+          scaling_mode=manual
+        fi
+        ;;
+      manual|automatic) ;;
+      *) ERRORS+=("runConfig.scalingMode must be manual or automatic, got '$scaling_mode'");;
+    esac
+    if [[ "$scaling_mode" == "manual" && "$has_bounds" == "true" ]]; then
+      ERRORS+=("runConfig.minInstances and runConfig.maxInstances do not apply to manually scaled worker pools; set runConfig.instanceCount")
+    fi
+    if [[ "$scaling_mode" == "manual" && "$has_count" != "true" ]]; then
+      ERRORS+=("runConfig.instanceCount is required when runConfig.scalingMode is manual")
+    fi
+    if [[ "$scaling_mode" == "automatic" && "$has_count" == "true" ]]; then
+      ERRORS+=("runConfig.instanceCount only applies to manually scaled worker pools; set runConfig.scalingMode to manual")
+    fi
+
+    # instanceSplits: each names a revision or the latest one; percents add up to 100
+    SPLIT_COUNT=$("$YQ" '.runConfig.instanceSplits | length' "$CONFIG" 2>/dev/null || echo "0")
+    if (( SPLIT_COUNT > 0 )); then
+      split_total=0
+      split_targets=()
+      for i in $(seq 0 $((SPLIT_COUNT - 1))); do
+        SPLIT_KEYS=$("$YQ" ".runConfig.instanceSplits[$i] | keys | .[]" "$CONFIG" 2>/dev/null || true)
+        for sk in $SPLIT_KEYS; do
+          case "$sk" in
+            revision|latest|percent) ;;
+            *) ERRORS+=("runConfig.instanceSplits[$i] has unknown key '$sk'. Allowed: revision latest percent");;
+          esac
+        done
+        revision=$("$YQ" ".runConfig.instanceSplits[$i].revision // \"\"" "$CONFIG")
+        latest=$("$YQ" ".runConfig.instanceSplits[$i].latest // false" "$CONFIG")
+        if [[ -n "$revision" && "$latest" == "true" ]] || [[ -z "$revision" && "$latest" != "true" ]]; then
+          ERRORS+=("runConfig.instanceSplits[$i] must set exactly one of 'revision' or 'latest'")
+        fi
+        target="$revision"
+        if [[ "$latest" == "true" ]]; then
+          target=latest
+        fi
+        for seen in "${split_targets[@]}"; do
+          if [[ "$seen" == "$target" ]]; then
+            ERRORS+=("runConfig.instanceSplits[$i] assigns $target twice")
+          fi
+        done
+        split_targets+=("$target")
+        percent=$("$YQ" ".runConfig.instanceSplits[$i].percent // 0" "$CONFIG")
+        if ! [[ "$percent" =~ ^[0-9]+$ ]] || (( percent > 100 )); then
+          ERRORS+=("runConfig.instanceSplits[$i].percent must be an integer between 0 and 100, got '$percent'")
+        else
+          split_total=$((split_total + percent))
+        fi
+      done
+      if (( split_total != 100 )); then
+        ERRORS+=("runConfig.instanceSplits must assign 100 percent of the instances, got $split_total")
+      fi
+    fi
+  fi
 fi
 
 # ── env entries ──────────────────────────────────────────────────────────────
@@ -150,13 +322,384 @@ if [[ "$ENV_COUNT" =~ ^[0-9]+$ ]] && (( ENV_COUNT > 0 )); then
     ENV_KEYS=$("$YQ" ".env[$i] | keys | .[]" "$CONFIG" 2>/dev/null || true)
     for ek in $ENV_KEYS; do
       case "$ek" in
-        variable|value|secret|availability) ;;
-        *) ERRORS+=("env '$var' has unknown key '$ek'. Allowed: variable, value, secret, availability");;
+        variable|value|secret|version|availability|allowPlaintext) ;;
+        *) ERRORS+=("env '$var' has unknown key '$ek'. Allowed: variable, value, secret, version, availability, allowPlaintext");;
+      esac
+    done
+
+    version=$("$YQ" ".env[$i].version // \"\"" "$CONFIG")
+    if [[ -n "$version" ]]; then
+      if [[ "$has_secret" != "true" ]]; then
+        ERRORS+=("env '$var' sets 'version' without 'secret'")
+      elif ! [[ "$version" == "latest" || "$version" =~ ^[1-9][0-9]*$ ]]; then
+        ERRORS+=("env '$var' version must be 'latest' or a positive integer, got '$version'")
+      fi
+    fi
+
+    allow_plaintext=$("$YQ" ".env[$i].allowPlaintext | tag" "$CONFIG" 2>/dev/null || echo "")
+    if [[ "$allow_plaintext" != "!!null" && "$allow_plaintext" != "!!bool" && -n "$allow_plaintext" ]]; then
+      ERRORS+=("env '$var' allowPlaintext must be true or false")
+    fi
+  done
+fi
+
+# Duplicate variable names usually come from base/overlay merges
+DUPLICATE_VARS=$("$YQ" '.env[].variable' "$CONFIG" 2>/dev/null | sort | uniq -d || true)
+for dup in $DUPLICATE_VARS; do
+  ERRORS+=("env '$dup' is defined more than once")
+done
+
+# ── envFrom entries ──────────────────────────────────────────────────────────
+
+ENV_FROM_COUNT=$("$YQ" '.envFrom | length // 0' "$CONFIG" 2>/dev/null || echo "0")
+if [[ "$ENV_FROM_COUNT" =~ ^[0-9]+$ ]] && (( ENV_FROM_COUNT > 0 )); then
+  for ((i=0; i<ENV_FROM_COUNT; i++)); do
+    path=$("$YQ" ".envFrom[$i].path // \"\"" "$CONFIG")
+    if [[ -z "$path" ]]; then
+      ERRORS+=("envFrom entry at index $i is missing 'path'")
+    fi
+
+    format=$("$YQ" ".envFrom[$i].format // \"\"" "$CONFIG")
+    case "$format" in
+      ""|dotenv|yaml) ;;
+      *) ERRORS+=("envFrom '$path' format must be dotenv or yaml, got '$format'");;
+    esac
+
+    ENV_FROM_KEYS=$("$YQ" ".envFrom[$i] | keys | .[]" "$CONFIG" 2>/dev/null || true)
+    for ek in $ENV_FROM_KEYS; do
+      case "$ek" in
+        path|format) ;;
+        *) ERRORS+=("envFrom '$path' has unknown key '$ek'. Allowed: path, format");;
+      esac
+    done
+  done
+fi
+
+# ── secretVolumes entries ────────────────────────────────────────────────────
+
+VOLUME_COUNT=$("$YQ" '.secretVolumes | length // 0' "$CONFIG" 2>/dev/null || echo "0")
+if [[ "$VOLUME_COUNT" =~ ^[0-9]+$ ]] && (( VOLUME_COUNT > 0 )); then
+  for ((i=0; i<VOLUME_COUNT; i++)); do
+    mount=$("$YQ" ".secretVolumes[$i].mountPath // \"\"" "$CONFIG")
+    secret=$("$YQ" ".secretVolumes[$i].secret // \"\"" "$CONFIG")
+    version=$("$YQ" ".secretVolumes[$i].version // \"\"" "$CONFIG")
+    file_name=$("$YQ" ".secretVolumes[$i].fileName // \"\"" "$CONFIG")
+
+    if [[ "$mount" != /* ]]; then
+      ERRORS+=("secretVolumes entry at index $i mountPath must be an absolute path, got '$mount'")
+    fi
+    if ! [[ "$secret" =~ ^projects/[0-9]+/secrets/[a-zA-Z_][a-zA-Z0-9_-]*$ ]]; then
+      ERRORS+=("secretVolumes '$mount' secret must match 'projects/<num>/secrets/<name>', got '$secret'")
+    fi
+    if [[ -n "$version" ]] && ! [[ "$version" == "latest" || "$version" =~ ^[1-9][0-9]*$ ]]; then
+      ERRORS+=("secretVolumes '$mount' version must be 'latest' or a positive integer, got '$version'")
+    fi
+    if [[ "$file_name" == */* ]]; then
+      ERRORS+=("secretVolumes '$mount' fileName must not contain '/', got '$file_name'")
+    fi
+
+    VOLUME_KEYS=$("$YQ" ".secretVolumes[$i] | keys | .[]" "$CONFIG" 2>/dev/null || true)
+    for vk in $VOLUME_KEYS; do
+      case "$vk" in
+        mountPath|secret|version|fileName) ;;
+        *) ERRORS+=("secretVolumes '$mount' has unknown key '$vk'. Allowed: mountPath, secret, version, fileName");;
+      esac
+    done
+  done
+fi
+
+DUPLICATE_MOUNTS=$("$YQ" '.secretVolumes[].mountPath' "$CONFIG" 2>/dev/null | sort | uniq -d || true)
+for dup in $DUPLICATE_MOUNTS; do
+  ERRORS+=("secretVolumes mountPath '$dup' is used more than once")
+done
+
+# ── dependencies ─────────────────────────────────────────────────────────────
+
+DEPENDENCY_COUNT=$("$YQ" '.dependencies | length // 0' "$CONFIG" 2>/dev/null || echo "0")
+if [[ "$DEPENDENCY_COUNT" =~ ^[0-9]+$ ]] && (( DEPENDENCY_COUNT > 0 )); then
+  for ((i=0; i<DEPENDENCY_COUNT; i++)); do
+    dep_service=$("$YQ" ".dependencies[$i].service // \"\"" "$CONFIG")
+    if ! [[ "$dep_service" =~ ^[a-z]([-a-z0-9]*[a-z0-9])?$ ]]; then
+      ERRORS+=("dependencies entry at index $i service must be a Cloud Run service name, got '$dep_service'")
+    fi
+
+    dep_variable=$("$YQ" ".dependencies[$i].variable // \"\"" "$CONFIG")
+    if [[ -n "$dep_variable" ]] && ! [[ "$dep_variable" =~ ^[A-Za-z_][A-Za-z0-9_]*$ ]]; then
+      ERRORS+=("dependencies '$dep_service' variable must be a valid env name, got '$dep_variable'")
+    fi
+
+    DEPENDENCY_KEYS=$("$YQ" ".dependencies[$i] | keys | .[]" "$CONFIG" 2>/dev/null || true)
+    for dk in $DEPENDENCY_KEYS; do
+      case "$dk" in
+        service|variable|region) ;;
+        *) ERRORS+=("dependencies '$dep_service' has unknown key '$dk'. Allowed: service, variable, region");;
       esac
     done
   done
 fi
 
+# ── domains ──────────────────────────────────────────────────────────────────
+
+DOMAIN_COUNT=$("$YQ" '.domains | length // 0' "$CONFIG" 2>/dev/null || echo "0")
+if [[ "$DOMAIN_COUNT" =~ ^[0-9]+$ ]] && (( DOMAIN_COUNT > 0 )); then
+  if [[ "$RESOURCE_TYPE" != "service" ]]; then
+    ERRORS+=("domains are only supported for services")
+  fi
+  for ((i=0; i<DOMAIN_COUNT; i++)); do
+    domain=$("$YQ" ".domains[$i] // \"\"" "$CONFIG")
+    if ! [[ "$domain" =~ ^([a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?\.)+[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$ ]]; then
+      ERRORS+=("domains entry at index $i must be a lowercase hostname such as api.example.com, got '$domain'")
+    elif [[ "$domain" == "run.app" || "$domain" == *.run.app ]]; then
+      ERRORS+=("domain '$domain' is a run.app hostname; only custom domains can be mapped")
+    fi
+  done
+fi
+
+DUPLICATE_DOMAINS=$("$YQ" '.domains[]' "$CONFIG" 2>/dev/null | sort | uniq -d || true)
+for dup in $DUPLICATE_DOMAINS; do
+  ERRORS+=("domain '$dup' is listed more than once")
+done
+
+# ── loadBalancer ─────────────────────────────────────────────────────────────
+
+if [[ "$("$YQ" 'has("loadBalancer")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+  if [[ "$RESOURCE_TYPE" != "service" ]]; then
+    ERRORS+=("loadBalancer is only supported for services")
+  fi
+  LB_KEYS=$("$YQ" '.loadBalancer | keys | .[]' "$CONFIG" 2>/dev/null || true)
+  for lk in $LB_KEYS; do
+    case "$lk" in
+      domains) ;;
+      *) ERRORS+=("loadBalancer has unknown key '$lk'. Allowed: domains");;
+    esac
+  done
+
+  LB_DOMAIN_COUNT=$("$YQ" '.loadBalancer.domains | length // 0' "$CONFIG" 2>/dev/null || echo "0")
+  if [[ "$LB_DOMAIN_COUNT" =~ ^[0-9]+$ ]]; then
+    for ((i=0; i<LB_DOMAIN_COUNT; i++)); do
+      domain=$("$YQ" ".loadBalancer.domains[$i] // \"\"" "$CONFIG")
+      if ! [[ "$domain" =~ ^([a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?\.)+[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$ ]]; then
+        ERRORS+=("loadBalancer.domains entry at index $i must be a lowercase hostname such as api.example.com, got '$domain'")
+      elif [[ "$domain" == "run.app" || "$domain" == *.run.app ]]; then
+        ERRORS+=("loadBalancer domain '$domain' is a run.app hostname; only custom domains can be served")
+      fi
+    done
+  fi
+
+  DUPLICATE_LB_DOMAINS=$("$YQ" '.loadBalancer.domains[]' "$CONFIG" 2>/dev/null | sort | uniq -d || true)
+  for dup in $DUPLICATE_LB_DOMAINS; do
+    ERRORS+=("loadBalancer domain '$dup' is listed more than once")
+  done
+fi
+
+# ── iam ──────────────────────────────────────────────────────────────────────
+
+if [[ "$("$YQ" 'has("iam")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+  if [[ "$RESOURCE_TYPE" == "worker" ]]; then
+    ERRORS+=("iam is only supported for services and jobs")
+  fi
+  IAM_KEYS=$("$YQ" '.iam | keys | .[]' "$CONFIG" 2>/dev/null || true)
+  for ik in $IAM_KEYS; do
+    case "$ik" in
+      invokers) ;;
+      *) ERRORS+=("iam has unknown key '$ik'. Allowed: invokers");;
+    esac
+  done
+
+  INVOKER_COUNT=$("$YQ" '.iam.invokers | length // 0' "$CONFIG" 2>/dev/null || echo "0")
+  if [[ "$INVOKER_COUNT" =~ ^[0-9]+$ ]]; then
+    for ((i=0; i<INVOKER_COUNT; i++)); do
+      member=$("$YQ" ".iam.invokers[$i] // \"\"" "$CONFIG")
+      if ! [[ "$member" == "allUsers" || "$member" == "allAuthenticatedUsers" \
+        || "$member" =~ ^(user|group|serviceAccount):[^@[:space:]:]+@[^@[:space:].]+(\.[^@[:space:].]+)+$ \
+        || "$member" =~ ^domain:[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)+$ \
+        || "$member" =~ ^principal(Set)?://[^[:space:]]+$ ]]; then
+        ERRORS+=("iam.invokers entry at index $i is not a valid IAM member, got '$member'")
+      fi
+    done
+  fi
+
+  DUPLICATE_INVOKERS=$("$YQ" '.iam.invokers[]' "$CONFIG" 2>/dev/null | sort | uniq -d || true)
+  for dup in $DUPLICATE_INVOKERS; do
+    ERRORS+=("iam.invokers member '$dup' is listed more than once")
+  done
+fi
+
+# ── deploy ───────────────────────────────────────────────────────────────────
+
+if [[ "$("$YQ" 'has("deploy")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+  DEPLOY_KEYS=$("$YQ" '.deploy | keys | .[]' "$CONFIG" 2>/dev/null || true)
+  for dk in $DEPLOY_KEYS; do
+    case "$dk" in
+      verify) ;;
+      *) ERRORS+=("deploy has unknown key '$dk'. Allowed: verify");;
+    esac
+  done
+
+  if [[ "$("$YQ" '.deploy | has("verify")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+    if [[ "$RESOURCE_TYPE" != "service" ]]; then
+      ERRORS+=("deploy.verify is only supported for services")
+    fi
+    VERIFY_KEYS=$("$YQ" '.deploy.verify | keys | .[]' "$CONFIG" 2>/dev/null || true)
+    for vk in $VERIFY_KEYS; do
+      case "$vk" in
+        timeoutSeconds|path|tag|rollback) ;;
+        *) ERRORS+=("deploy.verify has unknown key '$vk'. Allowed: timeoutSeconds path tag rollback");;
+      esac
+    done
+
+    verify_timeout=$("$YQ" '.deploy.verify.timeoutSeconds // ""' "$CONFIG")
+    if [[ -n "$verify_timeout" ]]; then
+      if ! [[ "$verify_timeout" =~ ^[0-9]+$ ]] || (( verify_timeout < 1 || verify_timeout > 3600 )); then
+        ERRORS+=("deploy.verify.timeoutSeconds must be an integer between 1 and 3600, got '$verify_timeout'")
+      fi
+    fi
+    verify_path=$("$YQ" '.deploy.verify.path // ""' "$CONFIG")
+    if [[ -n "$verify_path" ]] && ! [[ "$verify_path" =~ ^/[^[:space:]#]*$ ]]; then
+      ERRORS+=("deploy.verify.path must start with '/' and contain no whitespace or fragment, got '$verify_path'")
+    fi
+    verify_tag=$("$YQ" '.deploy.verify.tag // ""' "$CONFIG")
+    if [[ -n "$verify_tag" ]]; then
+      if ! [[ "$verify_tag" =~ ^[a-z]([-a-z0-9]{0,44}[a-z0-9])?$ ]]; then
+        ERRORS+=("deploy.verify.tag must be lowercase letters, digits, and hyphens, start with a letter, and be at most 46 characters, got '$verify_tag'")
+      elif [[ -z "$verify_path" ]]; then
+        ERRORS+=("deploy.verify.tag is only used with deploy.verify.path")
+      fi
+    fi
+    verify_rollback=$("$YQ" '.deploy.verify.rollback | tag' "$CONFIG")
+    if [[ "$verify_rollback" != "!!null" && "$verify_rollback" != "!!bool" ]]; then
+      ERRORS+=("deploy.verify.rollback must be true or false")
+    fi
+  fi
+fi
+
+# ── rollout ──────────────────────────────────────────────────────────────────
+
+if [[ "$("$YQ" 'has("rollout")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+  if [[ "$RESOURCE_TYPE" != "service" ]]; then
+    ERRORS+=("rollout is only supported for services")
+  fi
+  ROLLOUT_KEYS=$("$YQ" '.rollout | keys | .[]' "$CONFIG" 2>/dev/null || true)
+  for rk in $ROLLOUT_KEYS; do
+    case "$rk" in
+      tag|steps|gate) ;;
+      *) ERRORS+=("rollout has unknown key '$rk'. Allowed: tag steps gate");;
+    esac
+  done
+
+  rollout_tag=$("$YQ" '.rollout.tag // ""' "$CONFIG")
+  if [[ -n "$rollout_tag" ]] && ! [[ "$rollout_tag" =~ ^[a-z]([-a-z0-9]{0,44}[a-z0-9])?$ ]]; then
+    ERRORS+=("rollout.tag must be lowercase letters, digits, and hyphens, start with a letter, and be at most 46 characters, got '$rollout_tag'")
+  fi
+
+  STEP_COUNT=$("$YQ" '.rollout.steps | length' "$CONFIG" 2>/dev/null || echo "0")
+  if [[ "$STEP_COUNT" -eq 0 ]]; then
+    ERRORS+=("rollout.steps must list at least one step")
+  fi
+  previous_percent=0
+  for ((i = 0; i < STEP_COUNT; i++)); do
+    STEP_KEYS=$("$YQ" ".rollout.steps[$i] | keys | .[]" "$CONFIG" 2>/dev/null || true)
+    for sk in $STEP_KEYS; do
+      case "$sk" in
+        percent|bakeSeconds) ;;
+        *) ERRORS+=("rollout.steps[$i] has unknown key '$sk'. Allowed: percent bakeSeconds");;
+      esac
+    done
+    percent=$("$YQ" ".rollout.steps[$i].percent // \"\"" "$CONFIG")
+    if ! [[ "$percent" =~ ^[0-9]+$ ]] || (( percent <= previous_percent || percent > 100 )); then
+      ERRORS+=("rollout.steps[$i].percent must be an integer greater than $previous_percent and at most 100, got '$percent'")
+    else
+      previous_percent=$percent
+    fi
+    bake=$("$YQ" ".rollout.steps[$i].bakeSeconds // \"\"" "$CONFIG")
+    if [[ -n "$bake" ]] && { ! [[ "$bake" =~ ^[0-9]+$ ]] || (( bake > 86400 )); }; then
+      ERRORS+=("rollout.steps[$i].bakeSeconds must be an integer between 0 and 86400, got '$bake'")
+    fi
+  done
+  if [[ "$STEP_COUNT" -gt 0 && "$previous_percent" -ne 100 ]]; then
+    ERRORS+=("the last of rollout.steps must send 100 percent of the traffic")
+  fi
+
+  if [[ "$("$YQ" '.rollout | has("gate")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+    GATE_KEYS=$("$YQ" '.rollout.gate | keys | .[]' "$CONFIG" 2>/dev/null || true)
+    for gk in $GATE_KEYS; do
+      case "$gk" in
+        http|command) ;;
+        *) ERRORS+=("rollout.gate has unknown key '$gk'. Allowed: http command");;
+      esac
+    done
+    if [[ "$("$YQ" '(.rollout.gate | has("http")) and (.rollout.gate | has("command"))' "$CONFIG")" == "true" ]]; then
+      ERRORS+=("rollout.gate sets both 'http' and 'command'; set one")
+    fi
+    if [[ "$("$YQ" '.rollout.gate | has("http")' "$CONFIG")" == "true" ]]; then
+      gate_path=$("$YQ" '.rollout.gate.http.path // ""' "$CONFIG")
+      if ! [[ "$gate_path" =~ ^/[^[:space:]#]*$ ]]; then
+        ERRORS+=("rollout.gate.http.path must start with '/' and contain no whitespace or fragment, got '$gate_path'")
+      fi
+    fi
+    if [[ "$("$YQ" '.rollout.gate | has("command")' "$CONFIG")" == "true" ]]; then
+      gate_program=$("$YQ" '.rollout.gate.command[0] // ""' "$CONFIG")
+      if [[ -z "$gate_program" ]]; then
+        ERRORS+=("rollout.gate.command must name a program")
+      fi
+    fi
+  fi
+fi
+
+# ── security ─────────────────────────────────────────────────────────────────
+
+if [[ "$("$YQ" 'has("security")' "$CONFIG" 2>/dev/null || echo "false")" == "true" ]]; then
+  SECURITY_KEYS=$("$YQ" '.security | keys | .[]' "$CONFIG" 2>/dev/null || true)
+  for sk in $SECURITY_KEYS; do
+    case "$sk" in
+      binaryAuthorization|breakglassJustification|encryptionKey|keyRevocationAction|keyShutdownHours) ;;
+      *) ERRORS+=("security has unknown key '$sk'. Allowed: binaryAuthorization breakglassJustification encryptionKey keyRevocationAction keyShutdownHours");;
+    esac
+  done
+
+  binauthz=$("$YQ" '.security.binaryAuthorization // ""' "$CONFIG")
+  if [[ -n "$binauthz" && "$binauthz" != "default" ]] && ! [[ "$binauthz" =~ ^projects/[^/]+/platforms/cloudRun/policies/[^/]+$ ]]; then
+    ERRORS+=("security.binaryAuthorization must be 'default' or match 'projects/<project>/platforms/cloudRun/policies/<policy>', got '$binauthz'")
+  fi
+  if [[ -n "$("$YQ" '.security.breakglassJustification // ""' "$CONFIG")" && -z "$binauthz" ]]; then
+    ERRORS+=("security.breakglassJustification requires security.binaryAuthorization")
+  fi
+
+  encryption_key=$("$YQ" '.security.encryptionKey // ""' "$CONFIG")
+  if [[ -n "$encryption_key" ]] && ! [[ "$encryption_key" =~ ^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$ ]]; then
+    ERRORS+=("security.encryptionKey must match 'projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>', got '$encryption_key'")
+  fi
+  revocation_action=$("$YQ" '.security.keyRevocationAction // ""' "$CONFIG")
+  shutdown_hours=$("$YQ" '.security.keyShutdownHours // ""' "$CONFIG")
+  if [[ -n "$revocation_action" ]]; then
+    if [[ "$RESOURCE_TYPE" == "job" ]]; then
+      ERRORS+=("security.keyRevocationAction is only supported for services and workers")
+    fi
+    if [[ -z "$encryption_key" ]]; then
+      ERRORS+=("security.keyRevocationAction requires security.encryptionKey")
+    fi
+    case "$revocation_action" in
+      prevent-new|shutdown) ;;
+      *) ERRORS+=("security.keyRevocationAction must be prevent-new or shutdown, got '$revocation_action'");;
+    esac
+  fi
+  if [[ -n "$shutdown_hours" ]]; then
+    if [[ "$revocation_action" != "shutdown" ]]; then
+      ERRORS+=("security.keyShutdownHours requires security.keyRevocationAction 'shutdown'")
+    fi
+    if ! [[ "$shutdown_hours" =~ ^[0-9]+$ ]] || (( shutdown_hours < 1 )); then
+      ERRORS+=("security.keyShutdownHours must be a positive integer, got '$shutdown_hours'")
+    fi
+  fi
+fi
+
+# ── projectNumber ────────────────────────────────────────────────────────────
+
+PROJECT_NUMBER=$("$YQ" '.projectNumber // ""' "$CONFIG" 2>/dev/null || echo "")
+if [[ -n "$PROJECT_NUMBER" ]] && ! [[ "$PROJECT_NUMBER" =~ ^[0-9]+$ ]]; then
+  ERRORS+=("projectNumber must be a numeric project number, got '$PROJECT_NUMBER'")
+fi
+
 # ── serviceAccount ───────────────────────────────────────────────────────────
 
 SA=$("$YQ" '.serviceAccount // ""' "$CONFIG" 2>/dev/null || echo "")
@@ -2,6 +2,14 @@
 
 load("//cloudrun:common.bzl", "IMAGE_OVERRIDE_PLACEHOLDER")
 
+# Manifest file extension per --format value accepted by the generator.
+_FORMAT_EXTENSIONS = {
+    "knative-yaml": ".yaml",
+    "knative-json": ".json",
+    "v2-json": ".json",
+    "terraform-json": ".tf.json",
+}
+
 def _cloudrun_render_impl(ctx):
     output = ctx.outputs.manifest
     yq = ctx.file._yq
@@ -39,16 +47,43 @@ if [[ -z "$DIGEST" ]]; then
   echo "ERROR: image digest file is empty: {digest_path}" >&2
   exit 1
 fi
-if [[ "$DIGEST" != sha256:* ]]; then
-  echo "ERROR: image digest must start with 'sha256:', got '$DIGEST'" >&2
-  exit 1
-fi
 IMAGE_REF="{image_repo}@$DIGEST"
 """.format(
             digest_path = digest_file.path,
             image_repo = ctx.attr.image_repo,
         )
 
+    # envFrom paths are resolved against the package of the overlay config,
+    # since the merged config is written to a temporary file.
+    inputs.extend(ctx.files.env_files)
+
+    domain_mappings = getattr(ctx.outputs, "domain_mappings", None)
+    domain_mappings_flag = ""
+    if domain_mappings:
+        domain_mappings_flag = '--domain-mappings-output "{}" '.format(domain_mappings.path)
+    load_balancer = getattr(ctx.outputs, "load_balancer", None)
+    load_balancer_flag = ""
+    if load_balancer:
+        load_balancer_flag = '--load-balancer-output "{}" '.format(load_balancer.path)
+    iam_policy = getattr(ctx.outputs, "iam_policy", None)
+    iam_policy_flag = ""
+    if iam_policy:
+        iam_policy_flag = '--iam-policy-output "{}" '.format(iam_policy.path)
+    deploy_settings = getattr(ctx.outputs, "deploy_settings", None)
+    deploy_settings_flag = ""
+    if deploy_settings:
+        deploy_settings_flag = '--deploy-settings-output "{}" '.format(deploy_settings.path)
+    regions = ctx.attr.regions if ctx.attr.regions else [ctx.attr.region]
+
+    project_number_flag = ""
+    if ctx.attr.project_number:
+        project_number_flag = '--project-number "{}" '.format(ctx.attr.project_number)
+
+    policy_flag = ""
+    if ctx.file.policy:
+        inputs.append(ctx.file.policy)
+        policy_flag = '--policy "{}" '.format(ctx.file.policy.path)
+
     cmd = """\
 set -euo pipefail
 MERGED=$(mktemp)
@@ -70,7 +105,12 @@ bash "{validate}" --yq "{yq}" --config "$MERGED" --resource-type "{resource_type
   --image "$IMAGE_REF" \\
   --resource-type "{resource_type}" \\
   --timeout "{timeout}" \\
-  --output "{output}"
+  --format "{format}" \\
+  --environment "{environment}" \\
+  --require-digest={require_digest} \\
+  --config-dir "{config_dir}" \\
+  --regions "{regions}" \\
+  {domain_mappings_flag}{load_balancer_flag}{iam_policy_flag}{deploy_settings_flag}{project_number_flag}{policy_flag}--output "{output}"
 """.format(
         merge_cmd = merge_cmd,
         validate = validate_script.path,
@@ -81,20 +121,58 @@ bash "{validate}" --yq "{yq}" --config "$MERGED" --resource-type "{resource_type
         service_name = ctx.attr.service_name,
         region = ctx.attr.region,
         timeout = ctx.attr.timeout_seconds,
+        format = ctx.attr.format,
+        environment = ctx.attr.environment,
+        require_digest = "true" if ctx.attr.require_digest or has_pinned_image else "false",
+        config_dir = config.dirname,
+        regions = ",".join(regions),
+        domain_mappings_flag = domain_mappings_flag,
+        load_balancer_flag = load_balancer_flag,
+        iam_policy_flag = iam_policy_flag,
+        deploy_settings_flag = deploy_settings_flag,
+        project_number_flag = project_number_flag,
+        policy_flag = policy_flag,
         output = output.path,
     )
 
+    outputs = [output]
+    if domain_mappings:
+        outputs.append(domain_mappings)
+    if load_balancer:
+        outputs.append(load_balancer)
+    if iam_policy:
+        outputs.append(iam_policy)
+    if deploy_settings:
+        outputs.append(deploy_settings)
+
     ctx.actions.run_shell(
         command = cmd,
         inputs = inputs,
         tools = [generate_bin],
-        outputs = [output],
+        outputs = outputs,
         mnemonic = "CloudRunRender",
         progress_message = "Rendering Cloud Run manifest for %s" % ctx.attr.service_name,
     )
 
     return [DefaultInfo(files = depset([output]))]
 
+def _cloudrun_render_outputs(format, resource_type, load_balancer):
+    outputs = {"manifest": "%{name}" + _FORMAT_EXTENSIONS[format]}
+
+    # Services also get their DomainMapping manifests, empty without domains,
+    # and their deploy section, empty without one.
+    if resource_type == "service":
+        outputs["domain_mappings"] = "%{name}.domains.yaml"
+        outputs["deploy_settings"] = "%{name}.deploy.yaml"
+        if load_balancer:
+            outputs["load_balancer"] = "%{name}.lb.tf.json"
+
+    # Services and jobs get the IAM policy of their iam section, empty
+    # without one.
+    if resource_type in ("service", "job"):
+        outputs["iam_policy"] = "%{name}.iam.yaml"
+    return outputs
+
 cloudrun_render = rule(
     implementation = _cloudrun_render_impl,
     attrs = {
@@ -102,11 +180,23 @@ cloudrun_render = rule(
         "base_config": attr.label(allow_single_file = [".yaml", ".yml"]),
         "service_name": attr.string(mandatory = True),
         "region": attr.string(mandatory = True),
+        "regions": attr.string_list(),
         "image": attr.string(default = ""),
         "image_repo": attr.string(default = ""),
         "image_digest": attr.label(allow_single_file = True),
         "timeout_seconds": attr.int(default = 300),
         "resource_type": attr.string(default = "service"),
+        "environment": attr.string(default = ""),
+        # Digest-pinned images (image_digest) always require a digest.
+        "require_digest": attr.bool(default = False),
+        "policy": attr.label(allow_single_file = [".yaml", ".yml"]),
+        "env_files": attr.label_list(allow_files = True),
+        "project_number": attr.string(default = ""),
+        "load_balancer": attr.bool(default = False),
+        "format": attr.string(
+            default = "knative-yaml",
+            values = _FORMAT_EXTENSIONS.keys(),
+        ),
         "_validate": attr.label(
             default = "//cloudrun/private/validation:validate.sh",
             allow_single_file = True,
@@ -121,5 +211,5 @@ cloudrun_render = rule(
             allow_single_file = True,
         ),
     },
-    outputs = {"manifest": "%{name}.yaml"},
+    outputs = _cloudrun_render_outputs,
 )
@@ -18,6 +18,11 @@ def cloudrun_service(
         config_format = "apphosting.*.yaml",
         project_id = "",
         timeout_seconds = 300,
+        policy = None,
+        env_files = [],
+        project_number = "",
+        load_balancer = False,
+        require_digest = False,
         **kwargs):
     """Generates Knative Service manifests and deploy targets from apphosting YAML.
 
@@ -50,6 +55,19 @@ def cloudrun_service(
         config_format: Filename pattern for env extraction. Default: "apphosting.*.yaml".
         project_id: GCP project ID. Use {} for env substitution.
         timeout_seconds: Request timeout. Default: 300.
+        policy: Optional policy rules file evaluated against every rendered
+            manifest. Violations fail the build.
+        env_files: Files referenced by envFrom in the configs, tracked as
+            render inputs. Paths in envFrom are relative to each config.
+        project_number: Optional deploy project number, used for dependency
+            URLs and cross-project secret aliases. Overrides projectNumber in
+            the configs.
+        load_balancer: Also render <name>.render.lb.tf.json, a Terraform
+            JSON global external load balancer with a serverless NEG in
+            every region. Configure its domains under loadBalancer.
+        require_digest: Fail the render unless the image is pinned by
+            digest, rejecting tag-only and placeholder images. Always on
+            with image_repo.
         **kwargs: Additional attributes passed to underlying rules.
     """
 
@@ -101,7 +119,13 @@ def cloudrun_service(
             image = resolved_image,
             image_repo = resolved_image_repo,
             image_digest = image_digest,
+            policy = policy,
+            env_files = env_files,
+            project_number = project_number,
+            require_digest = require_digest,
+            load_balancer = load_balancer,
             timeout_seconds = timeout_seconds,
+            regions = effective_regions,
             resource_type = "service",
             visibility = visibility,
             tags = tags,
@@ -110,6 +134,9 @@ def cloudrun_service(
         cloudrun_deploy_target(
             name = name + ".deploy",
             manifest = ":" + name + ".render",
+            iam_policy = ":" + name + ".render.iam.yaml",
+            domain_mappings = ":" + name + ".render.domains.yaml",
+            deploy_settings = ":" + name + ".render.deploy.yaml",
             project_id = project_id,
             push_executable = push_executable,
             regions = effective_regions,
@@ -130,12 +157,19 @@ def cloudrun_service(
             name = target_name + ".render",
             config = cfg,
             base_config = base_config,
+            environment = env,
             service_name = service_name,
             region = primary_region,
             image = resolved_image,
             image_repo = resolved_image_repo,
             image_digest = image_digest,
+            policy = policy,
+            env_files = env_files,
+            project_number = project_number,
+            require_digest = require_digest,
+            load_balancer = load_balancer,
             timeout_seconds = timeout_seconds,
+            regions = effective_regions,
             resource_type = "service",
             visibility = visibility,
             tags = tags,
@@ -144,6 +178,9 @@ def cloudrun_service(
         cloudrun_deploy_target(
             name = target_name + ".deploy",
             manifest = ":" + target_name + ".render",
+            iam_policy = ":" + target_name + ".render.iam.yaml",
+            domain_mappings = ":" + target_name + ".render.domains.yaml",
+            deploy_settings = ":" + target_name + ".render.deploy.yaml",
             project_id = resolved_project,
             push_executable = push_executable,
             regions = effective_regions,
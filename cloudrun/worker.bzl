@@ -17,6 +17,10 @@ def cloudrun_worker(
         config_format = "apphosting.*.yaml",
         project_id = "",
         timeout_seconds = 300,
+        policy = None,
+        env_files = [],
+        project_number = "",
+        require_digest = False,
         **kwargs):
     """Generates Cloud Run Worker Pool manifests and deploy targets.
 
@@ -39,6 +43,16 @@ def cloudrun_worker(
         config_format: Filename pattern for env extraction.
         project_id: GCP project ID. Use {} for env substitution.
         timeout_seconds: Request timeout. Default: 300.
+        policy: Optional policy rules file evaluated against every rendered
+            manifest. Violations fail the build.
+        env_files: Files referenced by envFrom in the configs, tracked as
+            render inputs. Paths in envFrom are relative to each config.
+        project_number: Optional deploy project number, used for dependency
+            URLs and cross-project secret aliases. Overrides projectNumber in
+            the configs.
+        require_digest: Fail the render unless the image is pinned by
+            digest, rejecting tag-only and placeholder images. Always on
+            with image_repo.
         **kwargs: Additional attributes.
     """
     if not worker_name:
@@ -78,6 +92,10 @@ def cloudrun_worker(
             image = resolved_image,
             image_repo = resolved_image_repo,
             image_digest = image_digest,
+            policy = policy,
+            env_files = env_files,
+            project_number = project_number,
+            require_digest = require_digest,
             timeout_seconds = timeout_seconds,
             resource_type = "worker",
             visibility = visibility,
@@ -106,11 +124,16 @@ def cloudrun_worker(
             name = target_name + ".render",
             config = cfg,
             base_config = base_config,
+            environment = env,
             service_name = worker_name,
             region = region,
             image = resolved_image,
             image_repo = resolved_image_repo,
             image_digest = image_digest,
+            policy = policy,
+            env_files = env_files,
+            project_number = project_number,
+            require_digest = require_digest,
             timeout_seconds = timeout_seconds,
             resource_type = "worker",
             visibility = visibility,
@@ -176,3 +176,81 @@ TESTEOF
             "@yq//:yq",
         ],
     )
+
+def cloudrun_render_failure_test(name, config, image, resource_type = "service", require_digest = False, expected_errors = []):
+    """Tests that the manifest generator fails with expected error messages.
+
+    The generator is run with the flags cloudrun_render passes for the given
+    image and require_digest.
+
+    Args:
+        name: Test target name.
+        config: Label of the config to render.
+        image: Image reference passed to the generator.
+        resource_type: Resource type to render.
+        require_digest: Whether the render requires a digest-pinned image.
+        expected_errors: List of substrings expected in stderr output.
+    """
+    error_checks = ""
+    for err in expected_errors:
+        safe = err.replace("$", "$$").replace("'", "'\\''")
+        error_checks += """
+if ! echo "$$STDERR" | grep -Fq -- '{err}'; then
+    echo "  Missing expected error: '{err}'"
+    echo "  Actual stderr:"
+    echo "$$STDERR"
+    FAIL=1
+fi
+""".format(err = safe)
+
+    native.genrule(
+        name = name + "_gen_test",
+        outs = [name + "_test.sh"],
+        srcs = [config],
+        tools = ["//cloudrun/private/resource/cmd:resource_manifest"],
+        cmd = """
+cat > $@ <<'TESTEOF'
+#!/bin/bash
+set -euo pipefail
+GENERATE="$(rootpath //cloudrun/private/resource/cmd:resource_manifest)"
+CONFIG="$(rootpath {config})"
+OUTPUT="$${{TEST_TMPDIR:-$$(mktemp -d)}}/manifest"
+FAIL=0
+
+# Rendering should fail (exit non-zero)
+if STDERR=$$($$GENERATE --config $$CONFIG --service-name test --region us-central1 \\
+    --image '{image}' --resource-type {resource_type} \\
+    --require-digest={require_digest} --output $$OUTPUT 2>&1); then
+    echo "FAIL: Rendering should have failed but succeeded."
+    exit 1
+fi
+
+echo "Rendering correctly failed. Checking error messages..."
+
+{error_checks}
+
+if [ $$FAIL -ne 0 ]; then
+    echo "FAIL: Not all expected errors were present."
+    exit 1
+fi
+
+echo "PASS: Rendering failed with all expected errors."
+TESTEOF
+""".format(
+            config = config,
+            image = image,
+            resource_type = resource_type,
+            require_digest = "true" if require_digest else "false",
+            error_checks = error_checks,
+        ),
+        executable = True,
+    )
+
+    sh_test(
+        name = name,
+        srcs = [":" + name + "_gen_test"],
+        data = [
+            config,
+            "//cloudrun/private/resource/cmd:resource_manifest",
+        ],
+    )